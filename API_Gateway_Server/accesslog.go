@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// requestIDMetaKey is the Kitex metadata key the request ID is forwarded to the backend under.
+const requestIDMetaKey = "request_id"
+
+// requestIDKey and errorClassKey are the keys of the request ID and
+// error class stored in the app.RequestContext of a request.
+const requestIDKey = "requestID"
+const errorClassKey = "errorClass"
+
+var accessLogger *log.Logger
+
+// accessLogConfig selects where the access log is written: "stdout", "stderr" or a file path.
+// No access log is written when Output is empty.
+type accessLogConfig struct {
+	Output string `json:"output"`
+}
+
+// accessRecord is the access log line of one request.
+type accessRecord struct {
+	Time       string  `json:"time"`
+	RequestID  string  `json:"request_id"`
+	HTTPMethod string  `json:"http_method"`
+	Path       string  `json:"path"`
+	Service    string  `json:"service"`
+	Method     string  `json:"method"`
+	Instance   string  `json:"instance"`
+	Status     int     `json:"status"`
+	LatencyMS  float64 `json:"latency_ms"`
+	BytesIn    int     `json:"bytes_in"`
+	BytesOut   int     `json:"bytes_out"`
+	ErrorClass string  `json:"error_class,omitempty"`
+	ClientIP   string  `json:"client_ip"`
+}
+
+type accessRecordKey struct{}
+
+// newAccessLogger creates the logger writing the access log to the output selected by cfg.
+// It returns nil if the access log is disabled, and an error if the output file cannot be opened.
+func newAccessLogger(cfg accessLogConfig) (*log.Logger, error) {
+	return openLog(cfg.Output)
+}
+
+// openLog creates a logger writing to output: "stdout", "stderr" or a file path, appended to.
+// It returns nil if output is empty, and an error if the file cannot be opened.
+func openLog(output string) (*log.Logger, error) {
+	var w io.Writer
+	switch output {
+	case "":
+		return nil, nil
+	case "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return log.New(w, "", 0), nil
+}
+
+// newRequestID generates a random request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the ID of the request in ctx.
+func requestID(ctx *app.RequestContext) string {
+	return ctx.GetString(requestIDKey)
+}
+
+// setErrorClass records the class of the error that failed the request in ctx, for the access log.
+func setErrorClass(ctx *app.RequestContext, class string) {
+	ctx.Set(errorClassKey, class)
+}
+
+// forwardRequestID returns c carrying the ID of the request in ctx in the metadata sent to the backend.
+func forwardRequestID(c context.Context, ctx *app.RequestContext) context.Context {
+	id := requestID(ctx)
+	if id == "" {
+		return c
+	}
+	return metainfo.WithValue(c, requestIDMetaKey, id)
+}
+
+// accessLog is the middleware assigning every request an ID and writing its access log line.
+// The ID is taken from the X-Request-ID header or generated, and echoed in the response.
+func accessLog(c context.Context, ctx *app.RequestContext) {
+	start := time.Now()
+
+	id := string(ctx.GetHeader(requestIDHeader))
+	if id == "" || len(id) > 128 {
+		id = newRequestID()
+	}
+	ctx.Set(requestIDKey, id)
+
+	record := &accessRecord{RequestID: id}
+	c = context.WithValue(c, accessRecordKey{}, record)
+
+	ctx.Next(c)
+
+	ctx.Header(requestIDHeader, id)
+	if accessLogger == nil {
+		return
+	}
+
+	record.Time = start.UTC().Format(time.RFC3339Nano)
+	record.HTTPMethod = string(ctx.Method())
+	record.Path = string(ctx.Path())
+	record.Service, record.Method = routeOf(ctx)
+	record.Status = ctx.Response.StatusCode()
+	record.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	record.BytesIn = len(rawBody(ctx))
+	record.BytesOut = len(ctx.Response.Body())
+	record.ErrorClass = ctx.GetString(errorClassKey)
+	if record.ErrorClass == "" && record.Status >= 400 {
+		record.ErrorClass = statusClass(record.Status)
+	}
+	record.ClientIP = ctx.ClientIP()
+
+	line, err := json.Marshal(record)
+	if err == nil {
+		accessLogger.Println(string(line))
+	}
+}
+
+// accessLogTracer is the Kitex client tracer recording the instance a generic call was sent to in the access log.
+type accessLogTracer struct{}
+
+// Start does nothing, the instance is only known once the call finishes.
+func (accessLogTracer) Start(c context.Context) context.Context {
+	return c
+}
+
+// Finish records the address of the instance called in the access log record of c.
+func (accessLogTracer) Finish(c context.Context) {
+	record, ok := c.Value(accessRecordKey{}).(*accessRecord)
+	if !ok {
+		return
+	}
+	if ri := rpcinfo.GetRPCInfo(c); ri != nil && ri.To().Address() != nil {
+		record.Instance = ri.To().Address().String()
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLog_GeneratesRequestID(t *testing.T) {
+	accessLogger = nil
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodGet, "/ServiceA/methodA", nil),
+	}
+
+	accessLog(context.Background(), ctx)
+
+	id := string(ctx.Response.Header.Peek(requestIDHeader))
+	assert.Len(t, id, 32)
+	assert.Equal(t, id, requestID(ctx))
+}
+
+func TestAccessLog_WritesRecord(t *testing.T) {
+	var buf bytes.Buffer
+	accessLogger = log.New(&buf, "", 0)
+	defer func() { accessLogger = nil }()
+
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, "/ServiceA/methodB", nil),
+	}
+	ctx.Request.SetHeader(requestIDHeader, "abc-123")
+	ctx.Request.SetBodyString(`{"userId":"1"}`)
+	ctx.SetHandlers(app.HandlersChain{accessLog, func(c context.Context, ctx *app.RequestContext) {
+		setErrorClass(ctx, "generic_call")
+		ctx.String(http.StatusInternalServerError, "Error making generic call")
+	}})
+
+	accessLog(context.Background(), ctx)
+
+	assert.Equal(t, "abc-123", string(ctx.Response.Header.Peek(requestIDHeader)))
+
+	var record accessRecord
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "abc-123", record.RequestID)
+	assert.Equal(t, "ServiceA", record.Service)
+	assert.Equal(t, "methodB", record.Method)
+	assert.Equal(t, http.StatusInternalServerError, record.Status)
+	assert.Equal(t, "generic_call", record.ErrorClass)
+	assert.Equal(t, 14, record.BytesIn)
+	assert.Equal(t, len("Error making generic call"), record.BytesOut)
+}
+
+func TestForwardRequestID(t *testing.T) {
+	ctx := &app.RequestContext{}
+	ctx.Set(requestIDKey, "abc-123")
+
+	c := forwardRequestID(context.Background(), ctx)
+
+	id, ok := metainfo.GetValue(c, requestIDMetaKey)
+	assert.True(t, ok)
+	assert.Equal(t, "abc-123", id)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// adminConfig protects the /_admin endpoints with a bearer token.
+// The endpoints are disabled when Token is empty.
+type adminConfig struct {
+	Token string `json:"token"`
+}
+
+// adminAuth is the middleware requiring the admin token on the /_admin endpoints.
+func adminAuth(c context.Context, ctx *app.RequestContext) {
+	if config.Admin.Token == "" {
+		ctx.AbortWithMsg("Admin endpoints are disabled", http.StatusNotFound)
+		setErrorClass(ctx, "admin_disabled")
+		return
+	}
+
+	token := bearerToken(ctx)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(config.Admin.Token)) != 1 {
+		ctx.AbortWithMsg("Invalid admin token", http.StatusUnauthorized)
+		setErrorClass(ctx, "unauthenticated")
+		return
+	}
+	ctx.Next(c)
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// defaultAPIKeyQueryParam is the query parameter an API key is read from when it is not sent in the X-API-Key header.
+const defaultAPIKeyQueryParam = "api_key"
+
+// apiKeyOwnerKey is the key of the owner of the API key of a request stored in its app.RequestContext.
+const apiKeyOwnerKey = "apiKeyOwner"
+
+// apiKeyRolesKey is the key of the roles of the API key of a request stored in its app.RequestContext.
+const apiKeyRolesKey = "apiKeyRoles"
+
+// apiKeyOwnerMetaKey is the Kitex metadata key the owner of the API key is forwarded to the backend under.
+const apiKeyOwnerMetaKey = "api_key_owner"
+
+var keys apiKeyStore
+
+var errUnknownAPIKey = errors.New("unknown API key")
+
+// apiKeyConfig configures API key authentication. Requests to the routes matching
+// one of Routes must carry a valid key, in the X-API-Key header or the QueryParam
+// query parameter. The keys are kept in File.
+type apiKeyConfig struct {
+	File       string   `json:"file"`
+	QueryParam string   `json:"queryParam"`
+	Routes     []string `json:"routes"`
+}
+
+// apiKey is an API key as kept in an apiKeyStore. Only the SHA-256 hash of the secret is kept.
+// The key may call the routes matching one of Routes, all of them when Routes is empty,
+// up to Quota. Roles are the roles granted to its owner by the authorization policy.
+type apiKey struct {
+	ID      string      `json:"id"`
+	Hash    string      `json:"hash,omitempty"`
+	Owner   string      `json:"owner"`
+	Roles   []string    `json:"roles,omitempty"`
+	Routes  []string    `json:"routes,omitempty"`
+	Quota   apiKeyQuota `json:"quota"`
+	Created time.Time   `json:"created"`
+	Rotated time.Time   `json:"rotated"`
+	Revoked bool        `json:"revoked"`
+}
+
+// apiKeyQuota allows Limit requests per Period. A zero Limit is unlimited.
+type apiKeyQuota struct {
+	Limit  int      `json:"limit,omitempty"`
+	Period duration `json:"period,omitempty"`
+}
+
+// allows reports whether k may call method of serviceName.
+func (k apiKey) allows(serviceName, method string) bool {
+	if len(k.Routes) == 0 {
+		return true
+	}
+	for _, pattern := range k.Routes {
+		if matchRoute(pattern, serviceName, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyStore keeps the API keys. Deployments may plug in a database;
+// fileKeyStore keeps them in a JSON file.
+type apiKeyStore interface {
+	// Get returns the key with the given id and whether it exists.
+	Get(id string) (apiKey, bool, error)
+	// Put creates or replaces the key with the ID of k.
+	Put(k apiKey) error
+	// List returns every key, sorted by ID.
+	List() ([]apiKey, error)
+}
+
+// fileKeyStore is an apiKeyStore kept in a JSON file, rewritten on every change.
+type fileKeyStore struct {
+	mu   sync.Mutex
+	file string
+	keys map[string]apiKey
+}
+
+// newFileKeyStore creates an apiKeyStore kept in file, loading the keys it holds.
+// A missing file results in an empty store.
+// It returns the store and an error if the file cannot be read or decoded.
+func newFileKeyStore(file string) (*fileKeyStore, error) {
+	s := &fileKeyStore{file: file, keys: make(map[string]apiKey)}
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []apiKey
+	err = json.Unmarshal(content, &list)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range list {
+		s.keys[k.ID] = k
+	}
+	return s, nil
+}
+
+// Get returns the key with the given id and whether it exists.
+func (s *fileKeyStore) Get(id string) (apiKey, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	return k, ok, nil
+}
+
+// Put creates or replaces the key with the ID of k and rewrites the file.
+// The previous key is kept if the file cannot be written.
+func (s *fileKeyStore) Put(k apiKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.keys[k.ID]
+	s.keys[k.ID] = k
+	err := s.save()
+	if err != nil {
+		if existed {
+			s.keys[k.ID] = previous
+		} else {
+			delete(s.keys, k.ID)
+		}
+	}
+	return err
+}
+
+// List returns every key, sorted by ID.
+func (s *fileKeyStore) List() ([]apiKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sorted(), nil
+}
+
+// sorted returns the keys of s sorted by ID. The caller must hold s.mu.
+func (s *fileKeyStore) sorted() []apiKey {
+	list := make([]apiKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		list = append(list, k)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// save atomically replaces the file with the keys of s. The caller must hold s.mu.
+func (s *fileKeyStore) save() error {
+	content, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), ".apikeys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Chmod(0600)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file)
+}
+
+// hashSecret returns the hex SHA-256 hash of an API key.
+func hashSecret(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIKeySecret generates the API key of the key with the given id, written as "id.secret".
+// It returns the key and its hash.
+func newAPIKeySecret(id string) (string, string) {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	key := id + "." + base64.RawURLEncoding.EncodeToString(b)
+	return key, hashSecret(key)
+}
+
+// presentedAPIKey returns the API key sent in the X-API-Key header of ctx or, failing that, in its query.
+func presentedAPIKey(ctx *app.RequestContext) string {
+	if key := string(ctx.GetHeader(apiKeyHeader)); key != "" {
+		return key
+	}
+	param := config.APIKeys.QueryParam
+	if param == "" {
+		param = defaultAPIKeyQueryParam
+	}
+	return ctx.Query(param)
+}
+
+// lookupAPIKey returns the live key of store matching the API key presented.
+// It returns errUnknownAPIKey if the key does not exist, does not match or is revoked.
+func lookupAPIKey(store apiKeyStore, presented string) (apiKey, error) {
+	id, _, ok := strings.Cut(presented, ".")
+	if !ok {
+		return apiKey{}, errUnknownAPIKey
+	}
+	k, ok, err := store.Get(id)
+	if err != nil {
+		return apiKey{}, err
+	}
+	if !ok || k.Revoked || subtle.ConstantTimeCompare([]byte(hashSecret(presented)), []byte(k.Hash)) != 1 {
+		return apiKey{}, errUnknownAPIKey
+	}
+	return k, nil
+}
+
+// takeQuota counts one request against the quota of k in kv for the current period.
+// It reports whether the request is within the quota.
+func takeQuota(c context.Context, kv kvStore, k apiKey, now time.Time) (bool, error) {
+	period := time.Duration(k.Quota.Period)
+	if k.Quota.Limit <= 0 || period <= 0 {
+		return true, nil
+	}
+
+	window := now.UnixNano() / int64(period)
+	key := fmt.Sprintf("quota/%s/%d", k.ID, window)
+	for i := 0; i < casAttempts; i++ {
+		old, ok, err := kv.Get(c, key)
+		if err != nil {
+			return false, err
+		}
+		used := 0
+		if ok {
+			used, err = strconv.Atoi(string(old))
+			if err != nil {
+				return false, err
+			}
+		} else {
+			old = nil
+		}
+		if used >= k.Quota.Limit {
+			return false, nil
+		}
+		stored, err := kv.CompareAndSwap(c, key, old, []byte(strconv.Itoa(used+1)), period)
+		if err != nil {
+			return false, err
+		}
+		if stored {
+			return true, nil
+		}
+	}
+	return false, errBucketContention
+}
+
+// apiKeyAuth is the middleware requiring a valid API key on the routes of the API key configuration.
+// Requests without a valid key are rejected with 401, keys not allowed on the route with 403
+// and keys over their quota with 429, or 503 when their quota cannot be counted. The owner and roles of the key are stored in ctx,
+// and the owner is forwarded to the backend.
+func apiKeyAuth(c context.Context, ctx *app.RequestContext) {
+	serviceName, method := routeOf(ctx)
+
+	required := false
+	for _, pattern := range config.APIKeys.Routes {
+		if matchRoute(pattern, serviceName, method) {
+			required = true
+			break
+		}
+	}
+	if !required || keys == nil {
+		ctx.Next(c)
+		return
+	}
+
+	presented := presentedAPIKey(ctx)
+	if presented == "" {
+		ctx.AbortWithMsg("Missing API key", http.StatusUnauthorized)
+		setErrorClass(ctx, "unauthenticated")
+		return
+	}
+
+	k, err := lookupAPIKey(keys, presented)
+	if errors.Is(err, errUnknownAPIKey) {
+		ctx.AbortWithMsg("Invalid API key", http.StatusUnauthorized)
+		setErrorClass(ctx, "unauthenticated")
+		return
+	}
+	if err != nil {
+		ctx.AbortWithMsg("Internal Server Error", http.StatusInternalServerError)
+		setErrorClass(ctx, "api_key_store")
+		return
+	}
+
+	if !k.allows(serviceName, method) {
+		ctx.AbortWithMsg("API key not allowed to call this method", http.StatusForbidden)
+		setErrorClass(ctx, "forbidden")
+		return
+	}
+
+	ok, err := takeQuota(c, sharedStore, k, time.Now())
+	if err != nil {
+		ctx.AbortWithMsg("API key quota unavailable", http.StatusServiceUnavailable)
+		setErrorClass(ctx, "quota_store")
+		return
+	}
+	if !ok {
+		ctx.AbortWithMsg("API key quota exceeded", http.StatusTooManyRequests)
+		setErrorClass(ctx, "quota_exceeded")
+		return
+	}
+
+	ctx.Set(apiKeyOwnerKey, k.Owner)
+	ctx.Set(apiKeyRolesKey, k.Roles)
+	ctx.Next(metainfo.WithValue(c, apiKeyOwnerMetaKey, k.Owner))
+}
+
+// apiKeyRequest is the body of a request creating an API key.
+type apiKeyRequest struct {
+	Owner  string      `json:"owner"`
+	Roles  []string    `json:"roles"`
+	Routes []string    `json:"routes"`
+	Quota  apiKeyQuota `json:"quota"`
+}
+
+// apiKeyResponse is the response to a request creating or rotating an API key,
+// the only time the key itself is returned.
+type apiKeyResponse struct {
+	apiKey
+	Key string `json:"key"`
+}
+
+// keysEnabled reports whether API keys are configured, answering ctx with 404 if they are not.
+func keysEnabled(ctx *app.RequestContext) bool {
+	if keys == nil {
+		ctx.SetStatusCode(http.StatusNotFound)
+		ctx.String(consts.StatusNotFound, "API keys are disabled")
+		return false
+	}
+	return true
+}
+
+// listAPIKeys answers with every API key, without their hashes.
+func listAPIKeys(_ context.Context, ctx *app.RequestContext) {
+	if !keysEnabled(ctx) {
+		return
+	}
+	list, err := keys.List()
+	if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.String(consts.StatusInternalServerError, "Fail to list API keys")
+		return
+	}
+	for i := range list {
+		list[i].Hash = ""
+	}
+	ctx.JSON(consts.StatusOK, list)
+}
+
+// createAPIKey creates the API key described by the request body and answers with it.
+func createAPIKey(_ context.Context, ctx *app.RequestContext) {
+	if !keysEnabled(ctx) {
+		return
+	}
+	var req apiKeyRequest
+	err := json.Unmarshal(ctx.Request.Body(), &req)
+	if err != nil || req.Owner == "" {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.String(consts.StatusBadRequest, "Invalid API key, expected an owner")
+		return
+	}
+
+	id := newRequestID()[:16]
+	key, hash := newAPIKeySecret(id)
+	k := apiKey{
+		ID:      id,
+		Hash:    hash,
+		Owner:   req.Owner,
+		Roles:   req.Roles,
+		Routes:  req.Routes,
+		Quota:   req.Quota,
+		Created: time.Now().UTC(),
+	}
+	err = keys.Put(k)
+	if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.String(consts.StatusInternalServerError, "Fail to store API key")
+		return
+	}
+
+	k.Hash = ""
+	ctx.JSON(consts.StatusCreated, apiKeyResponse{apiKey: k, Key: key})
+}
+
+// updateAPIKey applies update to the live API key named by the id path parameter of ctx and stores it.
+// It answers ctx with an error and returns false if the key cannot be updated.
+func updateAPIKey(ctx *app.RequestContext, update func(*apiKey)) (apiKey, bool) {
+	if !keysEnabled(ctx) {
+		return apiKey{}, false
+	}
+	k, ok, err := keys.Get(ctx.Param("id"))
+	if err == nil && (!ok || k.Revoked) {
+		ctx.SetStatusCode(http.StatusNotFound)
+		ctx.String(consts.StatusNotFound, "API key not found")
+		return apiKey{}, false
+	}
+	if err == nil {
+		update(&k)
+		err = keys.Put(k)
+	}
+	if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.String(consts.StatusInternalServerError, "Fail to store API key")
+		return apiKey{}, false
+	}
+	return k, true
+}
+
+// rotateAPIKey replaces the secret of an API key, which stops accepting its previous key,
+// and answers with the new key.
+func rotateAPIKey(_ context.Context, ctx *app.RequestContext) {
+	var key string
+	k, ok := updateAPIKey(ctx, func(k *apiKey) {
+		key, k.Hash = newAPIKeySecret(k.ID)
+		k.Rotated = time.Now().UTC()
+	})
+	if !ok {
+		return
+	}
+	k.Hash = ""
+	ctx.JSON(consts.StatusOK, apiKeyResponse{apiKey: k, Key: key})
+}
+
+// revokeAPIKey revokes an API key, which is kept in the store for auditing.
+func revokeAPIKey(_ context.Context, ctx *app.RequestContext) {
+	_, ok := updateAPIKey(ctx, func(k *apiKey) {
+		k.Revoked = true
+	})
+	if !ok {
+		return
+	}
+	ctx.SetStatusCode(http.StatusNoContent)
+}
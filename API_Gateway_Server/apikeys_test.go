@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/cloudwego/hertz/pkg/route/param"
+	"github.com/stretchr/testify/assert"
+)
+
+func useAPIKeys(t *testing.T, routes ...string) *fileKeyStore {
+	store, err := newFileKeyStore(filepath.Join(t.TempDir(), "apikeys.json"))
+	assert.NoError(t, err)
+	config = &gatewayConfig{APIKeys: apiKeyConfig{Routes: routes}, Admin: adminConfig{Token: "admin"}}
+	keys = store
+	previous := sharedStore
+	sharedStore = newMemoryKV()
+	t.Cleanup(func() {
+		config = &gatewayConfig{}
+		keys = nil
+		sharedStore = previous
+	})
+	return store
+}
+
+func newAPIKeyContext(path, apiKey string) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, path, nil),
+	}
+	if apiKey != "" {
+		ctx.Request.SetHeader(apiKeyHeader, apiKey)
+	}
+	return ctx
+}
+
+func newAdminContext(method, id, body string) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(method, "/_admin/keys", nil),
+	}
+	ctx.Request.SetBodyString(body)
+	if id != "" {
+		ctx.Params = append(ctx.Params, param.Param{Key: "id", Value: id})
+	}
+	return ctx
+}
+
+func createTestKey(t *testing.T, body string) apiKeyResponse {
+	ctx := newAdminContext(http.MethodPost, "", body)
+	createAPIKey(context.Background(), ctx)
+	assert.Equal(t, http.StatusCreated, ctx.Response.StatusCode())
+
+	var resp apiKeyResponse
+	assert.NoError(t, json.Unmarshal(ctx.Response.Body(), &resp))
+	return resp
+}
+
+func TestFileKeyStore_PersistsKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "apikeys.json")
+	store, err := newFileKeyStore(file)
+	assert.NoError(t, err)
+
+	key, hash := newAPIKeySecret("abc")
+	assert.NoError(t, store.Put(apiKey{ID: "abc", Hash: hash, Owner: "partner", Quota: apiKeyQuota{Limit: 5, Period: duration(time.Hour)}}))
+
+	reloaded, err := newFileKeyStore(file)
+	assert.NoError(t, err)
+	k, err := lookupAPIKey(reloaded, key)
+	assert.NoError(t, err)
+	assert.Equal(t, "partner", k.Owner)
+	assert.Equal(t, duration(time.Hour), k.Quota.Period)
+
+	_, err = lookupAPIKey(reloaded, "abc.wrong")
+	assert.ErrorIs(t, err, errUnknownAPIKey)
+}
+
+func TestAPIKeyAuth_RejectsMissingInvalidAndForbiddenKeys(t *testing.T) {
+	useAPIKeys(t, "ServiceA/*")
+	resp := createTestKey(t, `{"owner": "partner", "routes": ["ServiceA/methodA"]}`)
+
+	ctx := newAPIKeyContext("/ServiceA/methodA", "")
+	apiKeyAuth(context.Background(), ctx)
+	assert.Equal(t, http.StatusUnauthorized, ctx.Response.StatusCode())
+
+	ctx = newAPIKeyContext("/ServiceA/methodA", resp.ID+".wrong")
+	apiKeyAuth(context.Background(), ctx)
+	assert.Equal(t, http.StatusUnauthorized, ctx.Response.StatusCode())
+
+	ctx = newAPIKeyContext("/ServiceA/methodC", resp.Key)
+	apiKeyAuth(context.Background(), ctx)
+	assert.Equal(t, http.StatusForbidden, ctx.Response.StatusCode())
+
+	ctx = newAPIKeyContext("/ServiceA/methodA?api_key="+resp.Key, "")
+	apiKeyAuth(context.Background(), ctx)
+	assert.False(t, ctx.IsAborted())
+	assert.Equal(t, "partner", ctx.GetString(apiKeyOwnerKey))
+
+	ctx = newAPIKeyContext("/ServiceB/methodA", "")
+	apiKeyAuth(context.Background(), ctx)
+	assert.False(t, ctx.IsAborted())
+}
+
+func TestAPIKeyAuth_EnforcesQuota(t *testing.T) {
+	useAPIKeys(t, "*/*")
+	resp := createTestKey(t, `{"owner": "partner", "quota": {"limit": 2, "period": "1h"}}`)
+
+	for i := 0; i < 2; i++ {
+		ctx := newAPIKeyContext("/ServiceA/methodA", resp.Key)
+		apiKeyAuth(context.Background(), ctx)
+		assert.False(t, ctx.IsAborted())
+	}
+
+	ctx := newAPIKeyContext("/ServiceA/methodA", resp.Key)
+	apiKeyAuth(context.Background(), ctx)
+	assert.Equal(t, http.StatusTooManyRequests, ctx.Response.StatusCode())
+}
+
+// failingKV is a kvStore whose every operation fails.
+type failingKV struct{}
+
+func (failingKV) Get(context.Context, string) ([]byte, bool, error) {
+	return nil, false, errors.New("store unavailable")
+}
+
+func (failingKV) CompareAndSwap(context.Context, string, []byte, []byte, time.Duration) (bool, error) {
+	return false, errors.New("store unavailable")
+}
+
+func (failingKV) Delete(context.Context, string) error {
+	return errors.New("store unavailable")
+}
+
+func TestAPIKeyAuth_QuotaStoreFailure(t *testing.T) {
+	useAPIKeys(t, "*/*")
+	limited := createTestKey(t, `{"owner": "partner", "quota": {"limit": 2, "period": "1h"}}`)
+	unlimited := createTestKey(t, `{"owner": "internal"}`)
+	sharedStore = failingKV{}
+
+	ctx := newAPIKeyContext("/ServiceA/methodA", limited.Key)
+	apiKeyAuth(context.Background(), ctx)
+	assert.Equal(t, http.StatusServiceUnavailable, ctx.Response.StatusCode(), "a quota that cannot be counted is not skipped")
+
+	ctx = newAPIKeyContext("/ServiceA/methodA", unlimited.Key)
+	apiKeyAuth(context.Background(), ctx)
+	assert.False(t, ctx.IsAborted())
+}
+
+func TestAPIKeyAdmin_RotateAndRevoke(t *testing.T) {
+	store := useAPIKeys(t, "*/*")
+	resp := createTestKey(t, `{"owner": "partner"}`)
+	assert.Empty(t, resp.Hash)
+
+	ctx := newAdminContext(http.MethodPost, resp.ID, "")
+	rotateAPIKey(context.Background(), ctx)
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	var rotated apiKeyResponse
+	assert.NoError(t, json.Unmarshal(ctx.Response.Body(), &rotated))
+	assert.NotEqual(t, resp.Key, rotated.Key)
+
+	_, err := lookupAPIKey(store, resp.Key)
+	assert.ErrorIs(t, err, errUnknownAPIKey)
+	_, err = lookupAPIKey(store, rotated.Key)
+	assert.NoError(t, err)
+
+	ctx = newAdminContext(http.MethodDelete, resp.ID, "")
+	revokeAPIKey(context.Background(), ctx)
+	assert.Equal(t, http.StatusNoContent, ctx.Response.StatusCode())
+	_, err = lookupAPIKey(store, rotated.Key)
+	assert.ErrorIs(t, err, errUnknownAPIKey)
+
+	ctx = newAdminContext(http.MethodDelete, "unknown", "")
+	revokeAPIKey(context.Background(), ctx)
+	assert.Equal(t, http.StatusNotFound, ctx.Response.StatusCode())
+
+	ctx = newAdminContext(http.MethodGet, "", "")
+	listAPIKeys(context.Background(), ctx)
+	var list []apiKey
+	assert.NoError(t, json.Unmarshal(ctx.Response.Body(), &list))
+	assert.Len(t, list, 1)
+	assert.True(t, list[0].Revoked)
+	assert.Empty(t, list[0].Hash)
+}
+
+func TestAdminAuth_RequiresToken(t *testing.T) {
+	useAPIKeys(t)
+
+	ctx := newAdminContext(http.MethodGet, "", "")
+	adminAuth(context.Background(), ctx)
+	assert.Equal(t, http.StatusUnauthorized, ctx.Response.StatusCode())
+
+	ctx = newAdminContext(http.MethodGet, "", "")
+	ctx.Request.SetHeader("Authorization", "Bearer admin")
+	adminAuth(context.Background(), ctx)
+	assert.False(t, ctx.IsAborted())
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"API_Gateway_Server/hmacsign"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// defaultJobs, defaultJobTTL and defaultCallbackTimeout apply when the asynchronous calls are not configured further:
+// the jobs held by the in-memory job store, the time completed jobs are kept and the time a callback may take.
+const (
+	defaultJobs            = 1000
+	defaultJobTTL          = time.Hour
+	defaultCallbackTimeout = 10 * time.Second
+)
+
+// preferHeader carries the preference of the client for an asynchronous call, asyncPreference,
+// and callbackURLHeader the URL notified when it completes.
+const (
+	preferHeader      = "Prefer"
+	asyncPreference   = "respond-async"
+	callbackURLHeader = "X-Callback-URL"
+)
+
+// callbackClientID is the client ID of the gateway in the signature of its callbacks.
+const callbackClientID = "gateway"
+
+// The statuses of a job.
+const (
+	jobPending   = "pending"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+var errTooManyJobs = errors.New("too many jobs")
+
+var jobs jobStore
+
+// callbackClient posts the completed jobs to their callback URLs. It does not follow redirects,
+// which could lead a notification to a URL that is not allowed.
+var callbackClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// asyncConfig lets the methods matching Routes be called asynchronously with the Prefer: respond-async header.
+// The in-memory job store holds up to MaxJobs jobs, keeping completed jobs for TTL. A call may be given a callback
+// URL starting with one of CallbackURLs, notified of the job within CallbackTimeout and signed with CallbackSecret
+// when set.
+type asyncConfig struct {
+	Routes          []string `json:"routes"`
+	MaxJobs         int      `json:"maxJobs"`
+	TTL             duration `json:"ttl"`
+	CallbackURLs    []string `json:"callbackURLs"`
+	CallbackSecret  string   `json:"callbackSecret"`
+	CallbackTimeout duration `json:"callbackTimeout"`
+}
+
+// job is an asynchronous call, holding its result once it completes.
+type job struct {
+	ID        string       `json:"id"`
+	Status    string       `json:"status"`
+	Service   string       `json:"service"`
+	Method    string       `json:"method"`
+	Created   time.Time    `json:"created"`
+	Completed *time.Time   `json:"completed,omitempty"`
+	Result    *batchResult `json:"result,omitempty"`
+	Callback  *jobCallback `json:"callback,omitempty"`
+	Subject   string       `json:"subject,omitempty"`
+}
+
+// jobCallback is the URL notified when a job completes, with the HTTP status answering the notification
+// or the error that failed it.
+type jobCallback struct {
+	URL    string `json:"url"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// jobStore keeps the jobs. Deployments may plug in an external store shared by every gateway instance;
+// memoryJobStore stands in for it in a single process and in tests.
+type jobStore interface {
+	// Put stores j, replacing the job of the same ID. It returns errTooManyJobs if the store is full.
+	Put(c context.Context, j job) error
+	// Get returns the live job of id and whether it exists.
+	Get(c context.Context, id string) (job, bool, error)
+}
+
+// memoryJobStore is an in-process jobStore holding up to maxJobs jobs, completed jobs expiring after ttl.
+// When it is full, the oldest completed job is evicted to make room for a new one.
+type memoryJobStore struct {
+	mu      sync.Mutex
+	maxJobs int
+	ttl     time.Duration
+	order   *list.List
+	jobs    map[string]*list.Element
+}
+
+// newMemoryJobStore creates an empty memoryJobStore holding up to maxJobs jobs, completed jobs expiring after ttl.
+func newMemoryJobStore(maxJobs int, ttl time.Duration) *memoryJobStore {
+	if maxJobs <= 0 {
+		maxJobs = defaultJobs
+	}
+	if ttl <= 0 {
+		ttl = defaultJobTTL
+	}
+	return &memoryJobStore{maxJobs: maxJobs, ttl: ttl, order: list.New(), jobs: make(map[string]*list.Element)}
+}
+
+// expired reports whether j completed more than the ttl of m before now.
+func (m *memoryJobStore) expired(j job, now time.Time) bool {
+	return j.Completed != nil && now.After(j.Completed.Add(m.ttl))
+}
+
+// remove removes elem from m. The caller must hold m.mu.
+func (m *memoryJobStore) remove(elem *list.Element) {
+	m.order.Remove(elem)
+	delete(m.jobs, elem.Value.(job).ID)
+}
+
+// Put stores j, replacing the job of the same ID. A new job evicts the expired jobs, then the oldest completed job
+// if m is full. It returns errTooManyJobs if every job held is pending.
+func (m *memoryJobStore) Put(_ context.Context, j job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.jobs[j.ID]; ok {
+		elem.Value = j
+		return nil
+	}
+
+	now := time.Now()
+	var oldestCompleted *list.Element
+	for elem := m.order.Back(); elem != nil; {
+		prev := elem.Prev()
+		if m.expired(elem.Value.(job), now) {
+			m.remove(elem)
+		} else if oldestCompleted == nil && elem.Value.(job).Completed != nil {
+			oldestCompleted = elem
+		}
+		elem = prev
+	}
+	if m.order.Len() >= m.maxJobs {
+		if oldestCompleted == nil {
+			return errTooManyJobs
+		}
+		m.remove(oldestCompleted)
+	}
+	m.jobs[j.ID] = m.order.PushFront(j)
+	return nil
+}
+
+// Get returns the live job of id and whether it exists.
+func (m *memoryJobStore) Get(_ context.Context, id string) (job, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.jobs[id]
+	if !ok {
+		return job{}, false, nil
+	}
+	if m.expired(elem.Value.(job), time.Now()) {
+		m.remove(elem)
+		return job{}, false, nil
+	}
+	return elem.Value.(job), true, nil
+}
+
+// prefersAsync reports whether the request in ctx prefers to be answered asynchronously.
+func prefersAsync(ctx *app.RequestContext) bool {
+	for _, preference := range strings.Split(string(ctx.GetHeader(preferHeader)), ",") {
+		token, _, _ := strings.Cut(preference, ";")
+		if strings.EqualFold(strings.TrimSpace(token), asyncPreference) {
+			return true
+		}
+	}
+	return false
+}
+
+// asyncRoute reports whether method of serviceName may be called asynchronously.
+func asyncRoute(serviceName, method string) bool {
+	for _, pattern := range config.Async.Routes {
+		if matchRoute(pattern, serviceName, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsCallback reports whether the URL raw may be notified of the completion of a job: an absolute URL without
+// user information whose scheme and host are those of one of the callback URLs, and whose path, once cleaned, is
+// under its path.
+func allowsCallback(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Opaque != "" || u.User != nil {
+		return false
+	}
+	p := path.Clean("/" + u.Path)
+	for _, callbackURL := range config.Async.CallbackURLs {
+		allowed, err := url.Parse(callbackURL)
+		if err != nil || !strings.EqualFold(u.Scheme, allowed.Scheme) || !strings.EqualFold(u.Host, allowed.Host) {
+			continue
+		}
+		prefix := strings.TrimSuffix(allowed.Path, "/")
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// detachCall returns the context of the call requested in ctx, to be made in the background through the remaining
+// gateway middlewares and decode once ctx is answered. It keeps what the previous middlewares stored in ctx.
+func detachCall(ctx *app.RequestContext) *app.RequestContext {
+	call := app.NewContext(0)
+	ctx.Request.CopyTo(&call.Request)
+	call.Request.Header.DelBytes([]byte(preferHeader))
+	call.Request.Header.DelBytes([]byte(callbackURLHeader))
+	ctx.ForEachKey(func(k string, v interface{}) {
+		call.Set(k, v)
+	})
+	call.SetConn(peerConn{addr: ctx.RemoteAddr()})
+	call.SetClientIPFunc(clientIP)
+	call.SetHandlers(append(callMiddlewares(), decode))
+	return call
+}
+
+// runJob makes the call of j in the background and stores its result, then notifies its callback if any.
+func runJob(c context.Context, call *app.RequestContext, j job) {
+	call.Next(c)
+
+	result := resultOf(call)
+	completed := time.Now().UTC()
+	j.Completed, j.Result, j.Status = &completed, &result, jobSucceeded
+	if result.Error != "" {
+		j.Status = jobFailed
+	}
+	_ = jobs.Put(c, j)
+	if j.Callback != nil {
+		callback := notifyCallback(c, j)
+		j.Callback = &callback
+		_ = jobs.Put(c, j)
+	}
+}
+
+// notifyCallback posts the completed job j to its callback URL, signed with the callback secret when configured,
+// and returns the callback recording the outcome.
+func notifyCallback(c context.Context, j job) jobCallback {
+	callback := jobCallback{URL: j.Callback.URL}
+	timeout := time.Duration(config.Async.CallbackTimeout)
+	if timeout <= 0 {
+		timeout = defaultCallbackTimeout
+	}
+	c, cancel := context.WithTimeout(c, timeout)
+	defer cancel()
+
+	j.Callback = nil
+	body, err := json.Marshal(j)
+	if err != nil {
+		callback.Error = err.Error()
+		return callback
+	}
+	req, err := http.NewRequestWithContext(c, http.MethodPost, callback.URL, bytes.NewReader(body))
+	if err != nil {
+		callback.Error = err.Error()
+		return callback
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if config.Async.CallbackSecret != "" {
+		signer := &hmacsign.Signer{ClientID: callbackClientID, Secret: []byte(config.Async.CallbackSecret)}
+		if err = signer.SignRequest(req); err != nil {
+			callback.Error = err.Error()
+			return callback
+		}
+	}
+	resp, err := callbackClient.Do(req)
+	if err != nil {
+		callback.Error = err.Error()
+		return callback
+	}
+	_ = resp.Body.Close()
+	callback.Status = resp.StatusCode
+	return callback
+}
+
+// respondAsync is the middleware answering the calls to the asynchronous routes that prefer it with 202 and a job,
+// whose call is then made in the background through the remaining middlewares and decode. The job is retrieved at
+// its Location, /_jobs/{id}, and posted to the URL of the X-Callback-URL header, if allowed, once it completes.
+// Other requests are passed on.
+func respondAsync(c context.Context, ctx *app.RequestContext) {
+	serviceName, method := routeOf(ctx)
+	if jobs == nil || serviceName == "" || !prefersAsync(ctx) || !asyncRoute(serviceName, method) {
+		ctx.Next(c)
+		return
+	}
+
+	subject, _, _ := identity(ctx)
+	j := job{ID: newRequestID(), Status: jobPending, Service: serviceName, Method: method, Created: time.Now().UTC(),
+		Subject: subject}
+	if callback := string(ctx.GetHeader(callbackURLHeader)); callback != "" {
+		if !allowsCallback(callback) {
+			ctx.AbortWithMsg("Callback URL not allowed", http.StatusBadRequest)
+			setErrorClass(ctx, "invalid_request")
+			return
+		}
+		j.Callback = &jobCallback{URL: callback}
+	}
+	err := jobs.Put(c, j)
+	if errors.Is(err, errTooManyJobs) {
+		ctx.AbortWithMsg("Too many jobs", http.StatusServiceUnavailable)
+		setErrorClass(ctx, "overloaded")
+		return
+	}
+	if err != nil {
+		ctx.AbortWithMsg("Fail to store job", http.StatusInternalServerError)
+		setErrorClass(ctx, "job_store")
+		return
+	}
+
+	// The call outlives the request, whose access record is written once it is answered.
+	go runJob(context.WithValue(c, accessRecordKey{}, nil), detachCall(ctx), j)
+
+	ctx.Header("Preference-Applied", asyncPreference)
+	ctx.Header("Location", "/_jobs/"+j.ID)
+	ctx.JSON(consts.StatusAccepted, j)
+	ctx.Abort()
+}
+
+// jobSubject returns the subject of the identity the request in ctx authenticates with the credentials required
+// by the route of j, as a call to it would, or "" if it presents none valid.
+func jobSubject(c context.Context, ctx *app.RequestContext, j job) string {
+	for _, rule := range config.JWT.Rules {
+		if !matchRoute(rule.Route, j.Service, j.Method) {
+			continue
+		}
+		if token := bearerToken(ctx); token != "" && verifier != nil {
+			if claims, err := verifier.verify(token, rule.Audience, time.Now()); err == nil {
+				ctx.Set(claimsKey, claims)
+			}
+		}
+		break
+	}
+
+	for _, pattern := range config.APIKeys.Routes {
+		if !matchRoute(pattern, j.Service, j.Method) {
+			continue
+		}
+		if presented := presentedAPIKey(ctx); presented != "" && keys != nil {
+			if k, err := lookupAPIKey(keys, presented); err == nil {
+				ctx.Set(apiKeyOwnerKey, k.Owner)
+			}
+		}
+		break
+	}
+
+	for _, pattern := range config.HMAC.Routes {
+		if !matchRoute(pattern, j.Service, j.Method) {
+			continue
+		}
+		client, rejection := checkSignature(c, func(key string) string {
+			return string(ctx.GetHeader(key))
+		}, string(ctx.Method()), string(ctx.Request.URI().RequestURI()), ctx.Request.Body())
+		if rejection == "" {
+			ctx.Set(hmacClientKey, client)
+		}
+		break
+	}
+
+	subject, _, _ := identity(ctx)
+	return subject
+}
+
+// getJob answers with the job of the id path parameter, holding its result once it completes.
+// The job of a call made on behalf of a subject is only answered to the requests authenticated as that subject,
+// presenting the credentials its route requires; it is unknown to the others.
+func getJob(c context.Context, ctx *app.RequestContext) {
+	if jobs == nil {
+		ctx.SetStatusCode(http.StatusNotFound)
+		ctx.String(consts.StatusNotFound, "Asynchronous calls are disabled")
+		return
+	}
+	j, ok, err := jobs.Get(c, ctx.Param("id"))
+	if err != nil {
+		setErrorClass(ctx, "job_store")
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.String(consts.StatusInternalServerError, "Fail to read job")
+		return
+	}
+	if !ok || (j.Subject != "" && jobSubject(c, ctx, j) != j.Subject) {
+		ctx.SetStatusCode(http.StatusNotFound)
+		ctx.String(consts.StatusNotFound, "Unknown job")
+		return
+	}
+	ctx.JSON(consts.StatusOK, j)
+}
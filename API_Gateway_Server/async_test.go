@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"API_Gateway_Server/hmacsign"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/route/param"
+	"github.com/stretchr/testify/assert"
+)
+
+// useAsyncCalls lets the routes matching routes of ServiceA and ServiceB, whose calls are answered with respond,
+// be called asynchronously.
+func useAsyncCalls(t *testing.T, cfg asyncConfig, respond func(c context.Context, method, request string) (string, error)) {
+	useFakeBackend(t, respond)
+	config.Async = cfg
+	jobs = newMemoryJobStore(0, 0)
+	t.Cleanup(func() {
+		jobs = nil
+	})
+}
+
+// gatewayCall sends the call to path with the JSON body and headers through the gateway middlewares and decode.
+func gatewayCall(path, body string, headers map[string]string) *app.RequestContext {
+	ctx := app.NewContext(0)
+	ctx.Request.Header.SetMethod(http.MethodPost)
+	ctx.Request.SetRequestURI(path)
+	ctx.Request.Header.SetContentTypeBytes([]byte("application/json"))
+	for k, v := range headers {
+		ctx.Request.Header.Set(k, v)
+	}
+	ctx.Request.SetBodyString(body)
+	ctx.SetHandlers(append(gatewayMiddlewares(), decode))
+	ctx.Next(context.Background())
+	return ctx
+}
+
+// jobOf returns the job of id as answered by getJob, with the status of the answer.
+func jobOf(t *testing.T, id string) (int, job) {
+	ctx := &app.RequestContext{}
+	ctx.Params = param.Params{{Key: "id", Value: id}}
+	getJob(context.Background(), ctx)
+	var j job
+	if ctx.Response.StatusCode() == http.StatusOK {
+		assert.NoError(t, json.Unmarshal(ctx.Response.Body(), &j))
+	}
+	return ctx.Response.StatusCode(), j
+}
+
+// completedJob waits for the job of id to complete and returns it.
+func completedJob(t *testing.T, id string) job {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, j := jobOf(t, id)
+		if j.Status != jobPending || time.Now().After(deadline) {
+			return j
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRespondAsync_RunsCallInBackground(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan string, 1)
+	useAsyncCalls(t, asyncConfig{Routes: []string{"ServiceA/*"}}, func(_ context.Context, method, request string) (string, error) {
+		received <- request
+		<-release
+		return `{"message": "done"}`, nil
+	})
+
+	ctx := gatewayCall("/ServiceA/methodA", `{"message": "hi"}`, map[string]string{"Prefer": "respond-async, wait=10"})
+	assert.Equal(t, http.StatusAccepted, ctx.Response.StatusCode())
+	assert.Equal(t, "respond-async", string(ctx.Response.Header.Peek("Preference-Applied")))
+	var accepted job
+	assert.NoError(t, json.Unmarshal(ctx.Response.Body(), &accepted))
+	assert.Equal(t, "/_jobs/"+accepted.ID, string(ctx.Response.Header.Peek("Location")))
+	assert.Equal(t, jobPending, accepted.Status)
+
+	assert.JSONEq(t, `{"message": "hi"}`, <-received)
+	status, pending := jobOf(t, accepted.ID)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, jobPending, pending.Status)
+
+	close(release)
+	done := completedJob(t, accepted.ID)
+	assert.Equal(t, jobSucceeded, done.Status)
+	assert.NotNil(t, done.Completed)
+	assert.Equal(t, http.StatusOK, done.Result.Status)
+	assert.JSONEq(t, `{"message": "done"}`, string(done.Result.Body))
+
+	status, _ = jobOf(t, "unknown")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestGetJob_OnlyToItsSubject(t *testing.T) {
+	useAsyncCalls(t, asyncConfig{}, func(context.Context, string, string) (string, error) {
+		return `{"message": "done"}`, nil
+	})
+	useJWTConfig(t, jwtConfig{HMACSecret: testSecret, Rules: []jwtRule{{Route: "ServiceA/*"}}})
+	config.Async = asyncConfig{Routes: []string{"ServiceA/*"}}
+
+	tokenOf := func(subject string) string {
+		claims := validClaims()
+		claims["sub"] = subject
+		return "Bearer " + signHS256(t, claims)
+	}
+	ctx := gatewayCall("/ServiceA/methodA", `{}`, map[string]string{"Prefer": "respond-async", "Authorization": tokenOf("alice")})
+	assert.Equal(t, http.StatusAccepted, ctx.Response.StatusCode())
+	var accepted job
+	assert.NoError(t, json.Unmarshal(ctx.Response.Body(), &accepted))
+	assert.Equal(t, "alice", accepted.Subject)
+	assert.Eventually(t, func() bool {
+		j, _, _ := jobs.Get(context.Background(), accepted.ID)
+		return j.Status != jobPending
+	}, 2*time.Second, 5*time.Millisecond)
+
+	statusFor := func(authorization string) int {
+		ctx := &app.RequestContext{}
+		ctx.Params = param.Params{{Key: "id", Value: accepted.ID}}
+		if authorization != "" {
+			ctx.Request.SetHeader("Authorization", authorization)
+		}
+		getJob(context.Background(), ctx)
+		return ctx.Response.StatusCode()
+	}
+	assert.Equal(t, http.StatusOK, statusFor(tokenOf("alice")))
+	assert.Equal(t, http.StatusNotFound, statusFor(tokenOf("bob")))
+	assert.Equal(t, http.StatusNotFound, statusFor(""))
+	assert.Equal(t, http.StatusNotFound, statusFor("Bearer invalid"))
+}
+
+func TestRespondAsync_OtherCallsSynchronous(t *testing.T) {
+	useAsyncCalls(t, asyncConfig{Routes: []string{"ServiceA/methodA"}}, func(context.Context, string, string) (string, error) {
+		return `{"message": "hi"}`, nil
+	})
+
+	ctx := gatewayCall("/ServiceA/methodA", `{}`, nil)
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode(), "without the preference")
+	ctx = gatewayCall("/ServiceA/methodB", `{}`, map[string]string{"Prefer": "respond-async"})
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode(), "not an asynchronous route")
+	assert.Empty(t, ctx.Response.Header.Peek("Preference-Applied"))
+
+	origin := &callOrigin{requestID: "id"}
+	origin.header.Set("Prefer", "respond-async")
+	ctx = origin.dispatch(context.Background(), "1", "ServiceA", "methodA", []byte(`{}`))
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode(), "the calls of batches are synchronous")
+}
+
+func TestRespondAsync_FailedCall(t *testing.T) {
+	useAsyncCalls(t, asyncConfig{Routes: []string{"ServiceA/*"}}, nil)
+
+	ctx := gatewayCall("/ServiceA/methodA", `not json`, map[string]string{"Prefer": "respond-async"})
+	var accepted job
+	assert.NoError(t, json.Unmarshal(ctx.Response.Body(), &accepted))
+	done := completedJob(t, accepted.ID)
+	assert.Equal(t, jobFailed, done.Status)
+	assert.Equal(t, http.StatusBadRequest, done.Result.Status)
+}
+
+func TestRespondAsync_Callback(t *testing.T) {
+	notifications := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		notifications <- r
+		bodies <- body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer hook.Close()
+	useAsyncCalls(t, asyncConfig{Routes: []string{"ServiceA/*"}, CallbackURLs: []string{hook.URL + "/hooks/"}, CallbackSecret: "s3cret"},
+		func(context.Context, string, string) (string, error) {
+			return `{"message": "done"}`, nil
+		})
+
+	ctx := gatewayCall("/ServiceA/methodA", `{}`, map[string]string{"Prefer": "respond-async", "X-Callback-URL": "http://attacker.example/"})
+	assert.Equal(t, http.StatusBadRequest, ctx.Response.StatusCode())
+
+	ctx = gatewayCall("/ServiceA/methodA", `{}`, map[string]string{"Prefer": "respond-async", "X-Callback-URL": hook.URL + "/hooks/jobs"})
+	assert.Equal(t, http.StatusAccepted, ctx.Response.StatusCode())
+	var accepted job
+	assert.NoError(t, json.Unmarshal(ctx.Response.Body(), &accepted))
+
+	r, body := <-notifications, <-bodies
+	var notified job
+	assert.NoError(t, json.Unmarshal(body, &notified))
+	assert.Equal(t, accepted.ID, notified.ID)
+	assert.Equal(t, jobSucceeded, notified.Status)
+	assert.Equal(t, callbackClientID, r.Header.Get(hmacsign.HeaderClientID))
+	assert.True(t, hmacsign.Verify([]byte("s3cret"), http.MethodPost, "/hooks/jobs", r.Header.Get(hmacsign.HeaderTimestamp),
+		r.Header.Get(hmacsign.HeaderNonce), body, r.Header.Get(hmacsign.HeaderSignature)))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, j := jobOf(t, accepted.ID)
+		if j.Callback.Status != 0 || time.Now().After(deadline) {
+			assert.Equal(t, http.StatusNoContent, j.Callback.Status)
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRespondAsync_CallbackNotRedirected(t *testing.T) {
+	redirected := make(chan struct{}, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		redirected <- struct{}{}
+	}))
+	defer target.Close()
+	hook := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer hook.Close()
+	useAsyncCalls(t, asyncConfig{Routes: []string{"ServiceA/*"}, CallbackURLs: []string{hook.URL + "/hooks/"}},
+		func(context.Context, string, string) (string, error) {
+			return `{"message": "done"}`, nil
+		})
+
+	ctx := gatewayCall("/ServiceA/methodA", `{}`, map[string]string{"Prefer": "respond-async", "X-Callback-URL": hook.URL + "/hooks/jobs"})
+	var accepted job
+	assert.NoError(t, json.Unmarshal(ctx.Response.Body(), &accepted))
+	assert.Eventually(t, func() bool {
+		_, j := jobOf(t, accepted.ID)
+		return j.Callback.Status != 0
+	}, 2*time.Second, 5*time.Millisecond)
+	_, j := jobOf(t, accepted.ID)
+	assert.Equal(t, http.StatusTemporaryRedirect, j.Callback.Status)
+	assert.Empty(t, redirected, "the redirect is not followed")
+}
+
+func TestAllowsCallback(t *testing.T) {
+	config = &gatewayConfig{Async: asyncConfig{CallbackURLs: []string{"https://hooks.example/jobs/", "http://127.0.0.1:9000"}}}
+	t.Cleanup(func() {
+		config = &gatewayConfig{}
+	})
+
+	for raw, allowed := range map[string]bool{
+		"https://hooks.example/jobs/":                   true,
+		"https://hooks.example/jobs/1?x=y":              true,
+		"HTTPS://HOOKS.EXAMPLE/jobs/1":                  true,
+		"https://hooks.example/jobs":                    true,
+		"http://127.0.0.1:9000/any":                     true,
+		"https://hooks.example/jobsx":                   false,
+		"https://hooks.example/jobs/../admin":           false,
+		"https://hooks.example/jobs/%2e%2e/admin":       false,
+		"https://hooks.example.attacker.example/jobs/1": false,
+		"https://hooks.example@attacker.example/jobs/1": false,
+		"https://user@hooks.example/jobs/1":             false,
+		"http://hooks.example/jobs/1":                   false,
+		"https://hooks.example:8443/jobs/1":             false,
+		"http://127.0.0.1:90001/any":                    false,
+		"/jobs/1":                                       false,
+		"https:hooks.example/jobs/1":                    false,
+	} {
+		assert.Equal(t, allowed, allowsCallback(raw), raw)
+	}
+}
+
+func TestMemoryJobStore_Bounded(t *testing.T) {
+	store := newMemoryJobStore(2, time.Minute)
+	c := context.Background()
+	assert.NoError(t, store.Put(c, job{ID: "a", Status: jobPending}))
+	assert.NoError(t, store.Put(c, job{ID: "b", Status: jobPending}))
+	assert.ErrorIs(t, store.Put(c, job{ID: "c", Status: jobPending}), errTooManyJobs, "pending jobs are not evicted")
+
+	now := time.Now()
+	assert.NoError(t, store.Put(c, job{ID: "b", Status: jobSucceeded, Completed: &now}))
+	assert.NoError(t, store.Put(c, job{ID: "c", Status: jobPending}))
+	_, ok, _ := store.Get(c, "b")
+	assert.False(t, ok, "the oldest completed job is evicted")
+	_, ok, _ = store.Get(c, "a")
+	assert.True(t, ok)
+
+	expired := now.Add(-2 * time.Minute)
+	assert.NoError(t, store.Put(c, job{ID: "a", Status: jobFailed, Completed: &expired}))
+	_, ok, _ = store.Get(c, "a")
+	assert.False(t, ok, "completed jobs expire")
+}
+
+func TestPrefersAsync(t *testing.T) {
+	ctx := &app.RequestContext{}
+	assert.False(t, prefersAsync(ctx))
+	ctx.Request.Header.Set("Prefer", "return=minimal")
+	assert.False(t, prefersAsync(ctx))
+	ctx.Request.Header.Set("Prefer", "return=minimal, Respond-Async; x=1")
+	assert.True(t, prefersAsync(ctx))
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// everyoneRole grants its rules to every caller, and anonymousRole to callers with no roles or scopes.
+const everyoneRole = "*"
+const anonymousRole = "anonymous"
+
+var policy *authzPolicy
+var decisionLogger *log.Logger
+
+// authzConfig configures the authorization of the calls to the backend services,
+// evaluated after authentication with the policy read from PolicyFile.
+// The roles and scopes of a caller are read from the RolesClaim and ScopesClaim claims
+// of its token and from the roles of its API key. Decisions are logged to DecisionLog,
+// "stdout", "stderr" or a file path. In DryRun mode denials are logged but not enforced.
+type authzConfig struct {
+	PolicyFile  string `json:"policyFile"`
+	RolesClaim  string `json:"rolesClaim"`
+	ScopesClaim string `json:"scopesClaim"`
+	DecisionLog string `json:"decisionLog"`
+	DryRun      bool   `json:"dryRun"`
+}
+
+// authzPolicy grants the routes a caller may call to its roles and scopes.
+type authzPolicy struct {
+	Roles  map[string]authzGrant `json:"roles"`
+	Scopes map[string]authzGrant `json:"scopes"`
+}
+
+// authzGrant allows the routes matching one of Allow, except those matching one of Deny.
+// Deny rules take precedence over the allow rules of every role and scope of the caller.
+type authzGrant struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// authzDecision is the decision log line of one authorization.
+type authzDecision struct {
+	Time      string   `json:"time"`
+	RequestID string   `json:"request_id"`
+	Subject   string   `json:"subject"`
+	Roles     []string `json:"roles"`
+	Scopes    []string `json:"scopes"`
+	Service   string   `json:"service"`
+	Method    string   `json:"method"`
+	Allowed   bool     `json:"allowed"`
+	Rule      string   `json:"rule"`
+	DryRun    bool     `json:"dry_run"`
+}
+
+// loadPolicy reads the authorization policy from file.
+// It returns the policy and an error if the file cannot be read or decoded.
+func loadPolicy(file string) (*authzPolicy, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p := &authzPolicy{}
+	err = json.Unmarshal(content, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// grants returns the grants of p applying to a caller with roles and scopes, named after their source.
+func (p *authzPolicy) grants(roles, scopes []string) map[string]authzGrant {
+	grants := make(map[string]authzGrant)
+	add := func(kind string, set map[string]authzGrant, name string) {
+		if g, ok := set[name]; ok {
+			grants[kind+":"+name] = g
+		}
+	}
+
+	add("role", p.Roles, everyoneRole)
+	if len(roles) == 0 && len(scopes) == 0 {
+		add("role", p.Roles, anonymousRole)
+	}
+	for _, r := range roles {
+		add("role", p.Roles, r)
+	}
+	for _, s := range scopes {
+		add("scope", p.Scopes, s)
+	}
+	return grants
+}
+
+// evaluate decides whether a caller with roles and scopes may call method of serviceName.
+// It reports the decision and the rule it is based on.
+func (p *authzPolicy) evaluate(roles, scopes []string, serviceName, method string) (bool, string) {
+	grants := p.grants(roles, scopes)
+	names := make([]string, 0, len(grants))
+	for name := range grants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, pattern := range grants[name].Deny {
+			if matchRoute(pattern, serviceName, method) {
+				return false, fmt.Sprintf("%s deny %s", name, pattern)
+			}
+		}
+	}
+	for _, name := range names {
+		for _, pattern := range grants[name].Allow {
+			if matchRoute(pattern, serviceName, method) {
+				return true, fmt.Sprintf("%s allow %s", name, pattern)
+			}
+		}
+	}
+	return false, "no matching allow rule"
+}
+
+// claimList returns the values of the claim name of claims, given as a list or as a string
+// separated by spaces or commas.
+func claimList(claims jwt.MapClaims, name string) []string {
+	v, ok := claimString(claims, name)
+	if !ok {
+		return nil
+	}
+	return strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' })
+}
+
+// identity returns the subject, roles and scopes of the caller of ctx, as authenticated
+// by its bearer token, its API key or its request signature.
+func identity(ctx *app.RequestContext) (string, []string, []string) {
+	var subject string
+	var roles, scopes []string
+
+	if v, ok := ctx.Get(claimsKey); ok {
+		claims := v.(jwt.MapClaims)
+		subject, _ = claimString(claims, "sub")
+
+		rolesClaim, scopesClaim := config.Authz.RolesClaim, config.Authz.ScopesClaim
+		if rolesClaim == "" {
+			rolesClaim = "roles"
+		}
+		if scopesClaim == "" {
+			scopesClaim = "scope"
+		}
+		roles = append(roles, claimList(claims, rolesClaim)...)
+		scopes = append(scopes, claimList(claims, scopesClaim)...)
+	}
+
+	if owner := ctx.GetString(apiKeyOwnerKey); owner != "" {
+		if subject == "" {
+			subject = owner
+		}
+		if v, ok := ctx.Get(apiKeyRolesKey); ok {
+			roles = append(roles, v.([]string)...)
+		}
+	}
+
+	if client := ctx.GetString(hmacClientKey); client != "" {
+		if subject == "" {
+			subject = client
+		}
+		roles = append(roles, config.HMAC.ClientRoles[client]...)
+	}
+	return subject, roles, scopes
+}
+
+// callerKey returns what tells the caller of ctx apart to the backend: the subject of its identity and the metadata
+// sent to the backend with c, but the request ID. Responses shared between requests are only shared by the requests
+// of the same caller key.
+func callerKey(c context.Context, ctx *app.RequestContext) string {
+	subject, _, _ := identity(ctx)
+	metadata := metainfo.GetAllValues(c)
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		if k != requestIDMetaKey {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(subject)
+	for _, k := range keys {
+		b.WriteString("\x00" + k + "=" + metadata[k])
+	}
+	return b.String()
+}
+
+// logDecision writes decision to the decision log, if any.
+func logDecision(decision authzDecision) {
+	if decisionLogger == nil {
+		return
+	}
+	line, err := json.Marshal(decision)
+	if err == nil {
+		decisionLogger.Println(string(line))
+	}
+}
+
+// authorize is the middleware enforcing the authorization policy on the calls to the backend services.
+// Callers not allowed to call the requested method are rejected with 403, unless in dry-run mode.
+// The endpoints of the gateway itself, under paths starting with "_", are not authorized by the policy.
+func authorize(c context.Context, ctx *app.RequestContext) {
+	serviceName, method := routeOf(ctx)
+	if policy == nil || serviceName == "" || strings.HasPrefix(serviceName, "_") {
+		ctx.Next(c)
+		return
+	}
+
+	subject, roles, scopes := identity(ctx)
+	allowed, rule := policy.evaluate(roles, scopes, serviceName, method)
+	logDecision(authzDecision{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		RequestID: requestID(ctx),
+		Subject:   subject,
+		Roles:     roles,
+		Scopes:    scopes,
+		Service:   serviceName,
+		Method:    method,
+		Allowed:   allowed,
+		Rule:      rule,
+		DryRun:    config.Authz.DryRun,
+	})
+
+	if !allowed && !config.Authz.DryRun {
+		ctx.AbortWithMsg("Forbidden, not allowed to call this method", http.StatusForbidden)
+		setErrorClass(ctx, "forbidden")
+		return
+	}
+	ctx.Next(c)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"API_Gateway_Server/hmacsign"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicy = `{
+  "roles": {
+    "*": {"allow": ["ServiceA/methodA"]},
+    "reader": {"allow": ["ServiceA/*", "ServiceB/*"], "deny": ["*/methodC"]},
+    "admin": {"allow": ["*/*"]}
+  },
+  "scopes": {
+    "orders:write": {"allow": ["ServiceB/methodC"]}
+  }
+}`
+
+func useTestPolicy(t *testing.T, dryRun bool) *bytes.Buffer {
+	file := filepath.Join(t.TempDir(), "policy.json")
+	assert.NoError(t, os.WriteFile(file, []byte(testPolicy), 0644))
+
+	var err error
+	policy, err = loadPolicy(file)
+	assert.NoError(t, err)
+	config = &gatewayConfig{Authz: authzConfig{DryRun: dryRun}}
+	decisions := &bytes.Buffer{}
+	decisionLogger = log.New(decisions, "", 0)
+	t.Cleanup(func() {
+		policy = nil
+		decisionLogger = nil
+		config = &gatewayConfig{}
+	})
+	return decisions
+}
+
+func newAuthzContext(path string, claims jwt.MapClaims) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, path, nil),
+	}
+	if claims != nil {
+		ctx.Set(claimsKey, claims)
+	}
+	return ctx
+}
+
+func TestAuthzPolicy_Evaluate(t *testing.T) {
+	useTestPolicy(t, false)
+
+	allowed, _ := policy.evaluate(nil, nil, "ServiceA", "methodA")
+	assert.True(t, allowed)
+	allowed, _ = policy.evaluate(nil, nil, "ServiceA", "methodB")
+	assert.False(t, allowed)
+
+	allowed, rule := policy.evaluate([]string{"reader"}, nil, "ServiceB", "methodC")
+	assert.False(t, allowed)
+	assert.Equal(t, "role:reader deny */methodC", rule)
+
+	allowed, _ = policy.evaluate([]string{"reader"}, []string{"orders:write"}, "ServiceB", "methodC")
+	assert.False(t, allowed, "deny rules take precedence over allow rules")
+
+	allowed, _ = policy.evaluate(nil, []string{"orders:write"}, "ServiceB", "methodC")
+	assert.True(t, allowed)
+	allowed, _ = policy.evaluate([]string{"admin"}, nil, "ServiceA", "methodC")
+	assert.True(t, allowed)
+}
+
+func TestAuthorize_EnforcesPolicy(t *testing.T) {
+	decisions := useTestPolicy(t, false)
+
+	ctx := newAuthzContext("/ServiceA/methodC", jwt.MapClaims{"sub": "user-1", "roles": []interface{}{"reader"}})
+	authorize(context.Background(), ctx)
+	assert.Equal(t, http.StatusForbidden, ctx.Response.StatusCode())
+
+	var decision authzDecision
+	assert.NoError(t, json.Unmarshal(decisions.Bytes(), &decision))
+	assert.Equal(t, "user-1", decision.Subject)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, "role:reader deny */methodC", decision.Rule)
+
+	ctx = newAuthzContext("/ServiceB/methodC", jwt.MapClaims{"sub": "user-2", "scope": "orders:read orders:write"})
+	authorize(context.Background(), ctx)
+	assert.False(t, ctx.IsAborted())
+
+	ctx = newAuthzContext("/ServiceA/methodB", nil)
+	ctx.Set(apiKeyOwnerKey, "partner")
+	ctx.Set(apiKeyRolesKey, []string{"reader"})
+	authorize(context.Background(), ctx)
+	assert.False(t, ctx.IsAborted())
+
+	ctx = newAuthzContext("/_admin/keys", nil)
+	authorize(context.Background(), ctx)
+	assert.False(t, ctx.IsAborted())
+}
+
+func TestAuthorize_SignedClientRoles(t *testing.T) {
+	useTestPolicy(t, false)
+	config.HMAC = hmacConfig{
+		Clients:     map[string]string{"billing": "secret", "partner": "other"},
+		ClientRoles: map[string][]string{"billing": {"reader"}},
+		Routes:      []string{"ServiceA/*"},
+		Skew:        duration(time.Minute),
+	}
+	previous := sharedStore
+	sharedStore = newMemoryKV()
+	t.Cleanup(func() {
+		sharedStore = previous
+	})
+
+	signedCall := func(clientID, secret string) *app.RequestContext {
+		ctx := signedContext(t, &hmacsign.Signer{ClientID: clientID, Secret: []byte(secret)}, "/ServiceA/methodB", `{}`)
+		verifySignature(context.Background(), ctx)
+		assert.False(t, ctx.IsAborted())
+		authorize(context.Background(), ctx)
+		return ctx
+	}
+	assert.False(t, signedCall("billing", "secret").IsAborted(), "a signed client has its roles")
+	assert.Equal(t, http.StatusForbidden, signedCall("partner", "other").Response.StatusCode(),
+		"a signed client without roles is not granted what no role grants")
+}
+
+func TestAuthorize_DryRunLogsWithoutEnforcing(t *testing.T) {
+	decisions := useTestPolicy(t, true)
+
+	ctx := newAuthzContext("/ServiceA/methodB", nil)
+	authorize(context.Background(), ctx)
+	assert.False(t, ctx.IsAborted())
+
+	var decision authzDecision
+	assert.NoError(t, json.Unmarshal(decisions.Bytes(), &decision))
+	assert.False(t, decision.Allowed)
+	assert.True(t, decision.DryRun)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// defaultBatchItems and defaultBatchConcurrency bound the batch requests when the batch endpoint is not configured.
+const defaultBatchItems = 20
+const defaultBatchConcurrency = 4
+
+// batchConfig limits the batch requests to MaxItems calls, of which at most Concurrency are made at once.
+type batchConfig struct {
+	MaxItems    int `json:"maxItems"`
+	Concurrency int `json:"concurrency"`
+}
+
+// batchItem is one call of a batch request, to Method of Service with the JSON object Body.
+type batchItem struct {
+	Service string          `json:"service"`
+	Method  string          `json:"method"`
+	Body    json.RawMessage `json:"body"`
+}
+
+// batchResult is the outcome of one call of a batch request: its HTTP status and either
+// its JSON response body or the error message answering it.
+type batchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// batchLimits returns the maximum number of calls in a batch and of calls of a batch made at once.
+func batchLimits() (int, int) {
+	maxItems, concurrency := config.Batch.MaxItems, config.Batch.Concurrency
+	if maxItems <= 0 {
+		maxItems = defaultBatchItems
+	}
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	return maxItems, concurrency
+}
+
+// forEachConcurrently calls fn for each index below n, at most concurrency at once, and waits for the calls to return.
+func forEachConcurrently(n, concurrency int, fn func(i int)) {
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// resultOf returns the outcome of the call answered in ctx.
+func resultOf(ctx *app.RequestContext) batchResult {
+	result := batchResult{Status: ctx.Response.StatusCode()}
+	body := ctx.Response.Body()
+	if result.Status < http.StatusBadRequest && json.Valid(body) {
+		result.Body = append(json.RawMessage(nil), body...)
+	} else {
+		result.Error = string(body)
+	}
+	return result
+}
+
+// batch handles the batch requests, a JSON array of calls made concurrently as if each had been sent on its own,
+// so that every call is subject to the gateway policies. It answers with the array of the results of the calls in
+// the order of the request, the failure of one call not failing the others.
+func batch(c context.Context, ctx *app.RequestContext) {
+	var items []batchItem
+	err := json.Unmarshal(ctx.Request.Body(), &items)
+	if err != nil {
+		setErrorClass(ctx, "invalid_request")
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.String(consts.StatusBadRequest, "Invalid JSON data, expected an array of calls")
+		return
+	}
+
+	maxItems, concurrency := batchLimits()
+	if len(items) > maxItems {
+		setErrorClass(ctx, "invalid_request")
+		ctx.SetStatusCode(http.StatusRequestEntityTooLarge)
+		ctx.String(consts.StatusRequestEntityTooLarge, "Too many calls in batch, at most "+strconv.Itoa(maxItems))
+		return
+	}
+
+	origin := newCallOrigin(c, ctx)
+	results := make([]batchResult, len(items))
+	forEachConcurrently(len(items), concurrency, func(i int) {
+		item := items[i]
+		if item.Service == "" || item.Method == "" {
+			results[i] = batchResult{Status: http.StatusBadRequest, Error: "Invalid call, service and method required"}
+			return
+		}
+		if len(item.Body) == 0 {
+			item.Body = json.RawMessage("{}")
+		}
+		results[i] = resultOf(origin.dispatch(c, strconv.Itoa(i), item.Service, item.Method, item.Body))
+	})
+
+	ctx.JSON(consts.StatusOK, results)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"API_Gateway_Server/hmacsign"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/cloudwego/kitex/client/callopt"
+	"github.com/cloudwego/kitex/client/genericclient"
+	"github.com/cloudwego/kitex/pkg/discovery"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClient is a generic client answering the calls with respond.
+type fakeClient struct {
+	respond func(c context.Context, method, request string) (string, error)
+}
+
+func (f fakeClient) GenericCall(c context.Context, method string, request interface{}, _ ...callopt.Option) (interface{}, error) {
+	return f.respond(c, method, request.(string))
+}
+
+func (f fakeClient) Close() error {
+	return nil
+}
+
+// fakeResolver resolves every service to one instance.
+type fakeResolver struct{}
+
+func (fakeResolver) Target(_ context.Context, target rpcinfo.EndpointInfo) string {
+	return target.ServiceName()
+}
+
+func (fakeResolver) Resolve(_ context.Context, desc string) (discovery.Result, error) {
+	return discovery.Result{CacheKey: desc, Instances: []discovery.Instance{discovery.NewInstance("tcp", "127.0.0.1:8888", 10, nil)}}, nil
+}
+
+func (fakeResolver) Diff(cacheKey string, prev, next discovery.Result) (discovery.Change, bool) {
+	return discovery.DefaultDiff(cacheKey, prev, next)
+}
+
+func (fakeResolver) Name() string {
+	return "fake"
+}
+
+// useFakeBackend makes ServiceA and ServiceB answer the calls with respond.
+func useFakeBackend(t *testing.T, respond func(c context.Context, method, request string) (string, error)) {
+	previousReg, previousClients := reg, serviceClientMap
+	reg = fakeResolver{}
+	serviceClientMap = map[string]genericclient.Client{
+		"ServiceA": fakeClient{respond},
+		"ServiceB": fakeClient{respond},
+	}
+	config = &gatewayConfig{}
+	t.Cleanup(func() {
+		reg, serviceClientMap = previousReg, previousClients
+		config = &gatewayConfig{}
+	})
+}
+
+func batchCall(body string) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, "/_batch", nil),
+	}
+	ctx.Request.SetHeader("Content-Type", "application/json")
+	ctx.Request.SetBodyString(body)
+	batch(context.Background(), ctx)
+	return ctx
+}
+
+func TestBatch_ResultsInOrderWithPartialFailures(t *testing.T) {
+	useFakeBackend(t, func(_ context.Context, method, _ string) (string, error) {
+		if method == "methodC" {
+			return "", errors.New("backend down")
+		}
+		return `{"message": "` + method + `"}`, nil
+	})
+
+	ctx := batchCall(`[
+		{"service": "ServiceA", "method": "methodA", "body": {"message": "hi"}},
+		{"service": "ServiceB", "method": "methodC", "body": {"message": "hi"}},
+		{"service": "ServiceC", "method": "methodA", "body": {"message": "hi"}},
+		{"service": "ServiceA"},
+		{"service": "ServiceB", "method": "methodB"}
+	]`)
+
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `[
+		{"status": 200, "body": {"message": "methodA"}},
+		{"status": 500, "error": "Error making generic call"},
+		{"status": 400, "error": "Invalid service name, service undefined"},
+		{"status": 400, "error": "Invalid call, service and method required"},
+		{"status": 200, "body": {"message": "methodB"}}
+	]`, string(ctx.Response.Body()))
+}
+
+func TestBatch_SignedRequests(t *testing.T) {
+	useFakeBackend(t, func(c context.Context, _, _ string) (string, error) {
+		client, _ := metainfo.GetValue(c, hmacClientMetaKey)
+		return `{"message": "` + client + `"}`, nil
+	})
+	useHMACConfig(t)
+	signer := &hmacsign.Signer{ClientID: "billing", Secret: []byte("secret")}
+
+	body := `[
+		{"service": "ServiceA", "method": "methodA", "body": {}},
+		{"service": "ServiceA", "method": "methodB", "body": {}},
+		{"service": "ServiceB", "method": "methodA", "body": {}}
+	]`
+	ctx := signedContext(t, signer, "/_batch", body)
+	ctx.Request.SetHeader("Content-Type", "application/json")
+	batch(context.Background(), ctx)
+	assert.JSONEq(t, `[
+		{"status": 200, "body": {"message": "billing"}},
+		{"status": 200, "body": {"message": "billing"}},
+		{"status": 200, "body": {"message": ""}}
+	]`, string(ctx.Response.Body()), "the signature of the batch is checked once for its calls")
+
+	replay := &app.RequestContext{}
+	ctx.Request.CopyTo(&replay.Request)
+	batch(context.Background(), replay)
+	assert.JSONEq(t, `[
+		{"status": 401, "error": "Invalid request signature"},
+		{"status": 401, "error": "Invalid request signature"},
+		{"status": 200, "body": {"message": ""}}
+	]`, string(replay.Response.Body()), "a replayed batch is not signed")
+}
+
+func TestBatch_APIKeyQueryParam(t *testing.T) {
+	useFakeBackend(t, func(c context.Context, _, _ string) (string, error) {
+		owner, _ := metainfo.GetValue(c, apiKeyOwnerMetaKey)
+		return `{"message": "` + owner + `"}`, nil
+	})
+	useAPIKeys(t, "ServiceB/*")
+	resp := createTestKey(t, `{"owner": "partner"}`)
+
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, "/_batch?api_key="+resp.Key, nil),
+	}
+	ctx.Request.SetHeader("Content-Type", "application/json")
+	ctx.Request.SetBodyString(`[
+		{"service": "ServiceB", "method": "methodA", "body": {}},
+		{"service": "ServiceB", "method": "methodB", "body": {}}
+	]`)
+	batch(context.Background(), ctx)
+	assert.JSONEq(t, `[
+		{"status": 200, "body": {"message": "partner"}},
+		{"status": 200, "body": {"message": "partner"}}
+	]`, string(ctx.Response.Body()), "the calls are made with the key of the query parameter")
+}
+
+func TestBatch_CapsConcurrencyAndItems(t *testing.T) {
+	var inFlight, peak int32
+	useFakeBackend(t, func(context.Context, string, string) (string, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		return `{"message": "hi"}`, nil
+	})
+	config.Batch = batchConfig{MaxItems: 6, Concurrency: 2}
+
+	item := `{"service": "ServiceA", "method": "methodA", "body": {}}`
+	ctx := batchCall("[" + item + "," + item + "," + item + "," + item + "," + item + "]")
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&peak))
+
+	ctx = batchCall("[" + item + "," + item + "," + item + "," + item + "," + item + "," + item + "," + item + "]")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, ctx.Response.StatusCode())
+
+	ctx = batchCall(`{"service": "ServiceA"}`)
+	assert.Equal(t, http.StatusBadRequest, ctx.Response.StatusCode())
+}
+
+func TestDispatch_AppliesGatewayPolicies(t *testing.T) {
+	useFakeBackend(t, func(context.Context, string, string) (string, error) {
+		return `{"message": "hi"}`, nil
+	})
+	useAPIKeys(t, "ServiceB/*")
+
+	parent := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, "/_batch", nil),
+	}
+	parent.Set(requestIDKey, "batch-id")
+
+	origin := newCallOrigin(context.Background(), parent)
+	ctx := origin.dispatch(context.Background(), "0", "ServiceA", "methodA", []byte(`{}`))
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "batch-id-0", string(ctx.Response.Header.Peek(requestIDHeader)))
+
+	ctx = origin.dispatch(context.Background(), "1", "ServiceB", "methodA", []byte(`{}`))
+	assert.Equal(t, http.StatusUnauthorized, ctx.Response.StatusCode(), "ServiceB requires an API key")
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultQueueTimeout is how long a call waits for a free slot when the rule sets no queue timeout.
+const defaultQueueTimeout = 50 * time.Millisecond
+
+var bulkheads = newBulkheadGroup(bulkheadConfig{})
+
+var errBulkheadFull = errors.New("too many in-flight calls to service, request shed")
+
+// bulkheadConfig configures the maximum number of in-flight generic calls for each service,
+// keyed by service name. The rule under "*" applies to services without their own rule.
+type bulkheadConfig struct {
+	Services map[string]bulkheadRule `json:"services"`
+}
+
+// bulkheadRule limits the in-flight generic calls of a service to MaxInFlight.
+// Calls over the limit wait up to QueueTimeout for a free slot before being shed.
+//
+// With Adaptive set, the limit starts at MaxInFlight and follows an AIMD scheme
+// between MinInFlight and MaxInFlight: it shrinks by Backoff whenever a call fails
+// or is slower than LatencyTarget, and grows by one after each fast call made while the limit was reached.
+type bulkheadRule struct {
+	MaxInFlight   int      `json:"maxInFlight"`
+	QueueTimeout  duration `json:"queueTimeout"`
+	Adaptive      bool     `json:"adaptive"`
+	MinInFlight   int      `json:"minInFlight"`
+	LatencyTarget duration `json:"latencyTarget"`
+	Backoff       float64  `json:"backoff"`
+}
+
+// bulkhead limits the concurrent calls to one service.
+type bulkhead struct {
+	rule     bulkheadRule
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	waiters  []chan struct{}
+}
+
+// newBulkhead creates a bulkhead enforcing rule, filling in the defaults of the adaptive options.
+func newBulkhead(rule bulkheadRule) *bulkhead {
+	if rule.QueueTimeout <= 0 {
+		rule.QueueTimeout = duration(defaultQueueTimeout)
+	}
+	if rule.MinInFlight < 1 {
+		rule.MinInFlight = 1
+	}
+	if rule.Backoff <= 0 || rule.Backoff >= 1 {
+		rule.Backoff = 0.9
+	}
+	return &bulkhead{rule: rule, limit: float64(rule.MaxInFlight)}
+}
+
+// acquire takes a slot for a call, waiting up to the queue timeout for one to be released.
+// It returns a release function to call with the outcome of the call, or
+// errBulkheadFull if no slot became free in time.
+func (b *bulkhead) acquire(c context.Context) (func(time.Duration, error), error) {
+	b.mu.Lock()
+	if b.inFlight < int(b.limit) && len(b.waiters) == 0 {
+		b.inFlight++
+		b.mu.Unlock()
+		return b.release, nil
+	}
+	ready := make(chan struct{})
+	b.waiters = append(b.waiters, ready)
+	b.mu.Unlock()
+
+	timer := time.NewTimer(time.Duration(b.rule.QueueTimeout))
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return b.release, nil
+	case <-timer.C:
+	case <-c.Done():
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, w := range b.waiters {
+		if w == ready {
+			b.waiters = append(b.waiters[:i], b.waiters[i+1:]...)
+			return nil, errBulkheadFull
+		}
+	}
+	// The slot was granted while timing out.
+	return b.release, nil
+}
+
+// release frees the slot of a call that took latency and failed with err,
+// adapts the limit if enabled, and hands free slots to waiting calls.
+func (b *bulkhead) release(latency time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rule.Adaptive {
+		b.adapt(latency, err)
+	}
+	b.inFlight--
+	for len(b.waiters) > 0 && b.inFlight < int(b.limit) {
+		b.inFlight++
+		close(b.waiters[0])
+		b.waiters = b.waiters[1:]
+	}
+}
+
+// adapt applies the AIMD update for a call that took latency and failed with err.
+// The caller must hold b.mu.
+func (b *bulkhead) adapt(latency time.Duration, err error) {
+	slow := b.rule.LatencyTarget > 0 && latency > time.Duration(b.rule.LatencyTarget)
+	if err != nil || slow {
+		b.limit *= b.rule.Backoff
+		if b.limit < float64(b.rule.MinInFlight) {
+			b.limit = float64(b.rule.MinInFlight)
+		}
+		return
+	}
+	if b.inFlight >= int(b.limit) && b.limit < float64(b.rule.MaxInFlight) {
+		b.limit++
+	}
+}
+
+// currentLimit returns the number of calls currently allowed in flight.
+func (b *bulkhead) currentLimit() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int(b.limit)
+}
+
+// bulkheadGroup holds the bulkhead of each service, created on first use.
+type bulkheadGroup struct {
+	cfg       bulkheadConfig
+	mu        sync.Mutex
+	bulkheads map[string]*bulkhead
+}
+
+// newBulkheadGroup creates the bulkheads described by cfg.
+func newBulkheadGroup(cfg bulkheadConfig) *bulkheadGroup {
+	return &bulkheadGroup{cfg: cfg, bulkheads: make(map[string]*bulkhead)}
+}
+
+// get returns the bulkhead of serviceName, or nil if the service is not limited.
+func (g *bulkheadGroup) get(serviceName string) *bulkhead {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	b, ok := g.bulkheads[serviceName]
+	if ok {
+		return b
+	}
+	rule, ok := g.cfg.Services[serviceName]
+	if !ok {
+		rule, ok = g.cfg.Services["*"]
+	}
+	if ok && rule.MaxInFlight > 0 {
+		b = newBulkhead(rule)
+	}
+	g.bulkheads[serviceName] = b
+	return b
+}
+
+// acquire takes a slot for a call to serviceName.
+// It returns the release function of the slot, or errBulkheadFull if the call must be shed.
+func (g *bulkheadGroup) acquire(c context.Context, serviceName string) (func(time.Duration, error), error) {
+	b := g.get(serviceName)
+	if b == nil {
+		return func(time.Duration, error) {}, nil
+	}
+	return b.acquire(c)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkhead_ShedsAfterQueueTimeout(t *testing.T) {
+	b := newBulkhead(bulkheadRule{MaxInFlight: 1, QueueTimeout: duration(10 * time.Millisecond)})
+
+	release, err := b.acquire(context.Background())
+	assert.NoError(t, err)
+
+	_, err = b.acquire(context.Background())
+	assert.Equal(t, errBulkheadFull, err)
+
+	release(time.Millisecond, nil)
+	_, err = b.acquire(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestBulkhead_QueuedCallGetsReleasedSlot(t *testing.T) {
+	b := newBulkhead(bulkheadRule{MaxInFlight: 1, QueueTimeout: duration(time.Second)})
+
+	release, err := b.acquire(context.Background())
+	assert.NoError(t, err)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release(time.Millisecond, nil)
+	}()
+
+	_, err = b.acquire(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestBulkhead_AdaptiveLimit(t *testing.T) {
+	b := newBulkhead(bulkheadRule{
+		MaxInFlight:   4,
+		MinInFlight:   2,
+		Adaptive:      true,
+		LatencyTarget: duration(100 * time.Millisecond),
+		Backoff:       0.5,
+	})
+
+	release, err := b.acquire(context.Background())
+	assert.NoError(t, err)
+	release(time.Second, nil)
+	assert.Equal(t, 2, b.currentLimit())
+
+	release, err = b.acquire(context.Background())
+	assert.NoError(t, err)
+	release(time.Millisecond, errors.New("call failed"))
+	assert.Equal(t, 2, b.currentLimit())
+
+	first, _ := b.acquire(context.Background())
+	second, _ := b.acquire(context.Background())
+	first(time.Millisecond, nil)
+	second(time.Millisecond, nil)
+	assert.Equal(t, 3, b.currentLimit())
+}
+
+func TestBulkheadGroup_PerService(t *testing.T) {
+	g := newBulkheadGroup(bulkheadConfig{Services: map[string]bulkheadRule{
+		"ServiceB": {MaxInFlight: 1, QueueTimeout: duration(time.Millisecond)},
+	}})
+
+	_, err := g.acquire(context.Background(), "ServiceB")
+	assert.NoError(t, err)
+	_, err = g.acquire(context.Background(), "ServiceB")
+	assert.Equal(t, errBulkheadFull, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = g.acquire(context.Background(), "ServiceA")
+		assert.NoError(t, err)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// defaultCacheEntries and defaultCacheBytes bound the in-memory response cache when its size is not configured.
+const defaultCacheEntries = 10000
+const defaultCacheBytes = 64 << 20
+
+var responses responseCache
+
+var cacheRequestsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_cache_requests_total",
+	Help: "Requests to cached methods, by result: hit, miss or bypass.",
+}, []string{"service", "method", "result"})
+
+// cacheConfig configures the response cache of the methods matching each rule, the first matching rule applying.
+// The in-memory cache holds up to MaxEntries responses and MaxBytes bytes, evicting the least recently used.
+type cacheConfig struct {
+	MaxEntries int         `json:"maxEntries"`
+	MaxBytes   int         `json:"maxBytes"`
+	Rules      []cacheRule `json:"rules"`
+}
+
+// cacheRule caches the successful responses of the routes matching Route for TTL.
+// Responses are cached per value of the body fields KeyFields, the whole body when empty,
+// and of the request headers KeyHeaders.
+type cacheRule struct {
+	Route      string   `json:"route"`
+	TTL        duration `json:"ttl"`
+	KeyFields  []string `json:"keyFields"`
+	KeyHeaders []string `json:"keyHeaders"`
+}
+
+// cachedResponse is a response kept in the cache.
+type cachedResponse struct {
+	Status      int       `json:"status"`
+	ContentType string    `json:"contentType"`
+	Body        []byte    `json:"body"`
+	ETag        string    `json:"etag"`
+	Stored      time.Time `json:"stored"`
+	Expires     time.Time `json:"expires"`
+}
+
+// responseCache keeps the cached responses. Deployments may plug in an external store
+// such as Redis; memoryCache keeps them in an in-process LRU.
+type responseCache interface {
+	// Get returns the live response stored at key and whether it exists.
+	Get(c context.Context, key string) (cachedResponse, bool, error)
+	// Set stores resp at key until resp.Expires.
+	Set(c context.Context, key string, resp cachedResponse) error
+	// Purge removes the responses whose key starts with prefix and returns how many were removed.
+	Purge(c context.Context, prefix string) (int, error)
+}
+
+// memoryCache is an in-process LRU responseCache bounded in entries and bytes.
+type memoryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	bytes      int
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key  string
+	resp cachedResponse
+}
+
+// newMemoryCache creates an empty memoryCache holding up to maxEntries responses and maxBytes bytes.
+func newMemoryCache(maxEntries, maxBytes int) *memoryCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheEntries
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultCacheBytes
+	}
+	return &memoryCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// entrySize returns the bytes accounted to the entry of key holding resp.
+func entrySize(key string, resp cachedResponse) int {
+	return len(key) + len(resp.ContentType) + len(resp.Body)
+}
+
+// remove removes elem from m. The caller must hold m.mu.
+func (m *memoryCache) remove(elem *list.Element) {
+	e := elem.Value.(*memoryCacheEntry)
+	m.order.Remove(elem)
+	delete(m.entries, e.key)
+	m.bytes -= entrySize(e.key, e.resp)
+}
+
+// Get returns the live response stored at key and whether it exists, marking it as recently used.
+func (m *memoryCache) Get(_ context.Context, key string) (cachedResponse, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.entries[key]
+	if !ok {
+		return cachedResponse{}, false, nil
+	}
+	e := elem.Value.(*memoryCacheEntry)
+	if time.Now().After(e.resp.Expires) {
+		m.remove(elem)
+		return cachedResponse{}, false, nil
+	}
+	m.order.MoveToFront(elem)
+	return e.resp, true, nil
+}
+
+// Set stores resp at key, evicting the least recently used responses over the size limits.
+// Responses larger than the whole cache are not stored.
+func (m *memoryCache) Set(_ context.Context, key string, resp cachedResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+	size := entrySize(key, resp)
+	if size > m.maxBytes {
+		return nil
+	}
+
+	m.entries[key] = m.order.PushFront(&memoryCacheEntry{key: key, resp: resp})
+	m.bytes += size
+	for m.order.Len() > m.maxEntries || m.bytes > m.maxBytes {
+		m.remove(m.order.Back())
+	}
+	return nil
+}
+
+// Purge removes the responses whose key starts with prefix and returns how many were removed.
+func (m *memoryCache) Purge(_ context.Context, prefix string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for key, elem := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			m.remove(elem)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// canonicalBody returns the JSON body with its object keys sorted and its insignificant whitespace removed,
+// keeping only the top-level fields when fields is not empty, so that equivalent bodies are equal.
+// It returns an error if body is not a JSON object.
+func canonicalBody(body []byte, fields []string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	err := decoder.Decode(&object)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) > 0 {
+		selected := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			if v, ok := object[f]; ok {
+				selected[f] = v
+			}
+		}
+		object = selected
+	}
+	return json.Marshal(object)
+}
+
+// cacheKey returns the key of the response of ctx to method of serviceName under rule, "service/method/" followed by
+// the digest of the selected body fields and headers and of the caller key, so that the response the backend built
+// for a caller is not served to another. It returns an error if the body is not a JSON object.
+func cacheKey(c context.Context, ctx *app.RequestContext, rule *cacheRule, serviceName, method string) (string, error) {
+	canonical, err := canonicalBody(ctx.Request.Body(), rule.KeyFields)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write(canonical)
+	h.Write([]byte{0})
+	h.Write([]byte(callerKey(c, ctx)))
+	for _, name := range rule.KeyHeaders {
+		h.Write([]byte{0})
+		h.Write([]byte(strings.ToLower(name)))
+		h.Write([]byte{0})
+		h.Write(ctx.GetHeader(name))
+	}
+	return serviceName + "/" + method + "/" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cacheControl holds the directives of a Cache-Control header relevant to the gateway.
+type cacheControl struct {
+	noStore bool
+	noCache bool
+	// maxAge is the maximum age of a cached response accepted, or -1 for any.
+	maxAge int
+}
+
+// parseCacheControl parses the Cache-Control header value.
+func parseCacheControl(value string) cacheControl {
+	cc := cacheControl{maxAge: -1}
+	for _, directive := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store":
+			cc.noStore = true
+		case "no-cache":
+			cc.noCache = true
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(arg, `"`)); err == nil && seconds >= 0 {
+				cc.maxAge = seconds
+			}
+		}
+	}
+	return cc
+}
+
+// writeCachedResponse answers ctx with resp, served from the cache at now.
+func writeCachedResponse(ctx *app.RequestContext, resp cachedResponse, now time.Time) {
+	ctx.SetStatusCode(resp.Status)
+	ctx.Response.Header.SetContentType(resp.ContentType)
+	ctx.Response.SetBody(resp.Body)
+	ctx.Header("ETag", resp.ETag)
+	ctx.Header("Age", strconv.Itoa(int(now.Sub(resp.Stored).Seconds())))
+	ctx.Header("Cache-Control", "max-age="+strconv.Itoa(int(resp.Expires.Sub(now).Seconds())))
+	ctx.Header("X-Cache", "HIT")
+}
+
+// cacheRuleFor returns the first cache rule matching method of serviceName, or nil if the method is not cacheable.
+func cacheRuleFor(serviceName, method string) *cacheRule {
+	for i := range config.Cache.Rules {
+		if matchRoute(config.Cache.Rules[i].Route, serviceName, method) {
+			return &config.Cache.Rules[i]
+		}
+	}
+	return nil
+}
+
+// acceptsCached reports whether the request with the Cache-Control cc accepts resp, cached at now.
+func acceptsCached(cc cacheControl, resp cachedResponse, now time.Time) bool {
+	return !cc.noCache && (cc.maxAge < 0 || now.Sub(resp.Stored) <= time.Duration(cc.maxAge)*time.Second)
+}
+
+// cachedEntityTag returns the entity tag of the response cached for the request of ctx to method of serviceName
+// under rule, and whether the request would be answered with it by cacheResponse.
+func cachedEntityTag(c context.Context, ctx *app.RequestContext, rule *cacheRule, serviceName, method string) (string, bool) {
+	cc := parseCacheControl(string(ctx.GetHeader("Cache-Control")))
+	if responses == nil || cc.noStore || len(ctx.GetHeader("If-Match")) > 0 {
+		return "", false
+	}
+	key, err := cacheKey(c, ctx, rule, serviceName, method)
+	if err != nil {
+		return "", false
+	}
+	resp, ok, err := responses.Get(c, key)
+	if err != nil || !ok || !acceptsCached(cc, resp, time.Now()) || resp.ETag == "" {
+		return "", false
+	}
+	return resp.ETag, true
+}
+
+// cacheResponse is the middleware answering the requests to cached methods from the response cache,
+// and caching the successful responses of the others. Requests with Cache-Control no-store are neither
+// answered from nor stored in the cache, no-cache skips the lookup, and max-age bounds the age of
+// the response accepted. Responses with Cache-Control no-store are not stored.
+// Requests with If-Match bypass the cache, as their precondition is checked by the backend.
+func cacheResponse(c context.Context, ctx *app.RequestContext) {
+	serviceName, method := routeOf(ctx)
+
+	rule := cacheRuleFor(serviceName, method)
+	if rule == nil || responses == nil {
+		ctx.Next(c)
+		return
+	}
+
+	labelService, labelMethod := metricsLabels(serviceName, method)
+	cc := parseCacheControl(string(ctx.GetHeader("Cache-Control")))
+	key, err := cacheKey(c, ctx, rule, serviceName, method)
+	if err != nil || cc.noStore || len(ctx.GetHeader("If-Match")) > 0 {
+		cacheRequestsTotal.WithLabelValues(labelService, labelMethod, "bypass").Inc()
+		ctx.Next(c)
+		return
+	}
+
+	now := time.Now()
+	if !cc.noCache {
+		resp, ok, err := responses.Get(c, key)
+		if err == nil && ok && acceptsCached(cc, resp, now) {
+			cacheRequestsTotal.WithLabelValues(labelService, labelMethod, "hit").Inc()
+			writeCachedResponse(ctx, resp, now)
+			ctx.Abort()
+			return
+		}
+	}
+
+	cacheRequestsTotal.WithLabelValues(labelService, labelMethod, "miss").Inc()
+	ctx.Next(c)
+	ctx.Header("X-Cache", "MISS")
+
+	if ctx.Response.StatusCode() != consts.StatusOK || parseCacheControl(string(ctx.Response.Header.Peek("Cache-Control"))).noStore {
+		return
+	}
+	_ = responses.Set(c, key, cachedResponse{
+		Status:      consts.StatusOK,
+		ContentType: string(ctx.Response.Header.ContentType()),
+		Body:        append([]byte(nil), ctx.Response.Body()...),
+		ETag:        entityTag(ctx.Response.Body()),
+		Stored:      now,
+		Expires:     now.Add(time.Duration(rule.TTL)),
+	})
+}
+
+// purgeCache removes the cached responses whose key starts with the prefix query parameter,
+// such as "ServiceA/" or "ServiceA/methodA/", and answers with how many were removed.
+func purgeCache(c context.Context, ctx *app.RequestContext) {
+	if responses == nil {
+		ctx.SetStatusCode(http.StatusNotFound)
+		ctx.String(consts.StatusNotFound, "Response cache is disabled")
+		return
+	}
+	purged, err := responses.Purge(c, ctx.Query("prefix"))
+	if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.String(consts.StatusInternalServerError, "Fail to purge response cache")
+		return
+	}
+	ctx.JSON(consts.StatusOK, map[string]int{"purged": purged})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func useResponseCache(t *testing.T, rule cacheRule) {
+	config = &gatewayConfig{Cache: cacheConfig{Rules: []cacheRule{rule}}}
+	responses = newMemoryCache(0, 0)
+	t.Cleanup(func() {
+		config = &gatewayConfig{}
+		responses = nil
+	})
+}
+
+// cachedCall sends a request with body and headers through cacheResponse to a handler counting its calls.
+func cachedCall(body string, calls *int, headers ...string) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, "/ServiceA/methodA", nil),
+	}
+	ctx.Request.SetBodyString(body)
+	for i := 0; i+1 < len(headers); i += 2 {
+		ctx.Request.SetHeader(headers[i], headers[i+1])
+	}
+	ctx.SetHandlers(app.HandlersChain{cacheResponse, func(_ context.Context, ctx *app.RequestContext) {
+		*calls++
+		ctx.JSON(consts.StatusOK, map[string]int{"call": *calls})
+	}})
+	cacheResponse(context.Background(), ctx)
+	return ctx
+}
+
+func TestMemoryCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := context.Background()
+	expires := time.Now().Add(time.Minute)
+	m := newMemoryCache(2, 1000)
+
+	assert.NoError(t, m.Set(c, "a", cachedResponse{Body: []byte("1"), Expires: expires}))
+	assert.NoError(t, m.Set(c, "b", cachedResponse{Body: []byte("2"), Expires: expires}))
+	_, ok, _ := m.Get(c, "a")
+	assert.True(t, ok)
+	assert.NoError(t, m.Set(c, "c", cachedResponse{Body: []byte("3"), Expires: expires}))
+
+	_, ok, _ = m.Get(c, "b")
+	assert.False(t, ok, "b is the least recently used")
+	_, ok, _ = m.Get(c, "a")
+	assert.True(t, ok)
+
+	small := newMemoryCache(10, 10)
+	assert.NoError(t, small.Set(c, "a", cachedResponse{Body: []byte("12345"), Expires: expires}))
+	assert.NoError(t, small.Set(c, "b", cachedResponse{Body: []byte("12345"), Expires: expires}))
+	_, ok, _ = small.Get(c, "a")
+	assert.False(t, ok, "a is evicted to stay within the byte limit")
+
+	assert.NoError(t, m.Set(c, "expired", cachedResponse{Expires: time.Now().Add(-time.Second)}))
+	_, ok, _ = m.Get(c, "expired")
+	assert.False(t, ok)
+}
+
+func TestMemoryCache_PurgeByPrefix(t *testing.T) {
+	c := context.Background()
+	expires := time.Now().Add(time.Minute)
+	m := newMemoryCache(0, 0)
+	for _, key := range []string{"ServiceA/methodA/1", "ServiceA/methodB/1", "ServiceB/methodA/1"} {
+		assert.NoError(t, m.Set(c, key, cachedResponse{Expires: expires}))
+	}
+
+	purged, err := m.Purge(c, "ServiceA/")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	_, ok, _ := m.Get(c, "ServiceB/methodA/1")
+	assert.True(t, ok)
+}
+
+func TestCacheKey_CanonicalFieldsAndHeaders(t *testing.T) {
+	rule := &cacheRule{KeyFields: []string{"message"}, KeyHeaders: []string{"Accept-Language"}}
+	keyOf := func(body, language, owner string) string {
+		ctx := &app.RequestContext{Request: *protocol.NewRequest(http.MethodPost, "/ServiceA/methodA", nil)}
+		ctx.Request.SetBodyString(body)
+		ctx.Request.SetHeader("Accept-Language", language)
+		c := context.Background()
+		if owner != "" {
+			ctx.Set(apiKeyOwnerKey, owner)
+			c = metainfo.WithValue(c, apiKeyOwnerMetaKey, owner)
+		}
+		k, err := cacheKey(metainfo.WithValue(c, requestIDMetaKey, newRequestID()), ctx, rule, "ServiceA", "methodA")
+		assert.NoError(t, err)
+		return k
+	}
+	key := func(body, language string) string {
+		return keyOf(body, language, "")
+	}
+
+	assert.Equal(t, key(`{"message": "hi", "trace": 1}`, "en"), key(`{"trace":2,"message":"hi"}`, "en"))
+	assert.NotEqual(t, key(`{"message": "hi"}`, "en"), key(`{"message": "bye"}`, "en"))
+	assert.NotEqual(t, key(`{"message": "hi"}`, "en"), key(`{"message": "hi"}`, "fr"))
+	assert.Regexp(t, "^ServiceA/methodA/[0-9a-f]{64}$", key(`{}`, ""))
+	assert.Equal(t, keyOf(`{}`, "en", "alice"), keyOf(`{}`, "en", "alice"))
+	assert.NotEqual(t, keyOf(`{}`, "en", "alice"), keyOf(`{}`, "en", "bob"), "responses are cached per caller")
+	assert.NotEqual(t, keyOf(`{}`, "en", "alice"), key(`{}`, "en"))
+}
+
+func TestCacheResponse_HitsAndCacheControl(t *testing.T) {
+	useResponseCache(t, cacheRule{Route: "ServiceA/methodA", TTL: duration(time.Minute)})
+	calls := 0
+
+	ctx := cachedCall(`{"message": "hi"}`, &calls)
+	assert.Equal(t, "MISS", string(ctx.Response.Header.Peek("X-Cache")))
+
+	ctx = cachedCall(`{ "message":"hi" }`, &calls)
+	assert.Equal(t, "HIT", string(ctx.Response.Header.Peek("X-Cache")))
+	assert.JSONEq(t, `{"call": 1}`, string(ctx.Response.Body()))
+	assert.Equal(t, 1, calls)
+
+	ctx = cachedCall(`{"message": "hi"}`, &calls, "Cache-Control", "no-cache")
+	assert.JSONEq(t, `{"call": 2}`, string(ctx.Response.Body()))
+	ctx = cachedCall(`{"message": "hi"}`, &calls)
+	assert.JSONEq(t, `{"call": 2}`, string(ctx.Response.Body()), "no-cache refreshes the cached response")
+
+	ctx = cachedCall(`{"message": "hi"}`, &calls, "Cache-Control", "no-store")
+	assert.JSONEq(t, `{"call": 3}`, string(ctx.Response.Body()))
+	ctx = cachedCall(`{"message": "hi"}`, &calls)
+	assert.JSONEq(t, `{"call": 2}`, string(ctx.Response.Body()), "no-store does not store the response")
+
+	_, err := responses.Purge(context.Background(), "ServiceA/methodA/")
+	assert.NoError(t, err)
+	cachedCall(`{"message": "hi"}`, &calls)
+	assert.Equal(t, 4, calls)
+}
+
+func TestCacheResponse_MetricsOfMethodsOfIDL(t *testing.T) {
+	useFakeBackend(t, nil)
+	useServiceIDLs(t)
+	assert.NoError(t, buildMetricsMethods())
+	useResponseCache(t, cacheRule{Route: "ServiceA/*", TTL: duration(time.Minute)})
+
+	misses := testutil.ToFloat64(cacheRequestsTotal.WithLabelValues("ServiceA", "unknown", "miss"))
+	ctx := &app.RequestContext{Request: *protocol.NewRequest(http.MethodPost, "/ServiceA/random-1234", nil)}
+	ctx.Request.SetBodyString(`{"message": "hi"}`)
+	ctx.SetHandlers(app.HandlersChain{cacheResponse, func(_ context.Context, ctx *app.RequestContext) {}})
+	cacheResponse(context.Background(), ctx)
+
+	assert.Equal(t, misses+1, testutil.ToFloat64(cacheRequestsTotal.WithLabelValues("ServiceA", "unknown", "miss")))
+}
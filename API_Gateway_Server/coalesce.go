@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var inflightCalls = struct {
+	sync.Mutex
+	calls map[string]*inflightCall
+}{calls: make(map[string]*inflightCall)}
+
+var (
+	coalescedRequestsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_coalesced_requests_total",
+		Help: "Requests to coalesced methods, by whether they made the backend call or shared another's.",
+	}, []string{"service", "method", "role"})
+
+	coalescedCallsSavedTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_coalesced_calls_saved_total",
+		Help: "Backend calls saved by sharing the result of an identical call in flight.",
+	}, []string{"service", "method"})
+)
+
+// coalesceConfig enables the coalescing of the concurrent identical requests to the routes matching one of Routes.
+type coalesceConfig struct {
+	Routes []string `json:"routes"`
+}
+
+// sharedResponse is the response of a coalesced request, given to every identical request waiting for it.
+// A cancelled response is that of a request whose context ended during its call, which the requests waiting
+// for it do not take.
+type sharedResponse struct {
+	status      int
+	contentType string
+	body        []byte
+	cancelled   bool
+}
+
+// inflightCall is a coalesced call in flight, whose response is set before done is closed.
+type inflightCall struct {
+	done chan struct{}
+	resp sharedResponse
+}
+
+// coalesce is the middleware letting the concurrent identical requests to the coalesced methods,
+// with the same service, method, canonical body and caller key, share one backend call. The first request
+// makes the call and the others wait for its response, after being authenticated and authorized themselves,
+// until their own context ends. The requests waiting for a request whose context ended during its call make
+// their own. Requests with If-Match are not coalesced.
+func coalesce(c context.Context, ctx *app.RequestContext) {
+	serviceName, method := routeOf(ctx)
+
+	enabled := false
+	for _, pattern := range config.Coalesce.Routes {
+		if matchRoute(pattern, serviceName, method) {
+			enabled = true
+			break
+		}
+	}
+	// A conditional write must reach the backend checking its precondition.
+	if !enabled || len(ctx.GetHeader("If-Match")) > 0 {
+		ctx.Next(c)
+		return
+	}
+
+	canonical, err := canonicalBody(ctx.Request.Body(), nil)
+	if err != nil {
+		ctx.Next(c)
+		return
+	}
+	h := sha256.New()
+	h.Write(canonical)
+	h.Write([]byte{0})
+	h.Write([]byte(callerKey(c, ctx)))
+	key := serviceName + "/" + method + "/" + hex.EncodeToString(h.Sum(nil))
+
+	labelService, labelMethod := metricsLabels(serviceName, method)
+	inflightCalls.Lock()
+	call, follower := inflightCalls.calls[key]
+	if !follower {
+		call = &inflightCall{done: make(chan struct{})}
+		inflightCalls.calls[key] = call
+	}
+	inflightCalls.Unlock()
+
+	if !follower {
+		// The waiting requests make their own call if this one panics.
+		call.resp.cancelled = true
+		defer func() {
+			inflightCalls.Lock()
+			delete(inflightCalls.calls, key)
+			inflightCalls.Unlock()
+			close(call.done)
+		}()
+		coalescedRequestsTotal.WithLabelValues(labelService, labelMethod, "leader").Inc()
+		ctx.Next(c)
+		call.resp = sharedResponse{
+			status:      ctx.Response.StatusCode(),
+			contentType: string(ctx.Response.Header.ContentType()),
+			body:        append([]byte(nil), ctx.Response.Body()...),
+			cancelled:   c.Err() != nil,
+		}
+		return
+	}
+
+	select {
+	case <-call.done:
+	case <-c.Done():
+		setErrorClass(ctx, "coalesce_wait")
+		ctx.AbortWithMsg("Request ended while waiting for an identical call", http.StatusGatewayTimeout)
+		return
+	}
+	if call.resp.cancelled {
+		ctx.Next(c)
+		return
+	}
+	coalescedRequestsTotal.WithLabelValues(labelService, labelMethod, "follower").Inc()
+	coalescedCallsSavedTotal.WithLabelValues(labelService, labelMethod).Inc()
+
+	ctx.SetStatusCode(call.resp.status)
+	ctx.Response.Header.SetContentType(call.resp.contentType)
+	ctx.Response.SetBody(call.resp.body)
+	ctx.Header("X-Coalesced", "true")
+	ctx.Abort()
+}
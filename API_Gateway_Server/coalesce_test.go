@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// useCoalescing coalesces the calls to the routes matching one of routes, with the metrics labelled by the methods
+// of the IDL of the RPC server.
+func useCoalescing(t *testing.T, routes ...string) {
+	useFakeBackend(t, nil)
+	useServiceIDLs(t)
+	assert.NoError(t, buildMetricsMethods())
+	config = &gatewayConfig{Coalesce: coalesceConfig{Routes: routes}}
+	t.Cleanup(func() {
+		config = &gatewayConfig{}
+	})
+}
+
+func TestCoalesce_SharesOneCall(t *testing.T) {
+	useCoalescing(t, "ServiceA/methodB")
+
+	var calls int32
+	release := make(chan struct{})
+	backend := func(_ context.Context, ctx *app.RequestContext) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		ctx.JSON(consts.StatusOK, map[string]string{"Msg": "hi"})
+	}
+
+	saved := testutil.ToFloat64(coalescedCallsSavedTotal.WithLabelValues("ServiceA", "methodB"))
+	const requests = 5
+	contexts := make([]*app.RequestContext, requests)
+	var wg sync.WaitGroup
+	for i := range contexts {
+		ctx := &app.RequestContext{
+			Request: *protocol.NewRequest(http.MethodPost, "/ServiceA/methodB", nil),
+		}
+		if i%2 == 0 {
+			ctx.Request.SetBodyString(`{"message": "hi", "id": 1}`)
+		} else {
+			ctx.Request.SetBodyString(`{"id":1,"message":"hi"}`)
+		}
+		ctx.SetHandlers(app.HandlersChain{coalesce, backend})
+		contexts[i] = ctx
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			coalesce(context.Background(), ctx)
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 1
+	}, time.Second, time.Millisecond)
+	// Let the other requests join the call in flight before it completes.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, ctx := range contexts {
+		assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+		assert.JSONEq(t, `{"Msg": "hi"}`, string(ctx.Response.Body()))
+	}
+	assert.Equal(t, float64(requests-1), testutil.ToFloat64(coalescedCallsSavedTotal.WithLabelValues("ServiceA", "methodB"))-saved)
+}
+
+func TestCoalesce_DifferentBodiesCallSeparately(t *testing.T) {
+	config = &gatewayConfig{Coalesce: coalesceConfig{Routes: []string{"ServiceA/*"}}}
+	t.Cleanup(func() {
+		config = &gatewayConfig{}
+	})
+
+	calls := 0
+	for _, body := range []string{`{"message": "hi"}`, `{"message": "bye"}`} {
+		ctx := &app.RequestContext{
+			Request: *protocol.NewRequest(http.MethodPost, "/ServiceA/methodA", nil),
+		}
+		ctx.Request.SetBodyString(body)
+		ctx.SetHandlers(app.HandlersChain{coalesce, func(context.Context, *app.RequestContext) { calls++ }})
+		coalesce(context.Background(), ctx)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestCoalesce_CallersCallSeparately(t *testing.T) {
+	useJWTConfig(t, jwtConfig{HMACSecret: testSecret, Rules: []jwtRule{{
+		Route:         "ServiceA/*",
+		ClaimMetadata: map[string]string{"sub": "user_id"},
+	}}})
+	config.Coalesce = coalesceConfig{Routes: []string{"ServiceA/*"}}
+
+	var calls int32
+	release := make(chan struct{})
+	backend := func(c context.Context, ctx *app.RequestContext) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		ctx.String(consts.StatusOK, (&metainfoCarrier{ctx: c}).Get("user_id"))
+	}
+
+	subjects := []string{"alice", "bob"}
+	contexts := make([]*app.RequestContext, len(subjects))
+	var wg sync.WaitGroup
+	for i, subject := range subjects {
+		claims := validClaims()
+		claims["sub"] = subject
+		ctx := newJWTContext("/ServiceA/methodA", signHS256(t, claims), `{"message": "hi"}`)
+		ctx.SetHandlers(app.HandlersChain{jwtAuth, coalesce, backend})
+		contexts[i] = ctx
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jwtAuth(context.Background(), ctx)
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 2
+	}, time.Second, time.Millisecond, "the requests of other callers do not share a call")
+	close(release)
+	wg.Wait()
+
+	for i, ctx := range contexts {
+		assert.Equal(t, subjects[i], string(ctx.Response.Body()))
+		assert.Empty(t, ctx.Response.Header.Peek("X-Coalesced"))
+	}
+}
+
+func TestCoalesce_FollowerEndsWithItsContext(t *testing.T) {
+	useCoalescing(t, "ServiceA/*")
+
+	release := make(chan struct{})
+	var calls int32
+	backend := func(c context.Context, ctx *app.RequestContext) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-release:
+		case <-c.Done():
+		}
+		ctx.JSON(consts.StatusOK, map[string]int32{"call": atomic.LoadInt32(&calls)})
+	}
+	newCall := func() *app.RequestContext {
+		ctx := &app.RequestContext{Request: *protocol.NewRequest(http.MethodPost, "/ServiceA/random-1234", nil)}
+		ctx.Request.SetBodyString(`{"message": "hi"}`)
+		ctx.SetHandlers(app.HandlersChain{coalesce, backend})
+		return ctx
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leader := newCall()
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		coalesce(leaderCtx, leader)
+	}()
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 1
+	}, time.Second, time.Millisecond)
+
+	followerCtx, cancelFollower := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFollower()
+	impatient := newCall()
+	coalesce(followerCtx, impatient)
+	assert.Equal(t, http.StatusGatewayTimeout, impatient.Response.StatusCode(), "a follower does not wait past its context")
+
+	patient := newCall()
+	patientDone := make(chan struct{})
+	go func() {
+		defer close(patientDone)
+		coalesce(context.Background(), patient)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancelLeader()
+	<-leaderDone
+	close(release)
+	<-patientDone
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "a follower makes its own call when the context of the leader ends")
+	assert.JSONEq(t, `{"call": 2}`, string(patient.Response.Body()))
+	assert.Empty(t, patient.Response.Header.Peek("X-Coalesced"))
+}
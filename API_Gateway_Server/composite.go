@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// defaultCompositeTimeout is how long a call of a composite route may take when it sets no timeout.
+const defaultCompositeTimeout = 5 * time.Second
+
+// requestScope is the name the templates of a composite route refer to the request body under.
+const requestScope = "request"
+
+var composites map[string]*composite
+
+var errCompositeTimeout = errors.New("timed out")
+var errCompositeCancelled = errors.New("cancelled")
+
+// placeholder matches the references of a template, such as ${request.userId} or ${a.message}.
+var placeholder = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// compositeConfig defines the composite routes, keyed by their "service/method" path, answered by
+// making several calls and merging their responses instead of calling a backend service.
+type compositeConfig struct {
+	Routes map[string]compositeRoute `json:"routes"`
+}
+
+// compositeRoute makes Calls, concurrently except for the calls referring to the responses of others,
+// and answers with Response, a JSON template referring to the request body and the responses of the calls.
+// It answers with the responses keyed by call name when Response is empty.
+type compositeRoute struct {
+	Calls    []compositeCall `json:"calls"`
+	Response json.RawMessage `json:"response"`
+}
+
+// compositeCall calls Method of Service with Body, a JSON template, within Timeout.
+// The composite route fails if the call fails, unless it is Optional.
+//
+// Templates refer to the request body as ${request} and to the response of a call as ${name},
+// and to their fields with dotted paths such as ${request.userId} or ${a.message}.
+// A string consisting of a single reference is replaced by the value referred to, null when
+// it does not exist, and the references within longer strings by the text of the value.
+type compositeCall struct {
+	Name     string          `json:"name"`
+	Service  string          `json:"service"`
+	Method   string          `json:"method"`
+	Body     json.RawMessage `json:"body"`
+	Timeout  duration        `json:"timeout"`
+	Optional bool            `json:"optional"`
+}
+
+// composite is a composite route ready to be answered.
+type composite struct {
+	steps    []compositeStep
+	response interface{}
+}
+
+// compositeStep is a call of a composite route with its decoded body template
+// and the indexes of the calls whose responses it refers to.
+type compositeStep struct {
+	compositeCall
+	body interface{}
+	deps []int
+}
+
+// decodeTemplate decodes the JSON template data, an empty template decoding to nil.
+func decodeTemplate(data json.RawMessage) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	err := decoder.Decode(&v)
+	return v, err
+}
+
+// references returns the names the template v refers to, the first segment of its references.
+func references(v interface{}) []string {
+	var names []string
+	switch t := v.(type) {
+	case string:
+		for _, m := range placeholder.FindAllStringSubmatch(t, -1) {
+			name, _, _ := strings.Cut(m[1], ".")
+			names = append(names, name)
+		}
+	case map[string]interface{}:
+		for _, e := range t {
+			names = append(names, references(e)...)
+		}
+	case []interface{}:
+		for _, e := range t {
+			names = append(names, references(e)...)
+		}
+	}
+	return names
+}
+
+// newComposites validates the composite routes of cfg and prepares them to be answered.
+// It returns an error if a template is invalid, refers to an unknown call, or the calls refer to each other in a cycle.
+func newComposites(cfg compositeConfig) (map[string]*composite, error) {
+	routes := make(map[string]*composite, len(cfg.Routes))
+	for path, route := range cfg.Routes {
+		if len(route.Calls) == 0 {
+			return nil, fmt.Errorf("composite route %s has no calls", path)
+		}
+
+		index := make(map[string]int, len(route.Calls))
+		for i, call := range route.Calls {
+			if call.Name == "" || call.Name == requestScope || call.Service == "" || call.Method == "" {
+				return nil, fmt.Errorf("composite route %s: call %d needs a name other than %q, a service and a method", path, i, requestScope)
+			}
+			if _, ok := index[call.Name]; ok {
+				return nil, fmt.Errorf("composite route %s: duplicate call %s", path, call.Name)
+			}
+			if _, ok := cfg.Routes[call.Service+"/"+call.Method]; ok {
+				return nil, fmt.Errorf("composite route %s: call %s to composite route", path, call.Name)
+			}
+			index[call.Name] = i
+		}
+
+		c := &composite{steps: make([]compositeStep, len(route.Calls))}
+		for i, call := range route.Calls {
+			body, err := decodeTemplate(call.Body)
+			if err != nil {
+				return nil, fmt.Errorf("composite route %s: call %s: %w", path, call.Name, err)
+			}
+			step := compositeStep{compositeCall: call, body: body}
+			for _, name := range references(body) {
+				if name == requestScope {
+					continue
+				}
+				dep, ok := index[name]
+				if !ok || dep == i {
+					return nil, fmt.Errorf("composite route %s: call %s refers to unknown call %s", path, call.Name, name)
+				}
+				step.deps = append(step.deps, dep)
+			}
+			c.steps[i] = step
+		}
+
+		var err error
+		c.response, err = decodeTemplate(route.Response)
+		if err != nil {
+			return nil, fmt.Errorf("composite route %s: response: %w", path, err)
+		}
+		for _, name := range references(c.response) {
+			if _, ok := index[name]; !ok && name != requestScope {
+				return nil, fmt.Errorf("composite route %s: response refers to unknown call %s", path, name)
+			}
+		}
+
+		if compositeCycle(c.steps) {
+			return nil, fmt.Errorf("composite route %s: calls refer to each other in a cycle", path)
+		}
+		routes[path] = c
+	}
+	return routes, nil
+}
+
+// compositeCycle reports whether the steps depend on each other in a cycle.
+func compositeCycle(steps []compositeStep) bool {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(steps))
+	var visit func(i int) bool
+	visit = func(i int) bool {
+		switch state[i] {
+		case visiting:
+			return true
+		case visited:
+			return false
+		}
+		state[i] = visiting
+		for _, dep := range steps[i].deps {
+			if visit(dep) {
+				return true
+			}
+		}
+		state[i] = visited
+		return false
+	}
+	for i := range steps {
+		if visit(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the value at the dotted path in scope, and whether it exists.
+func lookup(scope map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = scope
+	for _, segment := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			v, ok = t[segment]
+			if !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, false
+			}
+			v = t[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// render returns the template v with its references replaced by the values they refer to in scope.
+func render(v interface{}, scope map[string]interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		if m := placeholder.FindStringSubmatch(t); m != nil && m[0] == t {
+			value, _ := lookup(scope, m[1])
+			return value
+		}
+		return placeholder.ReplaceAllStringFunc(t, func(ref string) string {
+			value, ok := lookup(scope, ref[2:len(ref)-1])
+			if !ok || value == nil {
+				return ""
+			}
+			if s, ok := value.(string); ok {
+				return s
+			}
+			text, _ := json.Marshal(value)
+			return string(text)
+		})
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			rendered[k] = render(e, scope)
+		}
+		return rendered
+	case []interface{}:
+		rendered := make([]interface{}, len(t))
+		for i, e := range t {
+			rendered[i] = render(e, scope)
+		}
+		return rendered
+	}
+	return v
+}
+
+// callStep makes the call of step with body on behalf of origin within the timeout of the step.
+// It returns the decoded response of the call, or an error if it fails, times out or c is cancelled,
+// which the call is then made with.
+func callStep(c context.Context, origin *callOrigin, step *compositeStep, body []byte) (interface{}, error) {
+	timeout := time.Duration(step.Timeout)
+	if timeout <= 0 {
+		timeout = defaultCompositeTimeout
+	}
+	c, cancel := context.WithTimeout(c, timeout)
+	defer cancel()
+
+	answered := make(chan *app.RequestContext, 1)
+	go func() {
+		answered <- origin.dispatch(c, step.Name, step.Service, step.Method, body)
+	}()
+
+	var call *app.RequestContext
+	select {
+	case call = <-answered:
+	case <-c.Done():
+		if errors.Is(c.Err(), context.Canceled) {
+			return nil, errCompositeCancelled
+		}
+		return nil, errCompositeTimeout
+	}
+
+	if call.Response.StatusCode() != consts.StatusOK {
+		return nil, fmt.Errorf("status %d: %s", call.Response.StatusCode(), call.Response.Body())
+	}
+	return decodeTemplate(call.Response.Body())
+}
+
+// compose answers ctx by making the calls of the composite route and rendering its response.
+// Calls wait for the calls they refer to, and are skipped if one of those failed.
+// The request fails with 502, or 504 on timeout, if a call that is not optional fails,
+// cancelling the calls in flight and skipping those not made yet.
+func compose(c context.Context, ctx *app.RequestContext, route *composite) {
+	request, err := decodeTemplate(ctx.Request.Body())
+	if _, ok := request.(map[string]interface{}); err != nil || !ok {
+		setErrorClass(ctx, "invalid_request")
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.String(consts.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+
+	origin := newCallOrigin(c, ctx)
+	c, cancel := context.WithCancel(c)
+	defer cancel()
+	failed := -1
+	var failOnce sync.Once
+	n := len(route.steps)
+	results := make([]interface{}, n)
+	failures := make([]error, n)
+	done := make([]chan struct{}, n)
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	for i := range route.steps {
+		go func(i int) {
+			defer close(done[i])
+			step := &route.steps[i]
+			defer func() {
+				if failures[i] != nil && !step.Optional {
+					failOnce.Do(func() {
+						failed = i
+						cancel()
+					})
+				}
+			}()
+
+			scope := map[string]interface{}{requestScope: request}
+			for _, dep := range step.deps {
+				<-done[dep]
+				if failures[dep] != nil {
+					failures[i] = fmt.Errorf("skipped, call %s failed", route.steps[dep].Name)
+					return
+				}
+				scope[route.steps[dep].Name] = results[dep]
+			}
+
+			if c.Err() != nil {
+				failures[i] = errCompositeCancelled
+				return
+			}
+			body, err := json.Marshal(render(step.body, scope))
+			if err != nil {
+				failures[i] = err
+				return
+			}
+			results[i], failures[i] = callStep(c, origin, step, body)
+		}(i)
+	}
+
+	for i := range route.steps {
+		<-done[i]
+	}
+	if failed >= 0 {
+		status := http.StatusBadGateway
+		if errors.Is(failures[failed], errCompositeTimeout) {
+			status = http.StatusGatewayTimeout
+		}
+		setErrorClass(ctx, "composite_call")
+		ctx.SetStatusCode(status)
+		ctx.String(status, "Required call %s failed: %s", route.steps[failed].Name, failures[failed])
+		return
+	}
+
+	scope := map[string]interface{}{requestScope: request}
+	for i := range route.steps {
+		if failures[i] == nil {
+			scope[route.steps[i].Name] = results[i]
+		}
+	}
+
+	if route.response == nil {
+		delete(scope, requestScope)
+		ctx.JSON(consts.StatusOK, scope)
+		return
+	}
+	ctx.JSON(consts.StatusOK, render(route.response, scope))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+// useComposites makes the composite routes of the JSON configuration cfg the only ones.
+func useComposites(t *testing.T, cfg string) {
+	var composite compositeConfig
+	assert.NoError(t, json.Unmarshal([]byte(cfg), &composite))
+	var err error
+	composites, err = newComposites(composite)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		composites = nil
+	})
+}
+
+func composedCall(body string) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, "/Profile/get", nil),
+	}
+	ctx.Request.SetHeader("Content-Type", "application/json")
+	ctx.Request.SetBodyString(body)
+	decode(context.Background(), ctx)
+	return ctx
+}
+
+func TestNewComposites_Validates(t *testing.T) {
+	for name, cfg := range map[string]string{
+		"no calls":     `{"routes": {"Profile/get": {}}}`,
+		"unknown call": `{"routes": {"Profile/get": {"calls": [{"name": "a", "service": "ServiceA", "method": "methodA", "body": {"m": "${b.message}"}}]}}}`,
+		"cycle": `{"routes": {"Profile/get": {"calls": [
+			{"name": "a", "service": "ServiceA", "method": "methodA", "body": {"m": "${b.message}"}},
+			{"name": "b", "service": "ServiceB", "method": "methodB", "body": {"m": "${a.message}"}}
+		]}}}`,
+		"duplicate":        `{"routes": {"Profile/get": {"calls": [{"name": "a", "service": "ServiceA", "method": "methodA"}, {"name": "a", "service": "ServiceB", "method": "methodB"}]}}}`,
+		"unknown response": `{"routes": {"Profile/get": {"calls": [{"name": "a", "service": "ServiceA", "method": "methodA"}], "response": {"x": "${c}"}}}}`,
+		"recursive":        `{"routes": {"Profile/get": {"calls": [{"name": "a", "service": "Profile", "method": "get"}]}}}`,
+	} {
+		var composite compositeConfig
+		assert.NoError(t, json.Unmarshal([]byte(cfg), &composite), name)
+		_, err := newComposites(composite)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestRender(t *testing.T) {
+	scope := map[string]interface{}{
+		"request": map[string]interface{}{"userId": "u1", "tags": []interface{}{"x", "y"}},
+		"a":       map[string]interface{}{"message": "hi", "count": json.Number("2")},
+	}
+	template, err := decodeTemplate(json.RawMessage(`{
+		"user": "${request.userId}",
+		"count": "${a.count}",
+		"text": "${a.message} ${request.userId}, ${a.count} of ${request.tags.1}",
+		"missing": "${b.message}",
+		"list": ["${request.tags.0}"]
+	}`))
+	assert.NoError(t, err)
+
+	rendered, err := json.Marshal(render(template, scope))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"user": "u1", "count": 2, "text": "hi u1, 2 of y", "missing": null, "list": ["x"]}`, string(rendered))
+}
+
+func TestCompose_ChainsAndMergesCalls(t *testing.T) {
+	useFakeBackend(t, func(_ context.Context, method, request string) (string, error) {
+		var req map[string]string
+		_ = json.Unmarshal([]byte(request), &req)
+		switch method {
+		case "methodA":
+			return `{"message": "hello ` + req["userId"] + `"}`, nil
+		case "methodC":
+			return "", errors.New("backend down")
+		}
+		return `{"message": "` + req["message"] + `!"}`, nil
+	})
+	useComposites(t, `{"routes": {"Profile/get": {
+		"calls": [
+			{"name": "a", "service": "ServiceA", "method": "methodA", "body": {"userId": "${request.userId}"}},
+			{"name": "b", "service": "ServiceB", "method": "methodB", "body": {"message": "${a.message}"}},
+			{"name": "c", "service": "ServiceB", "method": "methodC", "optional": true}
+		],
+		"response": {"greeting": "${a.message}", "shout": "${b.message}", "extra": "${c.message}"}
+	}}}`)
+
+	ctx := composedCall(`{"userId": "u1"}`)
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"greeting": "hello u1", "shout": "hello u1!", "extra": null}`, string(ctx.Response.Body()))
+}
+
+func TestCompose_RequiredCallFails(t *testing.T) {
+	abandoned := make(chan struct{})
+	useFakeBackend(t, func(_ context.Context, method, _ string) (string, error) {
+		if method == "methodB" {
+			defer close(abandoned)
+			time.Sleep(100 * time.Millisecond)
+		}
+		if method == "methodC" {
+			return "", errors.New("backend down")
+		}
+		return `{"message": "hi"}`, nil
+	})
+
+	useComposites(t, `{"routes": {"Profile/get": {"calls": [
+		{"name": "a", "service": "ServiceA", "method": "methodA"},
+		{"name": "b", "service": "ServiceB", "method": "methodB", "timeout": "10ms"}
+	]}}}`)
+	ctx := composedCall(`{}`)
+	assert.Equal(t, http.StatusGatewayTimeout, ctx.Response.StatusCode())
+	assert.Equal(t, "Required call b failed: timed out", string(ctx.Response.Body()))
+	<-abandoned
+
+	useComposites(t, `{"routes": {"Profile/get": {"calls": [
+		{"name": "c", "service": "ServiceB", "method": "methodC"},
+		{"name": "a", "service": "ServiceA", "method": "methodA", "body": {"message": "${c.message}"}, "optional": true}
+	]}}}`)
+	ctx = composedCall(`{}`)
+	assert.Equal(t, http.StatusBadGateway, ctx.Response.StatusCode())
+	assert.Contains(t, string(ctx.Response.Body()), "Required call c failed: status 500")
+
+	useComposites(t, `{"routes": {"Profile/get": {"calls": [{"name": "a", "service": "ServiceA", "method": "methodA"}]}}}`)
+	ctx = composedCall(`{}`)
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"a": {"message": "hi"}}`, string(ctx.Response.Body()))
+}
+
+func TestCompose_RequiredFailureCancelsOtherCalls(t *testing.T) {
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	var dependentCalled int32
+	useFakeBackend(t, func(c context.Context, method, _ string) (string, error) {
+		switch method {
+		case "methodA":
+			close(started)
+			select {
+			case <-c.Done():
+				close(cancelled)
+				return "", c.Err()
+			case <-time.After(time.Second):
+			}
+		case "methodB":
+			atomic.AddInt32(&dependentCalled, 1)
+		case "methodC":
+			<-started
+			return "", errors.New("backend down")
+		}
+		return `{"message": "hi"}`, nil
+	})
+
+	useComposites(t, `{"routes": {"Profile/get": {"calls": [
+		{"name": "slow", "service": "ServiceA", "method": "methodA"},
+		{"name": "next", "service": "ServiceA", "method": "methodB", "body": {"message": "${slow.message}"}},
+		{"name": "failing", "service": "ServiceB", "method": "methodC"}
+	]}}}`)
+	start := time.Now()
+	ctx := composedCall(`{}`)
+	assert.Equal(t, http.StatusBadGateway, ctx.Response.StatusCode())
+	assert.Contains(t, string(ctx.Response.Body()), "Required call failing failed: status 500")
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "the request does not wait for the other calls")
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the call in flight is not cancelled")
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&dependentCalled), "the calls depending on a cancelled call are not made")
+}
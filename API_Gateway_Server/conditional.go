@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/cloudwego/kitex/pkg/kerrors"
+)
+
+// ifMatchMetaKey is the Kitex metadata key the If-Match header is forwarded to the backend under.
+const ifMatchMetaKey = "if_match"
+
+// preconditionFailedStatus is the status code of the Kitex biz status error backends fail a call with
+// when the entity tags of the if_match metadata do not match the current state of the resource.
+const preconditionFailedStatus = 412
+
+// entityTag returns the strong entity tag of a response body, the quoted start of its SHA-256 digest.
+func entityTag(body []byte) string {
+	digest := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(digest[:16]) + `"`
+}
+
+// matchEntityTag reports whether etag matches one of the entity tags of the If-None-Match header value,
+// or the header value is "*". The comparison is weak: a W/ prefix is ignored.
+func matchEntityTag(header, etag string) bool {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isPreconditionFailed reports whether err is a backend rejecting the call for a failed If-Match precondition.
+func isPreconditionFailed(err error) bool {
+	bizErr, ok := kerrors.FromBizStatusError(err)
+	return ok && bizErr.BizStatusCode() == preconditionFailedStatus
+}
+
+// conditional is the middleware supporting conditional requests. The If-Match header of any request is
+// forwarded to the backend as the if_match metadata, for the backend to check against the current state
+// of the resource. The successful responses of cacheable methods carry an ETag computed from their body,
+// and requests whose If-None-Match matches it are answered with 304 Not Modified and no body, before
+// calling the backend when the response is cached.
+func conditional(c context.Context, ctx *app.RequestContext) {
+	if ifMatch := ctx.GetHeader("If-Match"); len(ifMatch) > 0 {
+		c = metainfo.WithValue(c, ifMatchMetaKey, string(ifMatch))
+	}
+
+	serviceName, method := routeOf(ctx)
+	rule := cacheRuleFor(serviceName, method)
+	if rule == nil {
+		ctx.Next(c)
+		return
+	}
+
+	ifNoneMatch := string(ctx.GetHeader("If-None-Match"))
+	if ifNoneMatch != "" {
+		if etag, ok := cachedEntityTag(c, ctx, rule, serviceName, method); ok && matchEntityTag(ifNoneMatch, etag) {
+			ctx.Header("ETag", etag)
+			ctx.SetStatusCode(consts.StatusNotModified)
+			ctx.Abort()
+			return
+		}
+	}
+
+	ctx.Next(c)
+	if ctx.Response.StatusCode() != consts.StatusOK {
+		return
+	}
+	etag := string(ctx.Response.Header.Peek("ETag"))
+	if etag == "" {
+		etag = entityTag(ctx.Response.Body())
+		ctx.Header("ETag", etag)
+	}
+
+	if ifNoneMatch != "" && matchEntityTag(ifNoneMatch, etag) {
+		ctx.Response.ResetBody()
+		ctx.SetStatusCode(consts.StatusNotModified)
+	}
+}
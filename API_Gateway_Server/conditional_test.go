@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/cloudwego/kitex/pkg/kerrors"
+	"github.com/stretchr/testify/assert"
+)
+
+// conditionalCall sends a request with headers to method of ServiceA through conditional to a handler answering hi.
+func conditionalCall(method string, headers ...string) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, "/ServiceA/"+method, nil),
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		ctx.Request.SetHeader(headers[i], headers[i+1])
+	}
+	ctx.SetHandlers(app.HandlersChain{conditional, func(_ context.Context, ctx *app.RequestContext) {
+		ctx.JSON(consts.StatusOK, map[string]string{"Msg": "hi"})
+	}})
+	conditional(context.Background(), ctx)
+	return ctx
+}
+
+func TestMatchEntityTag(t *testing.T) {
+	assert.True(t, matchEntityTag(`"a"`, `"a"`))
+	assert.True(t, matchEntityTag(`"b", W/"a"`, `"a"`))
+	assert.True(t, matchEntityTag(`*`, `"a"`))
+	assert.False(t, matchEntityTag(`"b"`, `"a"`))
+	assert.False(t, matchEntityTag(`a`, `"a"`))
+}
+
+func TestConditional_ETagAndNotModified(t *testing.T) {
+	config = &gatewayConfig{Cache: cacheConfig{Rules: []cacheRule{{Route: "ServiceA/methodA"}}}}
+	t.Cleanup(func() {
+		config = &gatewayConfig{}
+	})
+
+	ctx := conditionalCall("methodA")
+	etag := string(ctx.Response.Header.Peek("ETag"))
+	assert.Equal(t, entityTag(ctx.Response.Body()), etag)
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+
+	ctx = conditionalCall("methodA", "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, ctx.Response.StatusCode())
+	assert.Empty(t, ctx.Response.Body())
+	assert.Equal(t, etag, string(ctx.Response.Header.Peek("ETag")))
+
+	ctx = conditionalCall("methodA", "If-None-Match", `"stale"`)
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"Msg": "hi"}`, string(ctx.Response.Body()))
+
+	ctx = conditionalCall("methodB", "If-None-Match", "*")
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode(), "methodB is not cacheable")
+	assert.Empty(t, ctx.Response.Header.Peek("ETag"))
+}
+
+func TestConditional_ForwardsIfMatch(t *testing.T) {
+	config = &gatewayConfig{}
+
+	var forwarded string
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, "/ServiceA/methodA", nil),
+	}
+	ctx.Request.SetHeader("If-Match", `"v1"`)
+	ctx.SetHandlers(app.HandlersChain{conditional, func(c context.Context, _ *app.RequestContext) {
+		forwarded, _ = metainfo.GetValue(c, ifMatchMetaKey)
+	}})
+	conditional(context.Background(), ctx)
+	assert.Equal(t, `"v1"`, forwarded)
+
+	assert.True(t, isPreconditionFailed(kerrors.NewBizStatusError(preconditionFailedStatus, "version is 2")))
+	assert.False(t, isPreconditionFailed(kerrors.NewBizStatusError(500, "precondition failed")))
+	assert.False(t, isPreconditionFailed(errors.New("precondition failed")))
+	assert.False(t, isPreconditionFailed(nil))
+}
+
+func TestConditional_CachedResponses(t *testing.T) {
+	useResponseCache(t, cacheRule{Route: "ServiceA/methodA", TTL: duration(time.Minute)})
+	calls := 0
+	call := func(headers ...string) *app.RequestContext {
+		ctx := &app.RequestContext{
+			Request: *protocol.NewRequest(http.MethodPost, "/ServiceA/methodA", nil),
+		}
+		ctx.Request.SetBodyString(`{"message": "hi"}`)
+		for i := 0; i+1 < len(headers); i += 2 {
+			ctx.Request.SetHeader(headers[i], headers[i+1])
+		}
+		ctx.SetHandlers(app.HandlersChain{conditional, cacheResponse, coalesce, func(_ context.Context, ctx *app.RequestContext) {
+			calls++
+			ctx.JSON(consts.StatusOK, map[string]int{"call": calls})
+		}})
+		conditional(context.Background(), ctx)
+		return ctx
+	}
+
+	etag := string(call().Response.Header.Peek("ETag"))
+	ctx := call("If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, ctx.Response.StatusCode())
+	assert.Equal(t, etag, string(ctx.Response.Header.Peek("ETag")))
+	assert.Empty(t, ctx.Response.Header.Peek("X-Cache"), "answered before the cache lookup")
+	assert.Equal(t, 1, calls)
+
+	ctx = call("If-Match", etag)
+	assert.Equal(t, 2, calls, "a conditional write bypasses the cache")
+	assert.JSONEq(t, `{"call": 2}`, string(ctx.Response.Body()))
+
+	ctx = call("If-None-Match", etag, "Cache-Control", "no-cache")
+	assert.Equal(t, 3, calls, "no-cache revalidates with the backend")
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+var configFile = "gateway.json"
+var config = &gatewayConfig{}
+
+// gatewayConfig holds the optional policies of the API Gateway.
+// Every policy is disabled when its section is left empty.
+type gatewayConfig struct {
+	RateLimit   rateLimitConfig   `json:"rateLimit"`
+	Bulkhead    bulkheadConfig    `json:"bulkhead"`
+	Tracing     tracingConfig     `json:"tracing"`
+	AccessLog   accessLogConfig   `json:"accessLog"`
+	JWT         jwtConfig         `json:"jwt"`
+	APIKeys     apiKeyConfig      `json:"apiKeys"`
+	Authz       authzConfig       `json:"authz"`
+	Admin       adminConfig       `json:"admin"`
+	TLS         tlsConfig         `json:"tls"`
+	BackendTLS  backendTLSConfig  `json:"backendTLS"`
+	CORS        corsConfig        `json:"cors"`
+	IPFilter    ipFilterConfig    `json:"ipFilter"`
+	Limits      limitsConfig      `json:"limits"`
+	HMAC        hmacConfig        `json:"hmac"`
+	Cache       cacheConfig       `json:"cache"`
+	Coalesce    coalesceConfig    `json:"coalesce"`
+	Batch       batchConfig       `json:"batch"`
+	Composite   compositeConfig   `json:"composite"`
+	GraphQL     graphQLConfig     `json:"graphQL"`
+	GRPC        grpcConfig        `json:"gRPC"`
+	WebSocket   webSocketConfig   `json:"webSocket"`
+	SSE         sseConfig         `json:"sse"`
+	Async       asyncConfig       `json:"async"`
+	Idempotency idempotencyConfig `json:"idempotency"`
+}
+
+// duration is a time.Duration decoded from a JSON string such as "1.5s".
+type duration time.Duration
+
+// UnmarshalJSON decodes data, a JSON string accepted by time.ParseDuration, into d.
+// It returns an error if data is not a valid duration string.
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// MarshalJSON encodes d as a JSON string such as "1.5s".
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// loadConfig reads the gateway configuration from file.
+// A missing file results in an empty configuration.
+// It returns the configuration and an error if the file cannot be read or decoded.
+func loadConfig(file string) (*gatewayConfig, error) {
+	cfg := &gatewayConfig{}
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// matchRoute reports whether serviceName and method match pattern,
+// written as "service/method" where each segment may use path.Match wildcards.
+// An empty pattern matches every route.
+func matchRoute(pattern, serviceName, method string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, err := path.Match(pattern, serviceName+"/"+method)
+	return err == nil && ok
+}
+
+// routeOf returns the service name and method requested in ctx.
+// Both are empty if the path does not name a service and a method.
+func routeOf(ctx *app.RequestContext) (string, string) {
+	splitArr := readPath(ctx)
+	if len(splitArr) < 3 {
+		return "", ""
+	}
+	return getServiceName(splitArr), getMethod(splitArr)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig_MissingFile(t *testing.T) {
+	cfg, err := loadConfig(filepath.Join(t.TempDir(), "missing.json"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, &gatewayConfig{}, cfg)
+}
+
+func TestLoadConfig_ValidFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gateway.json")
+	content := `{"rateLimit": {"rules": [{"route": "ServiceA/*", "key": "ip", "rate": 2, "burst": 4}]}}`
+	err := os.WriteFile(file, []byte(content), 0644)
+	assert.NoError(t, err)
+
+	cfg, err := loadConfig(file)
+
+	assert.NoError(t, err)
+	expected := []rateLimitRule{{Route: "ServiceA/*", Key: "ip", Rate: 2, Burst: 4}}
+	assert.Equal(t, expected, cfg.RateLimit.Rules)
+}
+
+func TestLoadConfig_InvalidFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gateway.json")
+	err := os.WriteFile(file, []byte(`{"rateLimit"`), 0644)
+	assert.NoError(t, err)
+
+	_, err = loadConfig(file)
+	assert.Error(t, err)
+}
+
+func TestDuration_UnmarshalJSON(t *testing.T) {
+	var d duration
+	assert.NoError(t, d.UnmarshalJSON([]byte(`"1.5s"`)))
+	assert.Equal(t, duration(1500*time.Millisecond), d)
+
+	assert.Error(t, d.UnmarshalJSON([]byte(`"soon"`)))
+}
+
+func TestMatchRoute(t *testing.T) {
+	assert.True(t, matchRoute("", "ServiceA", "methodA"))
+	assert.True(t, matchRoute("ServiceA/*", "ServiceA", "methodA"))
+	assert.True(t, matchRoute("*/methodC", "ServiceB", "methodC"))
+	assert.False(t, matchRoute("ServiceA/*", "ServiceB", "methodA"))
+	assert.False(t, matchRoute("ServiceA/methodA", "ServiceA", "methodB"))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// corsConfig configures the CORS policy of the routes matching each rule, the first matching rule applying.
+type corsConfig struct {
+	Rules []corsRule `json:"rules"`
+}
+
+// corsRule lets browsers on the origins matching one of AllowOrigins, written as path.Match
+// patterns such as "https://*.example.com" or "*" for any origin, call the routes matching Route
+// with AllowMethods (POST when empty) and AllowHeaders ("*" for any), reading ExposeHeaders
+// and sending credentials when AllowCredentials is set. Preflight responses are cached for MaxAge.
+type corsRule struct {
+	Route            string   `json:"route"`
+	AllowOrigins     []string `json:"allowOrigins"`
+	AllowMethods     []string `json:"allowMethods"`
+	AllowHeaders     []string `json:"allowHeaders"`
+	ExposeHeaders    []string `json:"exposeHeaders"`
+	AllowCredentials bool     `json:"allowCredentials"`
+	MaxAge           duration `json:"maxAge"`
+}
+
+// checkCORSConfig returns an error if a rule of cfg allows credentials from any origin, which would let every site
+// read the responses to the requests made with the cookies or credentials of its visitors.
+func checkCORSConfig(cfg corsConfig) error {
+	for _, rule := range cfg.Rules {
+		if !rule.AllowCredentials {
+			continue
+		}
+		for _, origin := range rule.AllowOrigins {
+			if !boundedOrigin(origin) {
+				return fmt.Errorf("cors rule %q allows credentials from arbitrary origins with %q", rule.Route, origin)
+			}
+		}
+	}
+	return nil
+}
+
+// boundedOrigin reports whether the origin pattern only matches the hosts of a domain someone controls:
+// its scheme is literal and its host ends with at least two literal labels after any wildcard, such as
+// https://*.example.com, and unlike https://*, http* or https://*example.com.
+func boundedOrigin(pattern string) bool {
+	const meta = `*?[\`
+	scheme, host, ok := strings.Cut(pattern, "://")
+	if !ok || scheme == "" || strings.ContainsAny(scheme, meta) {
+		return false
+	}
+	if i := strings.LastIndexByte(host, ':'); i >= 0 {
+		host = host[:i]
+	}
+	labels := strings.Split(host, ".")
+	literal := 0
+	for i := len(labels) - 1; i >= 0 && !strings.ContainsAny(labels[i], meta); i-- {
+		literal++
+	}
+	return literal == len(labels) && host != "" || literal >= 2
+}
+
+// allowsOrigin reports whether origin matches one of the allowed origins of r.
+func (r *corsRule) allowsOrigin(origin string) bool {
+	for _, pattern := range r.AllowOrigins {
+		if pattern == "*" {
+			return true
+		}
+		if ok, err := path.Match(pattern, origin); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// methods returns the allowed methods of r.
+func (r *corsRule) methods() []string {
+	if len(r.AllowMethods) == 0 {
+		return []string{http.MethodPost}
+	}
+	return r.AllowMethods
+}
+
+// allowsMethod reports whether method is one of the allowed methods of r.
+func (r *corsRule) allowsMethod(method string) bool {
+	for _, m := range r.methods() {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders reports whether every header of the comma separated list requested is allowed by r.
+func (r *corsRule) allowsHeaders(requested string) bool {
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		allowed := false
+		for _, a := range r.AllowHeaders {
+			if a == "*" || strings.EqualFold(a, h) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// setCORSHeaders sets the CORS headers allowing origin to read the response of ctx.
+func setCORSHeaders(ctx *app.RequestContext, rule *corsRule, origin string) {
+	ctx.Header("Access-Control-Allow-Origin", origin)
+	ctx.Response.Header.Add("Vary", "Origin")
+	if rule.AllowCredentials {
+		ctx.Header("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// cors is the middleware applying the CORS rule matching the requested route.
+// Preflight requests are answered here with 204, or 403 if the origin, method or headers
+// are not allowed, before any parsing of the request. Other requests from an allowed origin
+// get the CORS headers on their response.
+func cors(c context.Context, ctx *app.RequestContext) {
+	origin := string(ctx.GetHeader("Origin"))
+	if origin == "" {
+		ctx.Next(c)
+		return
+	}
+
+	serviceName, method := routeOf(ctx)
+	var rule *corsRule
+	for i := range config.CORS.Rules {
+		if matchRoute(config.CORS.Rules[i].Route, serviceName, method) {
+			rule = &config.CORS.Rules[i]
+			break
+		}
+	}
+	if rule == nil {
+		ctx.Next(c)
+		return
+	}
+
+	requestMethod := string(ctx.GetHeader("Access-Control-Request-Method"))
+	if string(ctx.Method()) == http.MethodOptions && requestMethod != "" {
+		requestHeaders := string(ctx.GetHeader("Access-Control-Request-Headers"))
+		if !rule.allowsOrigin(origin) || !rule.allowsMethod(requestMethod) || !rule.allowsHeaders(requestHeaders) {
+			ctx.AbortWithMsg("CORS preflight rejected", http.StatusForbidden)
+			setErrorClass(ctx, "cors")
+			return
+		}
+
+		setCORSHeaders(ctx, rule, origin)
+		ctx.Header("Access-Control-Allow-Methods", strings.Join(rule.methods(), ", "))
+		if requestHeaders != "" {
+			ctx.Header("Access-Control-Allow-Headers", requestHeaders)
+		}
+		if rule.MaxAge > 0 {
+			ctx.Header("Access-Control-Max-Age", strconv.Itoa(int(time.Duration(rule.MaxAge).Seconds())))
+		}
+		ctx.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+
+	ctx.Next(c)
+
+	if rule.allowsOrigin(origin) {
+		setCORSHeaders(ctx, rule, origin)
+		if len(rule.ExposeHeaders) > 0 {
+			ctx.Header("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func useCORSConfig(t *testing.T) {
+	config = &gatewayConfig{CORS: corsConfig{Rules: []corsRule{{
+		Route:            "ServiceA/*",
+		AllowOrigins:     []string{"https://*.example.com"},
+		AllowHeaders:     []string{"Content-Type", "Authorization"},
+		ExposeHeaders:    []string{requestIDHeader},
+		AllowCredentials: true,
+		MaxAge:           duration(10 * time.Minute),
+	}}}}
+	t.Cleanup(func() {
+		config = &gatewayConfig{}
+	})
+}
+
+func newPreflightContext(origin, method, headers string) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodOptions, "/ServiceA/methodA", nil),
+	}
+	ctx.Request.SetHeader("Origin", origin)
+	ctx.Request.SetHeader("Access-Control-Request-Method", method)
+	if headers != "" {
+		ctx.Request.SetHeader("Access-Control-Request-Headers", headers)
+	}
+	return ctx
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	useCORSConfig(t)
+
+	ctx := newPreflightContext("https://app.example.com", http.MethodPost, "content-type, authorization")
+	called := false
+	ctx.SetHandlers(app.HandlersChain{cors, func(context.Context, *app.RequestContext) { called = true }})
+	cors(context.Background(), ctx)
+	assert.False(t, called, "preflight requests are not passed on to decode")
+	assert.Equal(t, http.StatusNoContent, ctx.Response.StatusCode())
+	assert.Equal(t, "https://app.example.com", string(ctx.Response.Header.Peek("Access-Control-Allow-Origin")))
+	assert.Equal(t, "POST", string(ctx.Response.Header.Peek("Access-Control-Allow-Methods")))
+	assert.Equal(t, "content-type, authorization", string(ctx.Response.Header.Peek("Access-Control-Allow-Headers")))
+	assert.Equal(t, "true", string(ctx.Response.Header.Peek("Access-Control-Allow-Credentials")))
+	assert.Equal(t, "600", string(ctx.Response.Header.Peek("Access-Control-Max-Age")))
+
+	for _, ctx := range []*app.RequestContext{
+		newPreflightContext("https://evil.com", http.MethodPost, ""),
+		newPreflightContext("https://app.example.com", http.MethodDelete, ""),
+		newPreflightContext("https://app.example.com", http.MethodPost, "X-Custom"),
+	} {
+		cors(context.Background(), ctx)
+		assert.Equal(t, http.StatusForbidden, ctx.Response.StatusCode())
+		assert.Empty(t, ctx.Response.Header.Peek("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestCORS_ActualRequestKeepsHeadersOnErrors(t *testing.T) {
+	useCORSConfig(t)
+
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, "/ServiceA/methodA", nil),
+	}
+	ctx.Request.SetHeader("Origin", "https://app.example.com")
+	ctx.SetHandlers(app.HandlersChain{cors, func(_ context.Context, ctx *app.RequestContext) {
+		ctx.AbortWithMsg("Missing bearer token", http.StatusUnauthorized)
+	}})
+	cors(context.Background(), ctx)
+
+	assert.Equal(t, http.StatusUnauthorized, ctx.Response.StatusCode())
+	assert.Equal(t, "https://app.example.com", string(ctx.Response.Header.Peek("Access-Control-Allow-Origin")))
+	assert.Equal(t, requestIDHeader, string(ctx.Response.Header.Peek("Access-Control-Expose-Headers")))
+
+	ctx = &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, "/ServiceA/methodA", nil),
+	}
+	ctx.Request.SetHeader("Origin", "https://evil.com")
+	cors(context.Background(), ctx)
+	assert.Empty(t, ctx.Response.Header.Peek("Access-Control-Allow-Origin"))
+}
+
+func TestCheckCORSConfig_RejectsCredentialsFromAnyOrigin(t *testing.T) {
+	assert.NoError(t, checkCORSConfig(corsConfig{Rules: []corsRule{
+		{Route: "ServiceA/*", AllowOrigins: []string{"https://*.example.com"}, AllowCredentials: true},
+		{Route: "ServiceB/*", AllowOrigins: []string{"*"}},
+	}}))
+	assert.Error(t, checkCORSConfig(corsConfig{Rules: []corsRule{
+		{Route: "ServiceA/*", AllowOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true},
+	}}))
+
+	for _, origin := range []string{"http://localhost:3000", "https://app.example.com", "https://*.example.com", "https://app-*.example.com:*"} {
+		assert.NoError(t, checkCORSConfig(corsConfig{Rules: []corsRule{
+			{Route: "ServiceA/*", AllowOrigins: []string{origin}, AllowCredentials: true},
+		}}), origin)
+	}
+	for _, origin := range []string{"https://*", "http*", "*://app.example.com", "https://*:8443", "https://*.com", "https://*example.com", "https://example.*", ""} {
+		assert.Error(t, checkCORSConfig(corsConfig{Rules: []corsRule{
+			{Route: "ServiceA/*", AllowOrigins: []string{origin}, AllowCredentials: true},
+		}}), origin)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/cloudwego/kitex/pkg/generic/descriptor"
+)
+
+// The media types of the request bodies mapped into the request struct of the method called.
+const (
+	jsonContentType      = "application/json"
+	formContentType      = "application/x-www-form-urlencoded"
+	multipartContentType = "multipart/form-data"
+)
+
+// rawBodyKey is the key of the body of a request as it was received in its context, when a middleware replaced it.
+const rawBodyKey = "rawBody"
+
+// formInputKey marks in its context a request whose JSON body formInput mapped from form input.
+const formInputKey = "formInput"
+
+// formRequests holds the request structs of the methods, by "service/method", rebuilt whenever an IDL changes.
+var formRequests atomic.Value
+
+// buildFormRequests derives the request structs of the methods from the IDLs of the services, into which formInput
+// maps the query parameters and form fields of the requests. It returns an error if an IDL cannot be parsed,
+// keeping the previous request structs.
+func buildFormRequests() error {
+	requests := map[string]*descriptor.StructDescriptor{}
+	err := forEachMethod(func(serviceName, method string, desc *descriptor.FunctionDescriptor) {
+		if arg, ok := requestArg(desc); ok {
+			requests[serviceName+"/"+method] = arg.Type.Struct
+		}
+	})
+	if err != nil {
+		return err
+	}
+	formRequests.Store(requests)
+	return nil
+}
+
+// keepRawBody keeps the body of the request of ctx as it was received, for the signature check, before a middleware
+// replaces it. The body kept by a previous middleware is left alone.
+func keepRawBody(ctx *app.RequestContext) {
+	if _, ok := ctx.Get(rawBodyKey); !ok {
+		ctx.Set(rawBodyKey, append([]byte(nil), ctx.Request.Body()...))
+	}
+}
+
+// rawBody returns the body of the request of ctx as it was received, before formInput or the claim fields replaced it.
+func rawBody(ctx *app.RequestContext) []byte {
+	if body, ok := ctx.Get(rawBodyKey); ok {
+		return body.([]byte)
+	}
+	return ctx.Request.Body()
+}
+
+// argsValues returns the values of args by key, in order.
+func argsValues(args *protocol.Args) map[string][]string {
+	values := map[string][]string{}
+	args.VisitAll(func(key, value []byte) {
+		values[string(key)] = append(values[string(key)], string(value))
+	})
+	return values
+}
+
+// multipartValues returns the values of the multipart form of ctx by key, the contents of its files following
+// the values of their key.
+func multipartValues(ctx *app.RequestContext) (map[string][]string, error) {
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		return nil, err
+	}
+	values := map[string][]string{}
+	for key, v := range form.Value {
+		values[key] = append(values[key], v...)
+	}
+	for key, files := range form.File {
+		for _, fh := range files {
+			f, err := fh.Open()
+			if err != nil {
+				return nil, err
+			}
+			content, err := io.ReadAll(f)
+			_ = f.Close()
+			if err != nil {
+				return nil, err
+			}
+			values[key] = append(values[key], string(content))
+		}
+	}
+	return values, nil
+}
+
+// formJSON returns the JSON request of the struct st holding values. A key names a field of st, or with dotted
+// segments a field of a nested struct or the entry of a map, as in owner.name or counts.apples. Each value is
+// converted to the type of its field: lists and sets take every value of their key, other fields the first.
+// Keys naming no field are ignored. It returns an error if a value does not convert to the type of its field.
+func formJSON(st *descriptor.StructDescriptor, values map[string][]string) ([]byte, error) {
+	object := map[string]interface{}{}
+	for key, v := range values {
+		if len(v) == 0 {
+			continue
+		}
+		err := setFormValue(object, st, strings.Split(key, "."), v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return json.Marshal(object)
+}
+
+// setFormValue sets the field of object at path in the struct st to values, converted to the type of the field.
+func setFormValue(object map[string]interface{}, st *descriptor.StructDescriptor, path []string, values []string) error {
+	name := path[0]
+	field, ok := st.FieldsByName[name]
+	if !ok {
+		return nil
+	}
+
+	t := field.Type
+	switch {
+	case t.Type == descriptor.STRUCT && len(path) > 1:
+		nested, ok := object[name].(map[string]interface{})
+		if !ok {
+			nested = map[string]interface{}{}
+			object[name] = nested
+		}
+		return setFormValue(nested, t.Struct, path[1:], values)
+	case t.Type == descriptor.MAP && len(path) > 1:
+		entries, ok := object[name].(map[string]interface{})
+		if !ok {
+			entries = map[string]interface{}{}
+			object[name] = entries
+		}
+		v, err := formValue(t.Elem, values)
+		if err != nil {
+			return err
+		}
+		entries[strings.Join(path[1:], ".")] = v
+		return nil
+	case len(path) > 1:
+		return fmt.Errorf("%s has no fields", name)
+	}
+
+	v, err := formValue(t, values)
+	if err != nil {
+		return err
+	}
+	object[name] = v
+	return nil
+}
+
+// formValue converts values to the Thrift type t: a list or set takes every value, other types the first.
+func formValue(t *descriptor.TypeDescriptor, values []string) (interface{}, error) {
+	if t.Type != descriptor.LIST && t.Type != descriptor.SET {
+		return formScalar(t, values[0])
+	}
+	items := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		item, err := formScalar(t.Elem, value)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// formScalar converts value to the Thrift base type t, a binary value to base64 as the generic calls expect it.
+func formScalar(t *descriptor.TypeDescriptor, value string) (interface{}, error) {
+	switch t.Type {
+	case descriptor.BOOL:
+		return strconv.ParseBool(value)
+	case descriptor.I08:
+		return strconv.ParseInt(value, 10, 8)
+	case descriptor.I16:
+		return strconv.ParseInt(value, 10, 16)
+	case descriptor.I32:
+		return strconv.ParseInt(value, 10, 32)
+	case descriptor.I64:
+		return strconv.ParseInt(value, 10, 64)
+	case descriptor.DOUBLE:
+		return strconv.ParseFloat(value, 64)
+	case descriptor.STRING:
+		if t.Name == "binary" {
+			return base64.StdEncoding.EncodeToString([]byte(value)), nil
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("a %s cannot be given as a form value", t.Name)
+}
+
+// formInput is the middleware mapping the query parameters of a GET request without a body, and the fields of an
+// application/x-www-form-urlencoded or multipart/form-data body, into the JSON request of the method called, by
+// the types of the fields of its request struct in the IDL. The files of a multipart body are the values of their
+// field. The request is then passed on as application/json, so that the following middlewares and decode see the
+// JSON request, its original body kept for the signature check. Other requests are passed on unchanged.
+func formInput(c context.Context, ctx *app.RequestContext) {
+	serviceName, method := routeOf(ctx)
+	requests, _ := formRequests.Load().(map[string]*descriptor.StructDescriptor)
+	st, ok := requests[serviceName+"/"+method]
+	if !ok {
+		ctx.Next(c)
+		return
+	}
+
+	var values map[string][]string
+	switch mediaType(ctx) {
+	case formContentType:
+		values = argsValues(ctx.PostArgs())
+	case multipartContentType:
+		var err error
+		values, err = multipartValues(ctx)
+		if err != nil {
+			ctx.AbortWithMsg("Invalid multipart form", http.StatusBadRequest)
+			setErrorClass(ctx, "invalid_request")
+			return
+		}
+	case "":
+		if !ctx.Request.Header.IsGet() || len(ctx.Request.Body()) > 0 {
+			ctx.Next(c)
+			return
+		}
+		values = argsValues(ctx.QueryArgs())
+	default:
+		ctx.Next(c)
+		return
+	}
+
+	body, err := formJSON(st, values)
+	if err != nil {
+		ctx.AbortWithMsg("Invalid form data, "+err.Error(), http.StatusBadRequest)
+		setErrorClass(ctx, "invalid_request")
+		return
+	}
+	keepRawBody(ctx)
+	ctx.Set(formInputKey, true)
+	ctx.Request.SetBody(body)
+	ctx.Request.Header.SetContentTypeBytes([]byte(jsonContentType))
+	ctx.Next(c)
+}
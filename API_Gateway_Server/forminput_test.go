@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/kitex/pkg/generic/descriptor"
+	"github.com/stretchr/testify/assert"
+)
+
+// useFormRequests maps the form input of ServiceA, defined by an IDL of typed fields, and returns the channel
+// receiving the requests of its calls.
+func useFormRequests(t *testing.T) chan string {
+	received := make(chan string, 1)
+	useFakeBackend(t, func(_ context.Context, _, request string) (string, error) {
+		received <- request
+		return `{"message": "saved"}`, nil
+	})
+	useServiceIDLs(t)
+
+	file := filepath.Join(t.TempDir(), "serviceA.thrift")
+	assert.NoError(t, os.WriteFile(file, []byte(`namespace go api
+
+struct Owner {
+    1: string name
+    2: bool active
+}
+
+struct Item {
+    1: i64 id
+    2: list<string> tags
+    3: map<string, i32> counts
+    4: Owner owner
+    5: binary data
+    6: set<double> scores
+    7: byte rank
+    8: list<Owner> owners
+}
+
+service ServiceA {
+    Item save(1: Item item)
+}
+`), 0o600))
+	serviceIdlMap["ServiceA"] = file
+	assert.NoError(t, mapContent(file))
+	assert.NoError(t, buildFormRequests())
+	t.Cleanup(func() {
+		formRequests.Store(map[string]*descriptor.StructDescriptor{})
+	})
+	return received
+}
+
+// formCall sends the request with the content type, if any, and body through the gateway middlewares and decode.
+func formCall(method, uri, contentType string, body []byte) *app.RequestContext {
+	ctx := app.NewContext(0)
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	if contentType != "" {
+		ctx.Request.Header.SetContentTypeBytes([]byte(contentType))
+	}
+	ctx.Request.SetBody(body)
+	ctx.SetHandlers(append(gatewayMiddlewares(), decode))
+	ctx.Next(context.Background())
+	return ctx
+}
+
+func TestFormInput_QueryParameters(t *testing.T) {
+	received := useFormRequests(t)
+
+	query := url.Values{
+		"id":           {"7"},
+		"tags":         {"a", "b"},
+		"counts.apple": {"3"},
+		"owner.name":   {"Ann"},
+		"owner.active": {"true"},
+		"data":         {"hi"},
+		"scores":       {"1.5", "2"},
+		"api_key":      {"ignored"},
+	}
+	ctx := formCall(http.MethodGet, "/ServiceA/save?"+query.Encode(), "", nil)
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"id": 7, "tags": ["a", "b"], "counts": {"apple": 3}, "owner": {"name": "Ann", "active": true},
+		"data": "aGk=", "scores": [1.5, 2]}`, <-received)
+
+	ctx = formCall(http.MethodGet, "/ServiceA/save", "", nil)
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{}`, <-received)
+}
+
+func TestFormInput_FormBodies(t *testing.T) {
+	received := useFormRequests(t)
+
+	form := []byte("id=7&tags=a&tags=b&owner.name=Ann")
+	ctx := formCall(http.MethodPost, "/ServiceA/save", "application/x-www-form-urlencoded; charset=utf-8", form)
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"id": 7, "tags": ["a", "b"], "owner": {"name": "Ann"}}`, <-received)
+	assert.Equal(t, form, rawBody(ctx), "the original body is kept for the signature check")
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	assert.NoError(t, writer.WriteField("rank", "-3"))
+	part, err := writer.CreateFormFile("data", "data.bin")
+	assert.NoError(t, err)
+	_, err = part.Write([]byte{0, 1, 2})
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	ctx = formCall(http.MethodPost, "/ServiceA/save", writer.FormDataContentType(), body.Bytes())
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"rank": -3, "data": "AAEC"}`, <-received)
+
+	ctx = formCall(http.MethodPost, "/ServiceA/save", "application/json; charset=utf-8", []byte(`{"data": "AAEC"}`))
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"data": "AAEC"}`, <-received)
+}
+
+func TestFormInput_InvalidValues(t *testing.T) {
+	useFormRequests(t)
+
+	for _, query := range []string{"id=seven", "rank=300", "owner.active=maybe", "owners=Ann", "id.value=7"} {
+		ctx := formCall(http.MethodGet, "/ServiceA/save?"+query, "", nil)
+		assert.Equal(t, http.StatusBadRequest, ctx.Response.StatusCode(), query)
+	}
+
+	ctx := formCall(http.MethodPost, "/ServiceA/save", "multipart/form-data; boundary=x", []byte("not multipart"))
+	assert.Equal(t, "Invalid multipart form", string(ctx.Response.Body()))
+	ctx = formCall(http.MethodPost, "/ServiceA/save", "text/plain", []byte("id=7"))
+	assert.Equal(t, http.StatusBadRequest, ctx.Response.StatusCode())
+}
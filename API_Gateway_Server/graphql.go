@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/cloudwego/kitex/pkg/generic/descriptor"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// graphQLSchema holds the *graphql.Schema derived from the IDLs of the services, rebuilt whenever an IDL changes.
+var graphQLSchema atomic.Value
+
+// invalidGraphQLName matches the characters not allowed in GraphQL names.
+var invalidGraphQLName = regexp.MustCompile(`[^_0-9A-Za-z]`)
+
+// graphQLOriginKey is the context key of the request on behalf of which the GraphQL resolvers make their calls.
+type graphQLOriginKey struct{}
+
+// graphQLConfig configures the GraphQL endpoint. The methods matching one of Mutations
+// are fields of the Mutation type, the others of the Query type.
+type graphQLConfig struct {
+	Mutations []string `json:"mutations"`
+}
+
+// graphQLRequest is a GraphQL request, sent as the JSON body of a POST or the query parameters of a GET.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// longScalar is the GraphQL scalar of the Thrift i64, wider than the GraphQL Int.
+var longScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Long",
+	Description: "A 64-bit integer, the Thrift i64.",
+	Serialize:   coerceLong,
+	ParseValue:  coerceLong,
+	ParseLiteral: func(value ast.Value) interface{} {
+		switch v := value.(type) {
+		case *ast.IntValue:
+			return coerceLong(v.Value)
+		case *ast.StringValue:
+			return coerceLong(v.Value)
+		}
+		return nil
+	},
+})
+
+// coerceLong returns v as an int64, or nil if it is not an integer.
+func coerceLong(v interface{}) interface{} {
+	switch t := v.(type) {
+	case int:
+		return int64(t)
+	case int32:
+		return int64(t)
+	case int64:
+		return t
+	case float64:
+		return int64(t)
+	case json.Number:
+		n, err := t.Int64()
+		if err != nil {
+			return nil
+		}
+		return n
+	case string:
+		n, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return nil
+		}
+		return n
+	}
+	return nil
+}
+
+// unmarshalNumbers decodes the JSON data into v like json.Unmarshal, but decoding the numbers held by interfaces
+// as int64 when they are integers within its range, and as float64 otherwise, so that the i64 values beyond
+// the precision of a float64 are kept.
+func unmarshalNumbers(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("invalid JSON data after the top-level value")
+	}
+	return nil
+}
+
+// exactNumbers returns v, decoded with json.Number numbers, with its numbers as int64 when they are integers within
+// its range and as float64 otherwise, the types the GraphQL scalars take.
+func exactNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if n, err := t.Int64(); err == nil {
+			return n
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = exactNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = exactNumbers(e)
+		}
+	}
+	return v
+}
+
+// jsonScalar is the GraphQL scalar of the Thrift maps and of the types without a GraphQL equivalent, any JSON value.
+var jsonScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Any JSON value, such as a Thrift map.",
+	Serialize:   func(v interface{}) interface{} { return v },
+	ParseValue:  func(v interface{}) interface{} { return v },
+	ParseLiteral: func(value ast.Value) interface{} {
+		return literalValue(value)
+	},
+})
+
+// literalValue returns the value of the GraphQL literal value.
+func literalValue(value ast.Value) interface{} {
+	switch v := value.(type) {
+	case *ast.ObjectValue:
+		object := make(map[string]interface{}, len(v.Fields))
+		for _, f := range v.Fields {
+			object[f.Name.Value] = literalValue(f.Value)
+		}
+		return object
+	case *ast.ListValue:
+		list := make([]interface{}, len(v.Values))
+		for i, e := range v.Values {
+			list[i] = literalValue(e)
+		}
+		return list
+	case *ast.IntValue:
+		return json.Number(v.Value)
+	case *ast.FloatValue:
+		return json.Number(v.Value)
+	}
+	return value.GetValue()
+}
+
+// graphQLTypes derives the GraphQL types of the Thrift types, an object type for output
+// and an input type for arguments for each struct, named by names.
+type graphQLTypes struct {
+	names   *structNames
+	objects map[string]*graphql.Object
+	inputs  map[string]*graphql.InputObject
+}
+
+// output returns the GraphQL output type of the Thrift type t.
+func (g *graphQLTypes) output(t *descriptor.TypeDescriptor) graphql.Output {
+	switch t.Type {
+	case descriptor.STRUCT:
+		return g.object(t.Struct)
+	case descriptor.LIST, descriptor.SET:
+		return graphql.NewList(g.output(t.Elem))
+	case descriptor.VOID:
+		return graphql.Boolean
+	}
+	return scalarOf(t)
+}
+
+// input returns the GraphQL input type of the Thrift type t.
+func (g *graphQLTypes) input(t *descriptor.TypeDescriptor) graphql.Input {
+	switch t.Type {
+	case descriptor.STRUCT:
+		return g.inputObject(t.Struct)
+	case descriptor.LIST, descriptor.SET:
+		return graphql.NewList(g.input(t.Elem))
+	}
+	return scalarOf(t)
+}
+
+// scalarOf returns the GraphQL scalar of the Thrift type t that is not a struct, list or set.
+func scalarOf(t *descriptor.TypeDescriptor) *graphql.Scalar {
+	switch t.Type {
+	case descriptor.BOOL:
+		return graphql.Boolean
+	case descriptor.I08, descriptor.I16, descriptor.I32:
+		return graphql.Int
+	case descriptor.I64:
+		return longScalar
+	case descriptor.DOUBLE:
+		return graphql.Float
+	case descriptor.STRING:
+		return graphql.String
+	}
+	return jsonScalar
+}
+
+// sortedFields returns the fields of the struct s ordered by ID.
+func sortedFields(s *descriptor.StructDescriptor) []*descriptor.FieldDescriptor {
+	fields := make([]*descriptor.FieldDescriptor, 0, len(s.FieldsByID))
+	for _, f := range s.FieldsByID {
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].ID < fields[j].ID })
+	return fields
+}
+
+// object returns the GraphQL object type of the struct s.
+func (g *graphQLTypes) object(s *descriptor.StructDescriptor) *graphql.Object {
+	name := g.names.of(s)
+	if o, ok := g.objects[name]; ok {
+		return o
+	}
+	o := graphql.NewObject(graphql.ObjectConfig{
+		Name: name,
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			fields := graphql.Fields{}
+			for _, f := range sortedFields(s) {
+				fields[graphQLName(f.Name)] = &graphql.Field{Type: g.output(f.Type)}
+			}
+			return fields
+		}),
+	})
+	g.objects[name] = o
+	return o
+}
+
+// inputObject returns the GraphQL input type of the struct s, named after it with the Input suffix.
+func (g *graphQLTypes) inputObject(s *descriptor.StructDescriptor) *graphql.InputObject {
+	name := g.names.of(s) + "Input"
+	if o, ok := g.inputs[name]; ok {
+		return o
+	}
+	o := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: name,
+		Fields: graphql.InputObjectConfigFieldMapThunk(func() graphql.InputObjectConfigFieldMap {
+			fields := graphql.InputObjectConfigFieldMap{}
+			for _, f := range sortedFields(s) {
+				fields[graphQLName(f.Name)] = &graphql.InputObjectFieldConfig{Type: g.input(f.Type)}
+			}
+			return fields
+		}),
+	})
+	g.inputs[name] = o
+	return o
+}
+
+// graphQLName returns name with the characters not allowed in GraphQL names replaced by underscores.
+func graphQLName(name string) string {
+	return invalidGraphQLName.ReplaceAllString(name, "_")
+}
+
+// structNames names the GraphQL types and protobuf messages derived from the Thrift structs after the structs.
+// A struct of another IDL than the struct first named so is named after its service and itself, such as
+// ServiceB_Request, so that the structs of the same name of different services do not share a type.
+type structNames struct {
+	names   map[*descriptor.StructDescriptor]string
+	structs map[string]*descriptor.StructDescriptor
+}
+
+// newStructNames returns the names of no struct yet.
+func newStructNames() *structNames {
+	return &structNames{names: map[*descriptor.StructDescriptor]string{}, structs: map[string]*descriptor.StructDescriptor{}}
+}
+
+// of returns the name of the struct s, named by add.
+func (n *structNames) of(s *descriptor.StructDescriptor) string {
+	return n.names[s]
+}
+
+// addMethod names the structs of the request and response of the method of serviceName described by fn,
+// unless the method does not take a single struct. It returns an error if a struct cannot be named.
+func (n *structNames) addMethod(serviceName string, fn *descriptor.FunctionDescriptor) error {
+	arg, ok := requestArg(fn)
+	if !ok {
+		return nil
+	}
+	err := n.add(serviceName, arg.Type)
+	if err != nil {
+		return err
+	}
+	if t := resultType(fn); t != nil {
+		return n.add(serviceName, t)
+	}
+	return nil
+}
+
+// add names the structs of the Thrift type t of serviceName, then those of their fields in field order.
+// It returns an error if the name qualified by the service is taken by another struct as well.
+func (n *structNames) add(serviceName string, t *descriptor.TypeDescriptor) error {
+	switch t.Type {
+	case descriptor.LIST, descriptor.SET:
+		return n.add(serviceName, t.Elem)
+	case descriptor.MAP:
+		err := n.add(serviceName, t.Key)
+		if err != nil {
+			return err
+		}
+		return n.add(serviceName, t.Elem)
+	case descriptor.STRUCT:
+	default:
+		return nil
+	}
+
+	s := t.Struct
+	if _, ok := n.names[s]; ok {
+		return nil
+	}
+	name := graphQLName(s.Name)
+	if _, taken := n.structs[name]; taken {
+		name = graphQLName(serviceName) + "_" + name
+		if _, taken := n.structs[name]; taken {
+			return fmt.Errorf("struct %s of %s conflicts with struct %s", s.Name, serviceName, name)
+		}
+	}
+	n.names[s] = name
+	n.structs[name] = s
+	for _, f := range sortedFields(s) {
+		err := n.add(serviceName, f.Type)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// graphQLField returns the field calling method of serviceName described by fn. The field takes an argument
+// for the request struct of the method, named after the Thrift argument, and resolves to the response,
+// or to true for the void methods.
+// It returns false if the method does not take a single struct, which the generic calls cannot be made with.
+func graphQLField(g *graphQLTypes, serviceName, method string, fn *descriptor.FunctionDescriptor) (*graphql.Field, bool) {
+	arg, ok := requestArg(fn)
+	if !ok {
+		return nil, false
+	}
+
+	var result graphql.Output = graphql.Boolean
+	t := resultType(fn)
+	void := t == nil
+	if !void {
+		result = g.output(t)
+	}
+	argName := graphQLName(arg.Name)
+
+	return &graphql.Field{
+		Type: result,
+		Args: graphql.FieldConfigArgument{
+			argName: &graphql.ArgumentConfig{Type: graphql.NewNonNull(g.input(arg.Type))},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			origin, ok := p.Context.Value(graphQLOriginKey{}).(*callOrigin)
+			if !ok {
+				return nil, fmt.Errorf("no request to call %s.%s on behalf of", serviceName, method)
+			}
+			body, err := json.Marshal(p.Args[argName])
+			if err != nil {
+				return nil, err
+			}
+
+			call := origin.dispatch(p.Context, fmt.Sprint(p.Info.Path.Key), serviceName, method, body)
+			if call.Response.StatusCode() != consts.StatusOK {
+				return nil, fmt.Errorf("%s.%s failed with status %d: %s", serviceName, method, call.Response.StatusCode(), call.Response.Body())
+			}
+			if void {
+				return true, nil
+			}
+			var response interface{}
+			err = unmarshalNumbers(call.Response.Body(), &response)
+			return exactNumbers(response), err
+		},
+	}, true
+}
+
+// buildGraphQLSchema derives the GraphQL schema from the IDLs of the services and makes it the schema of the
+// GraphQL endpoint. Each method with a single struct argument becomes a field named "Service_method" on the
+// Mutation type if it matches one of the configured mutations, and on the Query type otherwise.
+// It returns an error if an IDL cannot be parsed or the schema is invalid, keeping the previous schema.
+func buildGraphQLSchema() error {
+	services := serviceNames()
+
+	g := &graphQLTypes{names: newStructNames(), objects: map[string]*graphql.Object{}, inputs: map[string]*graphql.InputObject{}}
+	queries := graphql.Fields{
+		"_services": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+			Description: "The services of the gateway.",
+			Resolve: func(graphql.ResolveParams) (interface{}, error) {
+				return services, nil
+			},
+		},
+	}
+	mutations := graphql.Fields{}
+
+	var nameErr error
+	err := forEachMethod(func(service, method string, fn *descriptor.FunctionDescriptor) {
+		if nameErr == nil {
+			nameErr = g.names.addMethod(service, fn)
+		}
+		if nameErr != nil {
+			return
+		}
+		field, ok := graphQLField(g, service, method, fn)
+		if !ok {
+			return
+		}
+		name := graphQLName(service) + "_" + graphQLName(method)
+		if matchAnyRoute(config.GraphQL.Mutations, service, method) {
+			mutations[name] = field
+		} else {
+			queries[name] = field
+		}
+	})
+	if err == nil {
+		err = nameErr
+	}
+	if err != nil {
+		return err
+	}
+
+	schemaConfig := graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: queries}),
+	}
+	if len(mutations) > 0 {
+		schemaConfig.Mutation = graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: mutations})
+	}
+	schema, err := graphql.NewSchema(schemaConfig)
+	if err != nil {
+		return err
+	}
+	graphQLSchema.Store(&schema)
+	return nil
+}
+
+// matchAnyRoute reports whether method of serviceName matches one of patterns.
+func matchAnyRoute(patterns []string, serviceName, method string) bool {
+	for _, pattern := range patterns {
+		if matchRoute(pattern, serviceName, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMutation reports whether the GraphQL document query defines a mutation.
+func hasMutation(query string) bool {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return false
+	}
+	for _, def := range doc.Definitions {
+		if op, ok := def.(*ast.OperationDefinition); ok && op.Operation == ast.OperationTypeMutation {
+			return true
+		}
+	}
+	return false
+}
+
+// serveGraphQL handles the GraphQL requests, sent as the JSON body of a POST or the query, variables and
+// operationName parameters of a GET, which cannot make mutations. The resolvers call the methods through
+// the gateway policies like the calls of a batch request. The schema is derived from the IDLs of the services
+// and supports introspection.
+func serveGraphQL(c context.Context, ctx *app.RequestContext) {
+	schema, ok := graphQLSchema.Load().(*graphql.Schema)
+	if !ok {
+		setErrorClass(ctx, "graphql_unavailable")
+		ctx.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.String(consts.StatusServiceUnavailable, "GraphQL schema not built")
+		return
+	}
+
+	var req graphQLRequest
+	if string(ctx.Method()) == http.MethodGet {
+		req.Query = ctx.Query("query")
+		req.OperationName = ctx.Query("operationName")
+		if variables := ctx.Query("variables"); variables != "" && unmarshalNumbers([]byte(variables), &req.Variables) != nil {
+			setErrorClass(ctx, "invalid_request")
+			ctx.SetStatusCode(http.StatusBadRequest)
+			ctx.String(consts.StatusBadRequest, "Invalid JSON data in variables")
+			return
+		}
+		if hasMutation(req.Query) {
+			setErrorClass(ctx, "invalid_request")
+			ctx.SetStatusCode(http.StatusMethodNotAllowed)
+			ctx.String(consts.StatusMethodNotAllowed, "Mutations require POST")
+			return
+		}
+	} else if unmarshalNumbers(ctx.Request.Body(), &req) != nil {
+		setErrorClass(ctx, "invalid_request")
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.String(consts.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+	if req.Query == "" {
+		setErrorClass(ctx, "invalid_request")
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.String(consts.StatusBadRequest, "Missing GraphQL query")
+		return
+	}
+
+	exactNumbers(req.Variables)
+
+	result := graphql.Do(graphql.Params{
+		Schema:         *schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        context.WithValue(c, graphQLOriginKey{}, newCallOrigin(c, ctx)),
+	})
+	ctx.JSON(consts.StatusOK, result)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/cloudwego/kitex/pkg/generic/descriptor"
+	"github.com/stretchr/testify/assert"
+)
+
+// useServiceIDLs defines ServiceA and ServiceB by the IDL of the RPC server.
+func useServiceIDLs(t *testing.T) {
+	previousIdls, previousContent := serviceIdlMap, idlContent
+	serviceIdlMap = map[string]string{"ServiceA": idlFile[0], "ServiceB": idlFile[0]}
+	idlContent = map[string]string{}
+	t.Cleanup(func() {
+		serviceIdlMap, idlContent = previousIdls, previousContent
+	})
+	assert.NoError(t, mapContent(idlFile[0]))
+}
+
+// useGraphQLSchema builds the GraphQL schema of ServiceA and ServiceB, defined by the IDL of the RPC server,
+// whose calls are answered with respond.
+func useGraphQLSchema(t *testing.T, mutations []string, respond func(c context.Context, method, request string) (string, error)) {
+	useFakeBackend(t, respond)
+	config.GraphQL.Mutations = mutations
+	useServiceIDLs(t)
+	assert.NoError(t, buildGraphQLSchema())
+}
+
+func graphQLCall(method, body string, query url.Values) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(method, "/graphql?"+query.Encode(), nil),
+	}
+	ctx.Request.SetHeader("Content-Type", "application/json")
+	ctx.Request.SetBodyString(body)
+	serveGraphQL(context.Background(), ctx)
+	return ctx
+}
+
+func TestGraphQL_Introspection(t *testing.T) {
+	useGraphQLSchema(t, []string{"*/methodC"}, nil)
+
+	ctx := graphQLCall(http.MethodGet, "", url.Values{"query": {`{
+		__schema { queryType { name } mutationType { name } }
+		query: __type(name: "Query") { fields { name args { name type { ofType { name } } } type { name } } }
+		mutation: __type(name: "Mutation") { fields { name } }
+	}`}})
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"data": {
+		"__schema": {"queryType": {"name": "Query"}, "mutationType": {"name": "Mutation"}},
+		"query": {"fields": [
+			{"name": "ServiceA_methodA", "args": [{"name": "req", "type": {"ofType": {"name": "RequestInput"}}}], "type": {"name": "Response"}},
+			{"name": "ServiceA_methodB", "args": [{"name": "req", "type": {"ofType": {"name": "RequestInput"}}}], "type": {"name": "Response"}},
+			{"name": "ServiceB_methodA", "args": [{"name": "req", "type": {"ofType": {"name": "RequestInput"}}}], "type": {"name": "Response"}},
+			{"name": "ServiceB_methodB", "args": [{"name": "req", "type": {"ofType": {"name": "RequestInput"}}}], "type": {"name": "Response"}},
+			{"name": "_services", "args": [], "type": {"name": null}}
+		]},
+		"mutation": {"fields": [{"name": "ServiceA_methodC"}, {"name": "ServiceB_methodC"}]}
+	}}`, string(ctx.Response.Body()))
+
+	ctx = graphQLCall(http.MethodGet, "", url.Values{"query": {`{ __type(name: "RequestInput") { inputFields { name type { name } } } }`}})
+	var input struct {
+		Data struct {
+			Type struct {
+				InputFields []map[string]interface{} `json:"inputFields"`
+			} `json:"__type"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(ctx.Response.Body(), &input))
+	assert.ElementsMatch(t, []map[string]interface{}{
+		{"name": "userId", "type": map[string]interface{}{"name": "String"}},
+		{"name": "message", "type": map[string]interface{}{"name": "String"}},
+	}, input.Data.Type.InputFields)
+}
+
+func TestGraphQL_ResolversCallMethods(t *testing.T) {
+	var received []string
+	useGraphQLSchema(t, []string{"*/methodC"}, func(_ context.Context, method, request string) (string, error) {
+		received = append(received, method+" "+request)
+		return `{"message": "hi from ` + method + `"}`, nil
+	})
+
+	ctx := graphQLCall(http.MethodPost, `{
+		"query": "query($req: RequestInput!) { a: ServiceA_methodA(req: $req) { message } ServiceB_methodB(req: {userId: \"u2\"}) { message } }",
+		"variables": {"req": {"userId": "u1", "message": "hi"}}
+	}`, nil)
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"data": {"a": {"message": "hi from methodA"}, "ServiceB_methodB": {"message": "hi from methodB"}}}`, string(ctx.Response.Body()))
+	assert.ElementsMatch(t, []string{`methodA {"message":"hi","userId":"u1"}`, `methodB {"userId":"u2"}`}, received)
+
+	ctx = graphQLCall(http.MethodPost, `{"query": "mutation { ServiceA_methodC(req: {userId: \"u1\"}) { message } }"}`, nil)
+	assert.JSONEq(t, `{"data": {"ServiceA_methodC": {"message": "hi from methodC"}}}`, string(ctx.Response.Body()))
+
+	ctx = graphQLCall(http.MethodGet, "", url.Values{"query": {`mutation { ServiceA_methodC(req: {userId: "u1"}) { message } }`}})
+	assert.Equal(t, http.StatusMethodNotAllowed, ctx.Response.StatusCode())
+
+	ctx = graphQLCall(http.MethodPost, `{"query": "{ ServiceC_methodA(req: {}) { message } }"}`, nil)
+	assert.Contains(t, string(ctx.Response.Body()), `Cannot query field \"ServiceC_methodA\"`)
+}
+
+func TestGraphQL_SchemaRebuiltOnIDLUpdate(t *testing.T) {
+	useGraphQLSchema(t, nil, nil)
+
+	file := filepath.Join(t.TempDir(), "serviceA.thrift")
+	assert.NoError(t, os.WriteFile(file, []byte(`namespace go api
+
+struct Greeting {
+    1: string userId
+    2: i64 count
+}
+
+struct Reply {
+    1: list<string> messages
+}
+
+service ServiceA {
+    Reply greet(1: Greeting greeting)
+}
+`), 0o600))
+	assert.NoError(t, updateIDL("ServiceA", file))
+
+	ctx := graphQLCall(http.MethodGet, "", url.Values{"query": {`{
+		__type(name: "Query") { fields { name args { name } type { name fields { name type { kind ofType { name } } } } } }
+	}`}})
+	assert.JSONEq(t, `{"data": {"__type": {"fields": [
+		{"name": "ServiceA_greet", "args": [{"name": "greeting"}], "type": {"name": "Reply", "fields": [{"name": "messages", "type": {"kind": "LIST", "ofType": {"name": "String"}}}]}},
+		{"name": "ServiceB_methodA", "args": [{"name": "req"}], "type": {"name": "Response", "fields": [{"name": "message", "type": {"kind": "SCALAR", "ofType": null}}]}},
+		{"name": "ServiceB_methodB", "args": [{"name": "req"}], "type": {"name": "Response", "fields": [{"name": "message", "type": {"kind": "SCALAR", "ofType": null}}]}},
+		{"name": "ServiceB_methodC", "args": [{"name": "req"}], "type": {"name": "Response", "fields": [{"name": "message", "type": {"kind": "SCALAR", "ofType": null}}]}},
+		{"name": "_services", "args": [], "type": {"name": null, "fields": null}}
+	]}}}`, string(ctx.Response.Body()))
+}
+
+func TestUnmarshalNumbers_KeepsLongPrecision(t *testing.T) {
+	var req graphQLRequest
+	assert.NoError(t, unmarshalNumbers([]byte(`{"query": "q", "variables": {"c": 9007199254740993, "r": 0.5, "l": [1, 2.5]}}`), &req))
+	assert.Equal(t, map[string]interface{}{"c": int64(9007199254740993), "r": 0.5, "l": []interface{}{int64(1), 2.5}},
+		exactNumbers(req.Variables))
+	assert.Equal(t, int64(9007199254740993), coerceLong(exactNumbers(json.Number("9007199254740993"))))
+
+	var response interface{}
+	assert.NoError(t, unmarshalNumbers([]byte(`{"count": 9007199254740993}`), &response))
+	assert.Equal(t, map[string]interface{}{"count": int64(9007199254740993)}, exactNumbers(response))
+
+	assert.Error(t, unmarshalNumbers([]byte(`{"query": "q"} {}`), &req))
+	assert.Error(t, unmarshalNumbers([]byte(`{"query": "q"`), &req))
+}
+
+// useConflictingStructs makes ServiceB defined by an IDL of its own, defining a Response struct of other fields than
+// the Response of ServiceA.
+func useConflictingStructs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "serviceB.thrift")
+	assert.NoError(t, os.WriteFile(file, []byte(`namespace go b
+
+struct Request {
+    1: string userId
+}
+
+struct Response {
+    1: i64 count
+}
+
+service ServiceB {
+    Response methodA(1: Request req)
+}
+`), 0o600))
+	serviceIdlMap["ServiceB"] = file
+	assert.NoError(t, mapContent(file))
+}
+
+func TestGraphQL_StructsOfSameNameKeptApart(t *testing.T) {
+	useGraphQLSchema(t, nil, nil)
+	useConflictingStructs(t)
+	assert.NoError(t, buildGraphQLSchema())
+
+	ctx := graphQLCall(http.MethodGet, "", url.Values{"query": {`{
+		a: __type(name: "Response") { fields { name } }
+		b: __type(name: "ServiceB_Response") { fields { name } }
+		q: __type(name: "Query") { fields { name type { name } } }
+	}`}})
+	assert.JSONEq(t, `{"data": {
+		"a": {"fields": [{"name": "message"}]},
+		"b": {"fields": [{"name": "count"}]},
+		"q": {"fields": [
+			{"name": "ServiceA_methodA", "type": {"name": "Response"}},
+			{"name": "ServiceA_methodB", "type": {"name": "Response"}},
+			{"name": "ServiceA_methodC", "type": {"name": "Response"}},
+			{"name": "ServiceB_methodA", "type": {"name": "ServiceB_Response"}},
+			{"name": "_services", "type": {"name": null}}
+		]}
+	}}`, string(ctx.Response.Body()))
+}
+
+func TestStructNames_RejectsConflictingQualifiedName(t *testing.T) {
+	taken := &descriptor.StructDescriptor{Name: "ServiceB_Request"}
+	request := &descriptor.StructDescriptor{Name: "Request"}
+	other := &descriptor.StructDescriptor{Name: "Request"}
+	n := newStructNames()
+	for _, s := range []*descriptor.StructDescriptor{taken, request} {
+		assert.NoError(t, n.add("ServiceA", &descriptor.TypeDescriptor{Type: descriptor.STRUCT, Struct: s}))
+	}
+	assert.Error(t, n.add("ServiceB", &descriptor.TypeDescriptor{Type: descriptor.STRUCT, Struct: other}))
+}
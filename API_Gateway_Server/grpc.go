@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cloudwego/hertz/pkg/network"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/cloudwego/kitex/pkg/generic/descriptor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// grpcPackage is the protobuf package of the gRPC services of the gateway.
+const grpcPackage = "gateway"
+
+// grpcAPI holds the *grpcServices derived from the IDLs of the services, rebuilt whenever an IDL changes.
+var grpcAPI atomic.Value
+
+// grpcCodes are the gRPC status codes of the JSON-RPC error codes classifying the failures of the calls.
+var grpcCodes = map[int]codes.Code{
+	jsonRPCMethodNotFound:     codes.Unimplemented,
+	jsonRPCInvalidParams:      codes.InvalidArgument,
+	jsonRPCInternalError:      codes.Internal,
+	jsonRPCBackendError:       codes.Unknown,
+	jsonRPCTimeout:            codes.DeadlineExceeded,
+	jsonRPCUnavailable:        codes.Unavailable,
+	jsonRPCUnauthorized:       codes.Unauthenticated,
+	jsonRPCForbidden:          codes.PermissionDenied,
+	jsonRPCTooManyRequests:    codes.ResourceExhausted,
+	jsonRPCPreconditionFailed: codes.FailedPrecondition,
+}
+
+// grpcConfig configures the gRPC endpoint, served on Address if set.
+type grpcConfig struct {
+	Address string `json:"address"`
+}
+
+// grpcServices describes the gRPC services derived from the IDLs of the services.
+type grpcServices struct {
+	files   *protoregistry.Files
+	methods map[string]grpcMethod
+}
+
+// grpcMethod is the method of a service called by a gRPC method, keyed by its full name such as "/gateway.ServiceA/methodA".
+type grpcMethod struct {
+	serviceName string
+	method      string
+	desc        protoreflect.MethodDescriptor
+	void        bool
+}
+
+// protoMessages derives the protobuf messages of the Thrift structs into file, a message for each struct,
+// named by names. The fields keep the names and IDs of the Thrift fields.
+type protoMessages struct {
+	file    *descriptorpb.FileDescriptorProto
+	names   *structNames
+	defined map[string]bool
+}
+
+// message returns the full name of the message of the struct s.
+func (m *protoMessages) message(s *descriptor.StructDescriptor) string {
+	name := m.names.of(s)
+	fullName := "." + grpcPackage + "." + name
+	if m.defined[name] {
+		return fullName
+	}
+	m.defined[name] = true
+
+	msg := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	m.file.MessageType = append(m.file.MessageType, msg)
+	for _, f := range sortedFields(s) {
+		m.field(msg, fullName, f)
+	}
+	return fullName
+}
+
+// field adds the field f to msg, named fullName, unless its ID or type has no protobuf equivalent.
+// Lists and sets become repeated fields, and maps map fields.
+func (m *protoMessages) field(msg *descriptorpb.DescriptorProto, fullName string, f *descriptor.FieldDescriptor) {
+	number := protowire.Number(f.ID)
+	if !number.IsValid() {
+		return
+	}
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(graphQLName(f.Name)),
+		Number: proto.Int32(f.ID),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+
+	switch f.Type.Type {
+	case descriptor.LIST, descriptor.SET:
+		if !m.singular(field, f.Type.Elem) {
+			return
+		}
+		field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	case descriptor.MAP:
+		key := &descriptorpb.FieldDescriptorProto{Name: proto.String("key"), Number: proto.Int32(1), Label: field.Label}
+		value := &descriptorpb.FieldDescriptorProto{Name: proto.String("value"), Number: proto.Int32(2), Label: field.Label}
+		if !m.singular(key, f.Type.Key) || !m.singular(value, f.Type.Elem) {
+			return
+		}
+		switch key.GetType() {
+		case descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, descriptorpb.FieldDescriptorProto_TYPE_BYTES, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+			return
+		}
+		entry := &descriptorpb.DescriptorProto{
+			Name:    proto.String(mapEntryName(field.GetName())),
+			Field:   []*descriptorpb.FieldDescriptorProto{key, value},
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		}
+		msg.NestedType = append(msg.NestedType, entry)
+		field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		field.TypeName = proto.String(fullName + "." + entry.GetName())
+		field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	default:
+		if !m.singular(field, f.Type) {
+			return
+		}
+	}
+	msg.Field = append(msg.Field, field)
+}
+
+// mapEntryName returns the name of the entry message of the map field named name, as protoc names it:
+// name in camel case followed by Entry.
+func mapEntryName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		switch {
+		case r == '_':
+			upper = true
+		case upper:
+			b.WriteString(strings.ToUpper(string(r)))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String() + "Entry"
+}
+
+// singular sets the type of field to the protobuf type of the Thrift type t, which must not be a list, set or map.
+// It returns false if t has no protobuf equivalent.
+func (m *protoMessages) singular(field *descriptorpb.FieldDescriptorProto, t *descriptor.TypeDescriptor) bool {
+	var fieldType descriptorpb.FieldDescriptorProto_Type
+	switch t.Type {
+	case descriptor.BOOL:
+		fieldType = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	case descriptor.I08, descriptor.I16, descriptor.I32:
+		fieldType = descriptorpb.FieldDescriptorProto_TYPE_INT32
+	case descriptor.I64:
+		fieldType = descriptorpb.FieldDescriptorProto_TYPE_INT64
+	case descriptor.DOUBLE:
+		fieldType = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+	case descriptor.STRING:
+		fieldType = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		if t.Name == "binary" {
+			fieldType = descriptorpb.FieldDescriptorProto_TYPE_BYTES
+		}
+	case descriptor.STRUCT:
+		fieldType = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+		field.TypeName = proto.String(m.message(t.Struct))
+	default:
+		return false
+	}
+	field.Type = fieldType.Enum()
+	return true
+}
+
+// buildGRPCServices derives the gRPC services from the IDLs of the services and makes them the services of the
+// gRPC endpoint. Each service becomes a service of the gateway package, whose methods are those taking a single
+// struct and returning a struct, or nothing as google.protobuf.Empty, with the messages derived from the structs.
+// It returns an error if an IDL cannot be parsed or the services are invalid, keeping the previous services.
+func buildGRPCServices() error {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String(grpcPackage + ".proto"),
+		Package:    proto.String(grpcPackage),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{emptypb.File_google_protobuf_empty_proto.Path()},
+	}
+	m := &protoMessages{file: file, names: newStructNames(), defined: map[string]bool{}}
+	type target struct {
+		service, name       string
+		serviceName, method string
+		void                bool
+	}
+	var targets []target
+
+	services := map[string]*descriptorpb.ServiceDescriptorProto{}
+	var nameErr error
+	err := forEachMethod(func(serviceName, method string, fn *descriptor.FunctionDescriptor) {
+		if nameErr == nil {
+			nameErr = m.names.addMethod(serviceName, fn)
+		}
+		if nameErr != nil {
+			return
+		}
+		arg, ok := requestArg(fn)
+		result := resultType(fn)
+		if !ok || (result != nil && result.Type != descriptor.STRUCT) {
+			return
+		}
+		output := ".google.protobuf.Empty"
+		if result != nil {
+			output = m.message(result.Struct)
+		}
+
+		svc, ok := services[serviceName]
+		if !ok {
+			svc = &descriptorpb.ServiceDescriptorProto{Name: proto.String(graphQLName(serviceName))}
+			services[serviceName] = svc
+			file.Service = append(file.Service, svc)
+		}
+		t := target{service: svc.GetName(), name: graphQLName(method), serviceName: serviceName, method: method, void: result == nil}
+		svc.Method = append(svc.Method, &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(t.name),
+			InputType:  proto.String(m.message(arg.Type.Struct)),
+			OutputType: proto.String(output),
+		})
+		targets = append(targets, t)
+	})
+	if err == nil {
+		err = nameErr
+	}
+	if err != nil {
+		return err
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		return err
+	}
+	files := &protoregistry.Files{}
+	for _, f := range []protoreflect.FileDescriptor{fd, emptypb.File_google_protobuf_empty_proto} {
+		if err := files.RegisterFile(f); err != nil {
+			return err
+		}
+	}
+
+	api := &grpcServices{files: files, methods: map[string]grpcMethod{}}
+	for _, t := range targets {
+		desc := fd.Services().ByName(protoreflect.Name(t.service)).Methods().ByName(protoreflect.Name(t.name))
+		api.methods["/"+string(desc.Parent().FullName())+"/"+t.name] = grpcMethod{serviceName: t.serviceName, method: t.method, desc: desc, void: t.void}
+	}
+	grpcAPI.Store(api)
+	return nil
+}
+
+// peerConn is the connection of a gRPC client as seen by the gateway middlewares, which only read its remote address.
+type peerConn struct {
+	network.Conn
+	addr net.Addr
+}
+
+// RemoteAddr returns the address of the client.
+func (p peerConn) RemoteAddr() net.Addr {
+	return p.addr
+}
+
+// newGRPCOrigin returns the origin of the calls made on behalf of the gRPC call in c,
+// whose metadata are the headers and whose peer is the client.
+func newGRPCOrigin(c context.Context) *callOrigin {
+	md, _ := metadata.FromIncomingContext(c)
+	o := &callOrigin{}
+	for key, values := range md {
+		if strings.HasPrefix(key, ":") || strings.HasPrefix(key, "grpc-") || strings.HasSuffix(key, "-bin") ||
+			key == "content-type" || key == "te" {
+			continue
+		}
+		for _, value := range values {
+			o.header.Add(key, value)
+		}
+	}
+	if ids := md.Get(requestIDHeader); len(ids) > 0 {
+		o.requestID = ids[0]
+	} else {
+		o.requestID = newRequestID()
+	}
+	if p, ok := peer.FromContext(c); ok {
+		o.conn = peerConn{addr: p.Addr}
+	}
+	return o
+}
+
+// rawMessage is a request message received along with its protobuf encoding, which the signature of a call covers.
+type rawMessage struct {
+	*dynamicpb.Message
+	raw []byte
+}
+
+// rawCodec is the protobuf codec of the gRPC server, keeping the encoding of the rawMessage it decodes.
+type rawCodec struct {
+	encoding.Codec
+}
+
+// Unmarshal decodes data into v, keeping data in v if it is a rawMessage.
+func (r rawCodec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(*rawMessage); ok {
+		m.raw = append([]byte(nil), data...)
+		v = m.Message
+	}
+	return r.Codec.Unmarshal(data, v)
+}
+
+// serveGRPC handles the calls to the gRPC services, translating the protobuf request into the JSON request struct
+// of the generic call and its JSON response back into protobuf. The calls go through the gateway policies like
+// the calls of a batch request, with the metadata of the call as headers. Failed calls are answered with the status
+// code matching the JSON-RPC error code of the failure. A call signed like the hmac requests, over the POST method,
+// the full method name and the protobuf request, is made on behalf of the client that signed it.
+func serveGRPC(_ interface{}, stream grpc.ServerStream) error {
+	name, _ := grpc.MethodFromServerStream(stream)
+	api, _ := grpcAPI.Load().(*grpcServices)
+	if api == nil {
+		return status.Error(codes.Unavailable, "gRPC services not built")
+	}
+	m, ok := api.methods[name]
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "unknown method %s", name)
+	}
+
+	req := &rawMessage{Message: dynamicpb.NewMessage(m.desc.Input())}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	body, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(req.Message)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	c := stream.Context()
+	origin := newGRPCOrigin(c)
+	origin.verifySignature(c, http.MethodPost, name, req.raw)
+	call := origin.dispatch(c, "0", m.serviceName, m.method, body)
+	if call.Response.StatusCode() != consts.StatusOK {
+		resp := callResponse(call)
+		return status.Error(grpcCodes[resp.Error.Code], string(call.Response.Body()))
+	}
+
+	resp := dynamicpb.NewMessage(m.desc.Output())
+	if !m.void {
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(call.Response.Body(), resp); err != nil {
+			return status.Errorf(codes.Internal, "invalid response of %s.%s: %s", m.serviceName, m.method, err)
+		}
+	}
+	return stream.SendMsg(resp)
+}
+
+// grpcReflection describes the gRPC services to the reflection service, with those registered on server.
+type grpcReflection struct {
+	server *grpc.Server
+}
+
+// GetServiceInfo returns the services registered on the server and the services derived from the IDLs.
+func (r grpcReflection) GetServiceInfo() map[string]grpc.ServiceInfo {
+	info := r.server.GetServiceInfo()
+	if api, ok := grpcAPI.Load().(*grpcServices); ok {
+		for name := range api.methods {
+			service, method, _ := strings.Cut(strings.TrimPrefix(name, "/"), "/")
+			s := info[service]
+			s.Methods = append(s.Methods, grpc.MethodInfo{Name: method})
+			s.Metadata = grpcPackage + ".proto"
+			info[service] = s
+		}
+	}
+	return info
+}
+
+// files returns the descriptors of the services derived from the IDLs, then those of the registered services.
+func (r grpcReflection) files() []*protoregistry.Files {
+	if api, ok := grpcAPI.Load().(*grpcServices); ok {
+		return []*protoregistry.Files{api.files, protoregistry.GlobalFiles}
+	}
+	return []*protoregistry.Files{protoregistry.GlobalFiles}
+}
+
+// FindFileByPath returns the descriptor of the file at path.
+func (r grpcReflection) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	for _, files := range r.files() {
+		if fd, err := files.FindFileByPath(path); err == nil {
+			return fd, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+// FindDescriptorByName returns the descriptor of name.
+func (r grpcReflection) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	for _, files := range r.files() {
+		if d, err := files.FindDescriptorByName(name); err == nil {
+			return d, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+// newGRPCServer returns the server of the gRPC services, over TLS when the gateway serves HTTPS,
+// with the reflection service describing them.
+func newGRPCServer() *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.UnknownServiceHandler(serveGRPC),
+		grpc.ForceServerCodec(rawCodec{encoding.GetCodec(grpcproto.Name)}),
+	}
+	if serverTLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(serverTLS)))
+	}
+	server := grpc.NewServer(opts...)
+	r := grpcReflection{server}
+	reflectionpb.RegisterServerReflectionServer(server, reflection.NewServer(reflection.ServerOptions{
+		Services:           r,
+		DescriptorResolver: r,
+	}))
+	return server
+}
+
+// startGRPCServer serves the gRPC services on the address of cfg, unless it is empty.
+// It returns the function stopping the server, or an error if the address cannot be listened on.
+func startGRPCServer(cfg grpcConfig) (func(), error) {
+	if cfg.Address == "" {
+		return func() {}, nil
+	}
+	listener, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	server := newGRPCServer()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	return server.GracefulStop, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"API_Gateway_Server/hmacsign"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/kitex/pkg/kerrors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// useGRPCServer serves the gRPC services of ServiceA and ServiceB, whose calls are answered with respond,
+// and returns a connection to the server.
+func useGRPCServer(t *testing.T, respond func(c context.Context, method, request string) (string, error)) *grpc.ClientConn {
+	useFakeBackend(t, respond)
+	config.GRPC.Address = "bufconn"
+	useServiceIDLs(t)
+	assert.NoError(t, buildGRPCServices())
+
+	listener := bufconn.Listen(1 << 20)
+	server := newGRPCServer()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(c context.Context, _ string) (net.Conn, error) { return listener.DialContext(c) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+		server.Stop()
+	})
+	return conn
+}
+
+// grpcCall calls the gRPC method name with the request in the protobuf JSON mapping and returns the response in it.
+func grpcCall(c context.Context, conn *grpc.ClientConn, name, request string) (string, error) {
+	api := grpcAPI.Load().(*grpcServices)
+	desc, ok := api.methods[name]
+	if !ok {
+		return "", errors.New("no method " + name)
+	}
+	in := dynamicpb.NewMessage(desc.desc.Input())
+	if err := protojson.Unmarshal([]byte(request), in); err != nil {
+		return "", err
+	}
+	out := dynamicpb.NewMessage(desc.desc.Output())
+	if err := conn.Invoke(c, name, in, out); err != nil {
+		return "", err
+	}
+	response, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(out)
+	return string(response), err
+}
+
+func TestBuildGRPCServices_MapsIDL(t *testing.T) {
+	useGRPCServer(t, nil)
+
+	api := grpcAPI.Load().(*grpcServices)
+	d, err := api.files.FindDescriptorByName("gateway.ServiceB")
+	assert.NoError(t, err)
+	methods := d.(protoreflect.ServiceDescriptor).Methods()
+	assert.Equal(t, 3, methods.Len())
+	assert.Equal(t, protoreflect.FullName("gateway.Request"), methods.ByName("methodC").Input().FullName())
+	assert.Equal(t, protoreflect.FullName("gateway.Response"), methods.ByName("methodC").Output().FullName())
+
+	d, err = api.files.FindDescriptorByName("gateway.Request")
+	assert.NoError(t, err)
+	fields := d.(protoreflect.MessageDescriptor).Fields()
+	assert.Equal(t, protoreflect.Name("userId"), fields.ByNumber(1).Name())
+	assert.Equal(t, protoreflect.StringKind, fields.ByNumber(1).Kind())
+	assert.Equal(t, protoreflect.Name("message"), fields.ByNumber(2).Name())
+}
+
+func TestServeGRPC_CallsMethods(t *testing.T) {
+	var received string
+	conn := useGRPCServer(t, func(_ context.Context, method, request string) (string, error) {
+		received = request
+		switch method {
+		case "methodB":
+			return "", kerrors.ErrRPCTimeout.WithCause(errors.New("1s"))
+		case "methodC":
+			return "", kerrors.NewBizStatusError(preconditionFailedStatus, "precondition failed")
+		}
+		return `{"message": "hi from ` + method + `", "extra": "x"}`, nil
+	})
+
+	c := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "grpc-id")
+	response, err := grpcCall(c, conn, "/gateway.ServiceA/methodA", `{"userId": "u1", "message": "hi"}`)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"message": "hi from methodA"}`, response)
+	assert.JSONEq(t, `{"userId": "u1", "message": "hi"}`, received)
+
+	_, err = grpcCall(c, conn, "/gateway.ServiceA/methodB", `{}`)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	_, err = grpcCall(c, conn, "/gateway.ServiceA/methodC", `{}`)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	err = conn.Invoke(c, "/gateway.ServiceA/methodD", &reflectionpb.ServerReflectionRequest{}, &reflectionpb.ServerReflectionResponse{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestServeGRPC_AppliesGatewayPolicies(t *testing.T) {
+	conn := useGRPCServer(t, func(context.Context, string, string) (string, error) {
+		return `{"message": "hi"}`, nil
+	})
+	store := useAPIKeys(t, "ServiceB/*")
+	config.GRPC.Address = "bufconn"
+	key, hash := newAPIKeySecret("abc")
+	assert.NoError(t, store.Put(apiKey{ID: "abc", Hash: hash, Owner: "client"}))
+
+	_, err := grpcCall(context.Background(), conn, "/gateway.ServiceB/methodA", `{}`)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	c := metadata.AppendToOutgoingContext(context.Background(), apiKeyHeader, key)
+	response, err := grpcCall(c, conn, "/gateway.ServiceB/methodA", `{}`)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"message": "hi"}`, response)
+}
+
+func TestServeGRPC_SignedCalls(t *testing.T) {
+	conn := useGRPCServer(t, func(c context.Context, _, _ string) (string, error) {
+		client, _ := metainfo.GetValue(c, hmacClientMetaKey)
+		return `{"message": "` + client + `"}`, nil
+	})
+	useHMACConfig(t)
+	config.GRPC.Address = "bufconn"
+
+	name := "/gateway.ServiceA/methodA"
+	in := dynamicpb.NewMessage(grpcAPI.Load().(*grpcServices).methods[name].desc.Input())
+	assert.NoError(t, protojson.Unmarshal([]byte(`{"userId": "u1"}`), in))
+	raw, err := proto.Marshal(in)
+	assert.NoError(t, err)
+	timestamp, nonce := strconv.FormatInt(time.Now().Unix(), 10), hmacsign.NewNonce()
+	c := metadata.AppendToOutgoingContext(context.Background(),
+		hmacsign.HeaderClientID, "billing",
+		hmacsign.HeaderTimestamp, timestamp,
+		hmacsign.HeaderNonce, nonce,
+		hmacsign.HeaderSignature, hmacsign.Sign([]byte("secret"), http.MethodPost, name, timestamp, nonce, raw))
+
+	response, err := grpcCall(c, conn, name, `{"userId": "u1"}`)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"message": "billing"}`, response)
+
+	_, err = grpcCall(c, conn, name, `{"userId": "u1"}`)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "a replayed call is not signed")
+	_, err = grpcCall(context.Background(), conn, name, `{"userId": "u1"}`)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestBuildGRPCServices_StructsOfSameNameKeptApart(t *testing.T) {
+	useGRPCServer(t, nil)
+	useConflictingStructs(t)
+	assert.NoError(t, buildGRPCServices())
+
+	methods := grpcAPI.Load().(*grpcServices).methods
+	output := methods["/gateway.ServiceA/methodA"].desc.Output()
+	assert.Equal(t, protoreflect.FullName("gateway.Response"), output.FullName())
+	assert.Equal(t, protoreflect.Name("message"), output.Fields().ByNumber(1).Name())
+	output = methods["/gateway.ServiceB/methodA"].desc.Output()
+	assert.Equal(t, protoreflect.FullName("gateway.ServiceB_Response"), output.FullName())
+	assert.Equal(t, protoreflect.Name("count"), output.Fields().ByNumber(1).Name())
+}
+
+func TestBuildGRPCServices_MapsThriftTypes(t *testing.T) {
+	useGRPCServer(t, nil)
+
+	file := filepath.Join(t.TempDir(), "serviceA.thrift")
+	assert.NoError(t, os.WriteFile(file, []byte(`namespace go api
+
+struct Owner {
+    1: string name
+}
+
+struct Item {
+    1: i64 id
+    2: list<string> tags
+    3: map<string, i32> counts
+    4: Owner owner
+    5: list<list<string>> matrix
+    6: binary data
+    7: set<double> scores
+}
+
+service ServiceA {
+    Item save(1: Item item)
+    void forget(1: Item item)
+    string name(1: Item item)
+}
+`), 0o600))
+	serviceIdlMap["ServiceA"] = file
+	assert.NoError(t, mapContent(file))
+	assert.NoError(t, buildGRPCServices())
+
+	api := grpcAPI.Load().(*grpcServices)
+	assert.Equal(t, protoreflect.FullName("gateway.Item"), api.methods["/gateway.ServiceA/save"].desc.Output().FullName())
+	assert.Equal(t, protoreflect.FullName("google.protobuf.Empty"), api.methods["/gateway.ServiceA/forget"].desc.Output().FullName())
+	assert.True(t, api.methods["/gateway.ServiceA/forget"].void)
+	_, ok := api.methods["/gateway.ServiceA/name"]
+	assert.False(t, ok, "methods not returning a struct are not exposed")
+	_, ok = api.methods["/gateway.ServiceB/methodA"]
+	assert.True(t, ok)
+
+	d, err := api.files.FindDescriptorByName("gateway.Item")
+	assert.NoError(t, err)
+	fields := d.(protoreflect.MessageDescriptor).Fields()
+	assert.Equal(t, protoreflect.Int64Kind, fields.ByName("id").Kind())
+	assert.True(t, fields.ByName("tags").IsList())
+	assert.Equal(t, protoreflect.StringKind, fields.ByName("tags").Kind())
+	assert.True(t, fields.ByName("counts").IsMap())
+	assert.Equal(t, protoreflect.StringKind, fields.ByName("counts").MapKey().Kind())
+	assert.Equal(t, protoreflect.Int32Kind, fields.ByName("counts").MapValue().Kind())
+	assert.Equal(t, protoreflect.FullName("gateway.Owner"), fields.ByName("owner").Message().FullName())
+	assert.Nil(t, fields.ByName("matrix"), "nested lists have no protobuf equivalent")
+	assert.Equal(t, protoreflect.BytesKind, fields.ByName("data").Kind())
+	assert.True(t, fields.ByName("scores").IsList())
+	assert.Equal(t, protowire.Number(7), fields.ByName("scores").Number())
+}
+
+func TestServeGRPC_Reflection(t *testing.T) {
+	conn := useGRPCServer(t, nil)
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}))
+	resp, err := stream.Recv()
+	assert.NoError(t, err)
+	var services []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		services = append(services, s.GetName())
+	}
+	assert.Equal(t, []string{"gateway.ServiceA", "gateway.ServiceB", "grpc.reflection.v1alpha.ServerReflection"}, services)
+
+	assert.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "gateway.ServiceA"},
+	}))
+	resp, err = stream.Recv()
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetFileDescriptorResponse().GetFileDescriptorProto(), 2, "the file and google/protobuf/empty.proto")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"API_Gateway_Server/hmacsign"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// defaultSignatureSkew is the default maximum difference between the timestamp of a signed request and the gateway clock.
+const defaultSignatureSkew = 5 * time.Minute
+
+// hmacClientKey is the key of the ID of the client that signed a request stored in its app.RequestContext.
+const hmacClientKey = "hmacClient"
+
+// hmacClientMetaKey is the Kitex metadata key the ID of the client that signed the request is forwarded to the backend under.
+const hmacClientMetaKey = "client_id"
+
+// hmacConfig requires the requests to the routes matching one of Routes to be signed
+// with the secret of one of Clients, mapping client IDs to their shared secrets, and grants
+// the signing clients their ClientRoles for authorization. Requests signed more than Skew
+// away from the gateway clock are rejected, and the nonces seen within that window are
+// remembered in sharedStore to reject replays.
+type hmacConfig struct {
+	Clients     map[string]string   `json:"clients"`
+	ClientRoles map[string][]string `json:"clientRoles"`
+	Routes      []string            `json:"routes"`
+	Skew        duration            `json:"skew"`
+}
+
+// rejectSignature aborts the request in ctx with 401 and msg.
+func rejectSignature(ctx *app.RequestContext, msg string) {
+	ctx.AbortWithMsg(msg, http.StatusUnauthorized)
+	setErrorClass(ctx, "invalid_signature")
+}
+
+// verifySignature is the middleware requiring a valid HMAC signature on the routes of the HMAC configuration.
+// Requests with a missing or invalid signature, a timestamp outside the skew window or a nonce
+// already used are rejected with 401. The client ID is stored in ctx and forwarded to the backend.
+// The calls dispatched on behalf of a request whose signature was verified already hold its client ID,
+// and are not checked again.
+func verifySignature(c context.Context, ctx *app.RequestContext) {
+	serviceName, method := routeOf(ctx)
+
+	required := false
+	for _, pattern := range config.HMAC.Routes {
+		if matchRoute(pattern, serviceName, method) {
+			required = true
+			break
+		}
+	}
+	if !required {
+		ctx.Next(c)
+		return
+	}
+
+	clientID := ctx.GetString(hmacClientKey)
+	if clientID == "" {
+		var rejection string
+		clientID, rejection = checkSignature(c, func(key string) string {
+			return string(ctx.GetHeader(key))
+		}, string(ctx.Method()), string(ctx.Request.URI().RequestURI()), rawBody(ctx))
+		if rejection != "" {
+			rejectSignature(ctx, rejection)
+			return
+		}
+		ctx.Set(hmacClientKey, clientID)
+	}
+	ctx.Next(metainfo.WithValue(c, hmacClientMetaKey, clientID))
+}
+
+// checkSignature checks the signature in the headers returned by header of the request made with method to uri
+// with body, remembering its nonce. It returns the ID of the client that signed the request,
+// or the message rejecting it.
+func checkSignature(c context.Context, header func(key string) string, method, uri string, body []byte) (string, string) {
+	clientID := header(hmacsign.HeaderClientID)
+	timestamp := header(hmacsign.HeaderTimestamp)
+	nonce := header(hmacsign.HeaderNonce)
+	signature := header(hmacsign.HeaderSignature)
+	if clientID == "" || timestamp == "" || nonce == "" || signature == "" {
+		return "", "Missing request signature"
+	}
+
+	secret, ok := config.HMAC.Clients[clientID]
+	if !ok {
+		return "", "Invalid request signature"
+	}
+
+	skew := time.Duration(config.HMAC.Skew)
+	if skew <= 0 {
+		skew = defaultSignatureSkew
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", "Invalid request timestamp"
+	}
+	age := time.Since(time.Unix(seconds, 0))
+	if age > skew || age < -skew {
+		return "", "Request timestamp outside the allowed window"
+	}
+
+	if !hmacsign.Verify([]byte(secret), method, uri, timestamp, nonce, body, signature) {
+		return "", "Invalid request signature"
+	}
+
+	// A nonce is remembered for twice the skew, covering every timestamp it can be replayed with.
+	fresh, err := sharedStore.CompareAndSwap(c, "nonce/"+clientID+"/"+nonce, nil, []byte(timestamp), 2*skew)
+	if err == nil && !fresh {
+		return "", "Replayed request"
+	}
+	return clientID, ""
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"API_Gateway_Server/hmacsign"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func useHMACConfig(t *testing.T) {
+	config = &gatewayConfig{HMAC: hmacConfig{
+		Clients: map[string]string{"billing": "secret"},
+		Routes:  []string{"ServiceA/*"},
+		Skew:    duration(time.Minute),
+	}}
+	previous := sharedStore
+	sharedStore = newMemoryKV()
+	t.Cleanup(func() {
+		config = &gatewayConfig{}
+		sharedStore = previous
+	})
+}
+
+// signedContext signs a request with signer and returns it as received by the gateway.
+func signedContext(t *testing.T, signer *hmacsign.Signer, uri, body string) *app.RequestContext {
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1:8888"+uri, strings.NewReader(body))
+	assert.NoError(t, err)
+	assert.NoError(t, signer.SignRequest(req))
+
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, uri, nil),
+	}
+	for k := range req.Header {
+		ctx.Request.SetHeader(k, req.Header.Get(k))
+	}
+	ctx.Request.SetBodyString(body)
+	return ctx
+}
+
+func TestVerifySignature_AcceptsSignedRequestOnce(t *testing.T) {
+	useHMACConfig(t)
+	signer := &hmacsign.Signer{ClientID: "billing", Secret: []byte("secret")}
+
+	ctx := signedContext(t, signer, "/ServiceA/methodA?x=1", `{"message": "hi"}`)
+	verifySignature(context.Background(), ctx)
+	assert.False(t, ctx.IsAborted())
+	assert.Equal(t, "billing", ctx.GetString(hmacClientKey))
+
+	replay := &app.RequestContext{}
+	ctx.Request.CopyTo(&replay.Request)
+	verifySignature(context.Background(), replay)
+	assert.Equal(t, http.StatusUnauthorized, replay.Response.StatusCode())
+	assert.Equal(t, "Replayed request", string(replay.Response.Body()))
+}
+
+func TestVerifySignature_AfterClaimFields(t *testing.T) {
+	useHMACConfig(t)
+	config.JWT = jwtConfig{HMACSecret: testSecret, Rules: []jwtRule{{
+		Route:       "ServiceA/*",
+		ClaimFields: map[string]string{"sub": "userId"},
+	}}}
+	var err error
+	verifier, err = newJWTVerifier(config.JWT)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		verifier = nil
+	})
+
+	signer := &hmacsign.Signer{ClientID: "billing", Secret: []byte("secret")}
+	ctx := signedContext(t, signer, "/ServiceA/methodA", `{"userId": "spoofed", "message": "hi"}`)
+	ctx.Request.SetHeader("Authorization", "Bearer "+signHS256(t, validClaims()))
+	var forwarded string
+	ctx.SetHandlers(app.HandlersChain{jwtAuth, verifySignature, func(_ context.Context, ctx *app.RequestContext) {
+		forwarded = string(ctx.Request.Body())
+	}})
+	jwtAuth(context.Background(), ctx)
+
+	assert.False(t, ctx.IsAborted(), string(ctx.Response.Body()))
+	assert.JSONEq(t, `{"userId": "user-1", "message": "hi"}`, forwarded, "the signature is checked against the body as sent")
+}
+
+func TestVerifySignature_Rejects(t *testing.T) {
+	useHMACConfig(t)
+
+	for _, tc := range []struct {
+		name   string
+		signer *hmacsign.Signer
+		tamper func(ctx *app.RequestContext)
+	}{
+		{"unknown client", &hmacsign.Signer{ClientID: "other", Secret: []byte("secret")}, nil},
+		{"wrong secret", &hmacsign.Signer{ClientID: "billing", Secret: []byte("wrong")}, nil},
+		{"stale timestamp", &hmacsign.Signer{ClientID: "billing", Secret: []byte("secret"), Now: func() time.Time {
+			return time.Now().Add(-2 * time.Minute)
+		}}, nil},
+		{"tampered body", &hmacsign.Signer{ClientID: "billing", Secret: []byte("secret")}, func(ctx *app.RequestContext) {
+			ctx.Request.SetBodyString(`{"message": "bye"}`)
+		}},
+		{"missing signature", &hmacsign.Signer{ClientID: "billing", Secret: []byte("secret")}, func(ctx *app.RequestContext) {
+			ctx.Request.Header.DelBytes([]byte(hmacsign.HeaderSignature))
+		}},
+	} {
+		ctx := signedContext(t, tc.signer, "/ServiceA/methodA", `{"message": "hi"}`)
+		if tc.tamper != nil {
+			tc.tamper(ctx)
+		}
+		verifySignature(context.Background(), ctx)
+		assert.Equal(t, http.StatusUnauthorized, ctx.Response.StatusCode(), tc.name)
+	}
+
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, "/ServiceB/methodA", nil),
+	}
+	verifySignature(context.Background(), ctx)
+	assert.False(t, ctx.IsAborted())
+}
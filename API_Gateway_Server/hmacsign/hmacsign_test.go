@@ -0,0 +1,31 @@
+package hmacsign
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignRequest_VerifiesAndKeepsBody(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1:8888/ServiceA/methodA?x=1", strings.NewReader(`{"message": "hi"}`))
+	assert.NoError(t, err)
+
+	signer := &Signer{ClientID: "billing", Secret: []byte("secret"), Now: func() time.Time { return time.Unix(1700000000, 0) }}
+	assert.NoError(t, signer.SignRequest(req))
+
+	assert.Equal(t, "billing", req.Header.Get(HeaderClientID))
+	assert.Equal(t, "1700000000", req.Header.Get(HeaderTimestamp))
+	body, err := io.ReadAll(req.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"message": "hi"}`, string(body))
+
+	nonce, signature := req.Header.Get(HeaderNonce), req.Header.Get(HeaderSignature)
+	assert.True(t, Verify([]byte("secret"), "POST", "/ServiceA/methodA?x=1", "1700000000", nonce, body, signature))
+	assert.False(t, Verify([]byte("other"), "POST", "/ServiceA/methodA?x=1", "1700000000", nonce, body, signature))
+	assert.False(t, Verify([]byte("secret"), "POST", "/ServiceA/methodB?x=1", "1700000000", nonce, body, signature))
+	assert.False(t, Verify([]byte("secret"), "POST", "/ServiceA/methodA?x=1", "1700000000", nonce, []byte(`{}`), signature))
+}
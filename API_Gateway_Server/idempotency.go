@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// defaultIdempotencyTTL is the time the responses to idempotency keys are kept when it is not configured,
+// and defaultIdempotencyLease the time a call in progress holds its key.
+const (
+	defaultIdempotencyTTL   = 24 * time.Hour
+	defaultIdempotencyLease = time.Minute
+)
+
+// idempotencyKeyHeader carries the key under which a client retries a call, and maxIdempotencyKeyBytes bounds it.
+const idempotencyKeyHeader = "Idempotency-Key"
+const maxIdempotencyKeyBytes = 255
+
+// replayedHeaders are the response headers stored with the response to an idempotency key and replayed with it.
+var replayedHeaders = []string{"ETag", "Location", "Preference-Applied"}
+
+// idempotencyConfig honours the Idempotency-Key header on the methods matching Routes, keeping the response to
+// the first call with a key for TTL. A call in progress holds its key for Lease, so that the key of a call that
+// never completes may be retried once the lease expires; it should exceed the time the calls may take.
+type idempotencyConfig struct {
+	Routes []string `json:"routes"`
+	TTL    duration `json:"ttl"`
+	Lease  duration `json:"lease"`
+}
+
+// idempotencyRecord is the call made with an idempotency key: the fingerprint of its body and, once it is done,
+// its response.
+type idempotencyRecord struct {
+	Fingerprint string            `json:"fingerprint"`
+	Done        bool              `json:"done"`
+	Status      int               `json:"status,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+}
+
+// idempotentRoute reports whether method of serviceName honours idempotency keys.
+func idempotentRoute(serviceName, method string) bool {
+	for _, pattern := range config.Idempotency.Routes {
+		if matchRoute(pattern, serviceName, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyFingerprint returns the digest of the canonical JSON body, or of the body itself if it is not a JSON object,
+// so that equivalent bodies have the same fingerprint.
+func bodyFingerprint(body []byte) string {
+	if canonical, err := canonicalBody(body, nil); err == nil {
+		body = canonical
+	}
+	digest := sha256.Sum256(body)
+	return hex.EncodeToString(digest[:])
+}
+
+// idempotencyStoreKey returns the key of the record of the idempotency key in sharedStore,
+// scoped to the caller of ctx and to method of serviceName.
+func idempotencyStoreKey(ctx *app.RequestContext, serviceName, method, key string) string {
+	subject, _, _ := identity(ctx)
+	digest := sha256.Sum256([]byte(subject + "\n" + key))
+	return "idempotency/" + serviceName + "/" + method + "/" + hex.EncodeToString(digest[:])
+}
+
+// replayResponse answers ctx with the response stored in record.
+func replayResponse(ctx *app.RequestContext, record idempotencyRecord) {
+	ctx.SetStatusCode(record.Status)
+	ctx.Response.Header.SetContentType(record.ContentType)
+	for k, v := range record.Headers {
+		ctx.Header(k, v)
+	}
+	ctx.Response.SetBody(record.Body)
+	ctx.Header("Idempotent-Replayed", "true")
+}
+
+// idempotent is the middleware honouring the Idempotency-Key header on the idempotent methods, so that a client may
+// retry a call without making it twice. The response to the first call with a key is kept in sharedStore
+// and replayed to the calls with the same key, caller and method, and an equivalent body.
+// A call with the key of another body is rejected with 422, and a call with the key of a call still in progress
+// with 409 until its lease expires. Responses meaning the call was not made, 429 and 503, are not kept.
+// Calls are passed on without idempotency if the store fails.
+func idempotent(c context.Context, ctx *app.RequestContext) {
+	serviceName, method := routeOf(ctx)
+	key := string(ctx.GetHeader(idempotencyKeyHeader))
+	if key == "" || !idempotentRoute(serviceName, method) {
+		ctx.Next(c)
+		return
+	}
+	if len(key) > maxIdempotencyKeyBytes {
+		ctx.AbortWithMsg("Invalid Idempotency-Key", http.StatusBadRequest)
+		setErrorClass(ctx, "invalid_request")
+		return
+	}
+
+	ttl := time.Duration(config.Idempotency.TTL)
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	lease := time.Duration(config.Idempotency.Lease)
+	if lease <= 0 {
+		lease = defaultIdempotencyLease
+	}
+	if lease > ttl {
+		lease = ttl
+	}
+	storeKey := idempotencyStoreKey(ctx, serviceName, method, key)
+	fingerprint := bodyFingerprint(ctx.Request.Body())
+	pending, _ := json.Marshal(idempotencyRecord{Fingerprint: fingerprint})
+
+	first, err := sharedStore.CompareAndSwap(c, storeKey, nil, pending, lease)
+	if err != nil {
+		ctx.Next(c)
+		return
+	}
+	if !first {
+		var record idempotencyRecord
+		data, ok, err := sharedStore.Get(c, storeKey)
+		if err != nil {
+			ctx.Next(c)
+			return
+		}
+		if ok && json.Unmarshal(data, &record) == nil && record.Fingerprint != fingerprint {
+			ctx.AbortWithMsg("Idempotency-Key already used with a different request", http.StatusUnprocessableEntity)
+			setErrorClass(ctx, "idempotency_mismatch")
+			return
+		}
+		if !record.Done {
+			ctx.AbortWithMsg("A request with this Idempotency-Key is in progress", http.StatusConflict)
+			setErrorClass(ctx, "idempotency_conflict")
+			return
+		}
+		replayResponse(ctx, record)
+		ctx.Abort()
+		return
+	}
+
+	ctx.Next(c)
+
+	status := ctx.Response.StatusCode()
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		_ = sharedStore.Delete(c, storeKey)
+		return
+	}
+	record := idempotencyRecord{
+		Fingerprint: fingerprint,
+		Done:        true,
+		Status:      status,
+		ContentType: string(ctx.Response.Header.ContentType()),
+		Headers:     map[string]string{},
+		Body:        append([]byte(nil), ctx.Response.Body()...),
+	}
+	for _, h := range replayedHeaders {
+		if v := ctx.Response.Header.Peek(h); len(v) > 0 {
+			record.Headers[h] = string(v)
+		}
+	}
+	done, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	// The response is kept for the TTL, even if the lease of the call expired meanwhile and no retry took the key.
+	swapped, err := sharedStore.CompareAndSwap(c, storeKey, pending, done, ttl)
+	if err == nil && !swapped {
+		_, _ = sharedStore.CompareAndSwap(c, storeKey, nil, done, ttl)
+	}
+}
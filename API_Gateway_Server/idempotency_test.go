@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/stretchr/testify/assert"
+)
+
+// useIdempotency honours idempotency keys on the routes matching routes of ServiceA and ServiceB,
+// whose calls are answered with respond.
+func useIdempotency(t *testing.T, cfg idempotencyConfig, respond func(c context.Context, method, request string) (string, error)) {
+	useFakeBackend(t, respond)
+	config.Idempotency = cfg
+	previous := sharedStore
+	sharedStore = newMemoryKV()
+	t.Cleanup(func() {
+		sharedStore = previous
+	})
+}
+
+// idempotentCall sends the call to ServiceA/methodA with the idempotency key and body, made by owner,
+// through idempotent and then handler.
+func idempotentCall(key, owner, body string, handler app.HandlerFunc) *app.RequestContext {
+	ctx := app.NewContext(0)
+	ctx.Request.Header.SetMethod(http.MethodPost)
+	ctx.Request.SetRequestURI("/ServiceA/methodA")
+	ctx.Request.SetHeader(idempotencyKeyHeader, key)
+	ctx.Request.SetBodyString(body)
+	if owner != "" {
+		ctx.Set(apiKeyOwnerKey, owner)
+	}
+	ctx.SetHandlers(app.HandlersChain{idempotent, handler})
+	ctx.Next(context.Background())
+	return ctx
+}
+
+func TestIdempotent_ReplaysResponse(t *testing.T) {
+	var calls int32
+	useIdempotency(t, idempotencyConfig{Routes: []string{"ServiceA/*"}}, func(context.Context, string, string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return `{"message": "created"}`, nil
+	})
+
+	ctx := gatewayCall("/ServiceA/methodA", `{"userId": "1", "message": "hi"}`, map[string]string{idempotencyKeyHeader: "k1"})
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.Empty(t, ctx.Response.Header.Peek("Idempotent-Replayed"))
+
+	ctx = gatewayCall("/ServiceA/methodA", `{"message": "hi",  "userId": "1"}`, map[string]string{idempotencyKeyHeader: "k1"})
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "true", string(ctx.Response.Header.Peek("Idempotent-Replayed")))
+	assert.JSONEq(t, `{"message": "created"}`, string(ctx.Response.Body()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "the replay is not sent to the backend")
+
+	gatewayCall("/ServiceA/methodA", `{"userId": "1", "message": "hi"}`, map[string]string{idempotencyKeyHeader: "k2"})
+	gatewayCall("/ServiceA/methodA", `{"userId": "1", "message": "hi"}`, nil)
+	gatewayCall("/ServiceB/methodA", `{"userId": "1", "message": "hi"}`, map[string]string{idempotencyKeyHeader: "k1"})
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls), "other keys, calls without key and other routes are made")
+
+	ctx = gatewayCall("/ServiceA/methodA", `{"userId": "2"}`, map[string]string{idempotencyKeyHeader: "k1"})
+	assert.Equal(t, http.StatusUnprocessableEntity, ctx.Response.StatusCode())
+}
+
+func TestIdempotent_ReplaysAsyncJob(t *testing.T) {
+	useAsyncCalls(t, asyncConfig{Routes: []string{"ServiceA/*"}}, func(context.Context, string, string) (string, error) {
+		return `{"message": "done"}`, nil
+	})
+	config.Idempotency = idempotencyConfig{Routes: []string{"ServiceA/*"}}
+
+	headers := map[string]string{"Prefer": "respond-async", idempotencyKeyHeader: "k1"}
+	first := gatewayCall("/ServiceA/methodA", `{}`, headers)
+	assert.Equal(t, http.StatusAccepted, first.Response.StatusCode())
+	replay := gatewayCall("/ServiceA/methodA", `{}`, headers)
+	assert.Equal(t, http.StatusAccepted, replay.Response.StatusCode())
+	assert.Equal(t, string(first.Response.Header.Peek("Location")), string(replay.Response.Header.Peek("Location")))
+	assert.Equal(t, string(first.Response.Body()), string(replay.Response.Body()))
+
+	var accepted job
+	assert.NoError(t, json.Unmarshal(first.Response.Body(), &accepted))
+	assert.Equal(t, jobSucceeded, completedJob(t, accepted.ID).Status)
+}
+
+func TestIdempotent_InProgressAndUnmadeCalls(t *testing.T) {
+	useIdempotency(t, idempotencyConfig{Routes: []string{"ServiceA/*"}}, nil)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan *app.RequestContext)
+	go func() {
+		done <- idempotentCall("k1", "", `{}`, func(c context.Context, ctx *app.RequestContext) {
+			close(started)
+			<-release
+			ctx.String(http.StatusOK, "made")
+		})
+	}()
+	<-started
+	ctx := idempotentCall("k1", "", `{}`, func(c context.Context, ctx *app.RequestContext) {
+		t.Error("the call in progress is not made twice")
+	})
+	assert.Equal(t, http.StatusConflict, ctx.Response.StatusCode())
+	close(release)
+	assert.Equal(t, http.StatusOK, (<-done).Response.StatusCode())
+
+	shed := func(c context.Context, ctx *app.RequestContext) {
+		ctx.String(http.StatusServiceUnavailable, "shed")
+	}
+	made := func(c context.Context, ctx *app.RequestContext) {
+		ctx.String(http.StatusOK, "made")
+	}
+	idempotentCall("k2", "", `{}`, shed)
+	ctx = idempotentCall("k2", "", `{}`, made)
+	assert.Equal(t, "made", string(ctx.Response.Body()), "a call that was not made may be retried")
+}
+
+func TestIdempotent_ScopedAndExpiring(t *testing.T) {
+	useIdempotency(t, idempotencyConfig{Routes: []string{"ServiceA/*"}, TTL: duration(20 * time.Millisecond)}, nil)
+	var calls int
+	made := func(c context.Context, ctx *app.RequestContext) {
+		calls++
+		ctx.String(http.StatusOK, "made")
+	}
+
+	idempotentCall("k1", "alice", `{}`, made)
+	idempotentCall("k1", "alice", `{}`, made)
+	assert.Equal(t, 1, calls)
+	idempotentCall("k1", "bob", `{}`, made)
+	assert.Equal(t, 2, calls, "keys are scoped to the caller")
+
+	time.Sleep(30 * time.Millisecond)
+	idempotentCall("k1", "alice", `{}`, made)
+	assert.Equal(t, 3, calls, "records expire")
+
+	ctx := idempotentCall(string(make([]byte, maxIdempotencyKeyBytes+1)), "", `{}`, made)
+	assert.Equal(t, http.StatusBadRequest, ctx.Response.StatusCode())
+}
+
+func TestIdempotent_PendingKeyLeased(t *testing.T) {
+	useIdempotency(t, idempotencyConfig{Routes: []string{"ServiceA/*"}, Lease: duration(20 * time.Millisecond)}, nil)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan *app.RequestContext)
+	go func() {
+		done <- idempotentCall("k1", "", `{}`, func(c context.Context, ctx *app.RequestContext) {
+			close(started)
+			<-release
+			ctx.String(http.StatusOK, "first")
+		})
+	}()
+	<-started
+	time.Sleep(30 * time.Millisecond)
+	ctx := idempotentCall("k2", "", `{}`, func(c context.Context, ctx *app.RequestContext) {
+		ctx.String(http.StatusOK, "made")
+	})
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	close(release)
+	<-done
+
+	ctx = idempotentCall("k1", "", `{}`, func(c context.Context, ctx *app.RequestContext) {
+		t.Error("the response outliving the lease is kept")
+	})
+	assert.Equal(t, "first", string(ctx.Response.Body()))
+
+	started, release = make(chan struct{}), make(chan struct{})
+	go func() {
+		done <- idempotentCall("k3", "", `{}`, func(c context.Context, ctx *app.RequestContext) {
+			close(started)
+			<-release
+			ctx.String(http.StatusOK, "stuck")
+		})
+	}()
+	<-started
+	time.Sleep(30 * time.Millisecond)
+	ctx = idempotentCall("k3", "", `{}`, func(c context.Context, ctx *app.RequestContext) {
+		ctx.String(http.StatusOK, "retried")
+	})
+	assert.Equal(t, "retried", string(ctx.Response.Body()), "the key of a call past its lease may be retried")
+	close(release)
+	<-done
+}
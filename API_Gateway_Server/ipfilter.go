@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+var clientFilter = &ipFilter{}
+
+// ipFilterConfig configures the CIDR allow and deny lists of the routes matching each rule,
+// the first matching rule applying. The client IP is the remote address of the connection,
+// or the last address of X-Forwarded-For that is not one of TrustedProxies when the connection
+// comes from a trusted proxy.
+type ipFilterConfig struct {
+	TrustedProxies []string `json:"trustedProxies"`
+	Rules          []ipRule `json:"rules"`
+}
+
+// ipRule denies the clients in one of Deny, then, when Allow is not empty, the clients not in one of Allow.
+// Both hold CIDRs such as "10.0.0.0/8", or single IP addresses.
+type ipRule struct {
+	Route string   `json:"route"`
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// ipFilter is an ipFilterConfig with its networks parsed.
+type ipFilter struct {
+	trusted []*net.IPNet
+	rules   []parsedIPRule
+}
+
+type parsedIPRule struct {
+	route string
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// parseNets parses CIDRs or single IP addresses.
+// It returns the networks and an error if one of them is invalid.
+func parseNets(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: s}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// containsIP reports whether ip is in one of nets.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// newIPFilter parses the networks of cfg.
+// It returns the filter and an error if a network is invalid.
+func newIPFilter(cfg ipFilterConfig) (*ipFilter, error) {
+	trusted, err := parseNets(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	f := &ipFilter{trusted: trusted}
+	for _, rule := range cfg.Rules {
+		allow, err := parseNets(rule.Allow)
+		if err != nil {
+			return nil, err
+		}
+		deny, err := parseNets(rule.Deny)
+		if err != nil {
+			return nil, err
+		}
+		f.rules = append(f.rules, parsedIPRule{route: rule.Route, allow: allow, deny: deny})
+	}
+	return f, nil
+}
+
+// clientIP returns the IP address of the client of ctx, following X-Forwarded-For through the trusted proxies.
+// It is installed as the ClientIP function of the server so that every policy sees the same client IP.
+func clientIP(ctx *app.RequestContext) string {
+	remote := ctx.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+
+	ip := net.ParseIP(remote)
+	if ip == nil || !containsIP(clientFilter.trusted, ip) {
+		return remote
+	}
+
+	hops := strings.Split(string(ctx.GetHeader("X-Forwarded-For")), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !containsIP(clientFilter.trusted, hop) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+// filterIP is the middleware rejecting with 403 the clients denied by the IP rule matching the requested route.
+func filterIP(c context.Context, ctx *app.RequestContext) {
+	serviceName, method := routeOf(ctx)
+
+	for _, rule := range clientFilter.rules {
+		if !matchRoute(rule.route, serviceName, method) {
+			continue
+		}
+		ip := net.ParseIP(clientIP(ctx))
+		denied := ip == nil || containsIP(rule.deny, ip) || len(rule.allow) > 0 && !containsIP(rule.allow, ip)
+		if denied {
+			ctx.AbortWithMsg("Forbidden, client IP not allowed", http.StatusForbidden)
+			setErrorClass(ctx, "ip_denied")
+			return
+		}
+		break
+	}
+	ctx.Next(c)
+}
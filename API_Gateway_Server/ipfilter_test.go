@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/test/mock"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+// remoteConn is a connection from a given remote address.
+type remoteConn struct {
+	*mock.Conn
+	addr net.Addr
+}
+
+func (c remoteConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func newIPContext(path, remote, forwardedFor string) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, path, nil),
+	}
+	ctx.SetConn(remoteConn{Conn: mock.NewConn(""), addr: &net.TCPAddr{IP: net.ParseIP(remote), Port: 40000}})
+	if forwardedFor != "" {
+		ctx.Request.SetHeader("X-Forwarded-For", forwardedFor)
+	}
+	return ctx
+}
+
+func useIPFilter(t *testing.T, cfg ipFilterConfig) {
+	var err error
+	clientFilter, err = newIPFilter(cfg)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		clientFilter = &ipFilter{}
+	})
+}
+
+func TestClientIP_HonoursTrustedProxiesOnly(t *testing.T) {
+	useIPFilter(t, ipFilterConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+
+	assert.Equal(t, "203.0.113.7", clientIP(newIPContext("/", "10.0.0.1", "203.0.113.7")))
+	assert.Equal(t, "203.0.113.7", clientIP(newIPContext("/", "10.0.0.1", "198.51.100.1, 203.0.113.7, 10.0.0.2")))
+	assert.Equal(t, "192.0.2.1", clientIP(newIPContext("/", "192.0.2.1", "203.0.113.7")))
+	assert.Equal(t, "10.0.0.1", clientIP(newIPContext("/", "10.0.0.1", "")))
+}
+
+func TestFilterIP_AllowAndDeny(t *testing.T) {
+	useIPFilter(t, ipFilterConfig{
+		TrustedProxies: []string{"10.0.0.1"},
+		Rules: []ipRule{
+			{Route: "ServiceA/methodC", Allow: []string{"192.0.2.0/24"}, Deny: []string{"192.0.2.66"}},
+			{Route: "*/*", Deny: []string{"198.51.100.0/24"}},
+		},
+	})
+
+	for _, tc := range []struct {
+		path, remote, forwardedFor string
+		allowed                    bool
+	}{
+		{"/ServiceA/methodC", "192.0.2.1", "", true},
+		{"/ServiceA/methodC", "192.0.2.66", "", false},
+		{"/ServiceA/methodC", "203.0.113.7", "", false},
+		{"/ServiceA/methodC", "10.0.0.1", "192.0.2.1", true},
+		{"/ServiceA/methodC", "203.0.113.7", "192.0.2.1", false},
+		{"/ServiceA/methodA", "203.0.113.7", "", true},
+		{"/ServiceA/methodA", "198.51.100.9", "", false},
+	} {
+		ctx := newIPContext(tc.path, tc.remote, tc.forwardedFor)
+		filterIP(context.Background(), ctx)
+		assert.Equal(t, !tc.allowed, ctx.IsAborted(), tc)
+		if !tc.allowed {
+			assert.Equal(t, http.StatusForbidden, ctx.Response.StatusCode())
+		}
+	}
+}
+
+func TestNewIPFilter_RejectsInvalidNetworks(t *testing.T) {
+	_, err := newIPFilter(ipFilterConfig{Rules: []ipRule{{Allow: []string{"not an ip"}}}})
+	assert.Error(t, err)
+	_, err = newIPFilter(ipFilterConfig{TrustedProxies: []string{"10.0.0.0/33"}})
+	assert.Error(t, err)
+}
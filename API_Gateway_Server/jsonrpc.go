@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/cloudwego/kitex/pkg/kerrors"
+	"github.com/cloudwego/kitex/pkg/remote"
+)
+
+// jsonRPCVersion is the version of JSON-RPC supported by the /jsonrpc endpoint.
+const jsonRPCVersion = "2.0"
+
+// The error codes of the JSON-RPC 2.0 specification, then those defined by the gateway in the range reserved for servers.
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+	jsonRPCInternalError  = -32603
+
+	jsonRPCBackendError       = -32000
+	jsonRPCTimeout            = -32001
+	jsonRPCUnavailable        = -32002
+	jsonRPCUnauthorized       = -32003
+	jsonRPCForbidden          = -32004
+	jsonRPCTooManyRequests    = -32005
+	jsonRPCPreconditionFailed = -32006
+)
+
+// jsonRPCRequest is a JSON-RPC request, or a notification when it has no ID.
+type jsonRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// jsonRPCResponse is the response to a JSON-RPC request, holding either its result or its error.
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// jsonRPCError is the error object of a failed JSON-RPC request.
+type jsonRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// jsonRPCCallError is the data of the error of a JSON-RPC request whose call failed,
+// the HTTP status and message answering the call.
+type jsonRPCCallError struct {
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// validID reports whether id is a valid JSON-RPC request ID, a string, a number or null.
+func validID(id json.RawMessage) bool {
+	var v interface{}
+	if json.Unmarshal(id, &v) != nil {
+		return false
+	}
+	switch v.(type) {
+	case string, float64, nil:
+		return true
+	}
+	return false
+}
+
+// callErrorCode returns the JSON-RPC error code and message of the error of a Kitex generic call.
+func callErrorCode(err error) (int, string) {
+	var transErr *remote.TransError
+	switch {
+	case isPreconditionFailed(err):
+		return jsonRPCPreconditionFailed, "Precondition failed"
+	case errors.As(err, &transErr) && transErr.TypeID() == remote.UnknownMethod,
+		strings.Contains(err.Error(), "missing method"):
+		return jsonRPCMethodNotFound, "Method not found"
+	case errors.Is(err, kerrors.ErrRPCTimeout):
+		return jsonRPCTimeout, "Timeout"
+	case errors.Is(err, kerrors.ErrServiceDiscovery), errors.Is(err, kerrors.ErrGetConnection),
+		errors.Is(err, kerrors.ErrLoadbalance), errors.Is(err, kerrors.ErrNoMoreInstance),
+		errors.Is(err, kerrors.ErrCircuitBreak), errors.Is(err, kerrors.ErrOverlimit):
+		return jsonRPCUnavailable, "Service unavailable"
+	case errors.Is(err, kerrors.ErrACL):
+		return jsonRPCForbidden, "Forbidden"
+	case errors.Is(err, kerrors.ErrInternalException), errors.Is(err, kerrors.ErrPanic):
+		return jsonRPCInternalError, "Internal error"
+	}
+	return jsonRPCBackendError, "Backend error"
+}
+
+// statusErrorCode returns the JSON-RPC error code and message of a call answered with status and body by the gateway.
+func statusErrorCode(status int, body string) (int, string) {
+	switch status {
+	case http.StatusBadRequest:
+		if strings.HasPrefix(body, "Invalid service name") {
+			return jsonRPCMethodNotFound, "Method not found"
+		}
+		return jsonRPCInvalidParams, "Invalid params"
+	case http.StatusUnauthorized:
+		return jsonRPCUnauthorized, "Unauthorized"
+	case http.StatusForbidden:
+		return jsonRPCForbidden, "Forbidden"
+	case http.StatusTooManyRequests:
+		return jsonRPCTooManyRequests, "Too many requests"
+	case http.StatusPreconditionFailed:
+		return jsonRPCPreconditionFailed, "Precondition failed"
+	case http.StatusServiceUnavailable:
+		return jsonRPCUnavailable, "Service unavailable"
+	case http.StatusGatewayTimeout:
+		return jsonRPCTimeout, "Timeout"
+	}
+	return jsonRPCInternalError, "Internal error"
+}
+
+// callResponse returns the response to a JSON-RPC request whose call was answered in call.
+// Generic call failures are mapped from their Kitex error, the other failures from their HTTP status.
+func callResponse(call *app.RequestContext) jsonRPCResponse {
+	status := call.Response.StatusCode()
+	body := call.Response.Body()
+	if status == consts.StatusOK && json.Valid(body) {
+		return jsonRPCResponse{Result: append(json.RawMessage(nil), body...)}
+	}
+
+	var code int
+	var message string
+	if err, ok := call.Value(callErrorKey).(error); ok {
+		code, message = callErrorCode(err)
+	} else {
+		code, message = statusErrorCode(status, string(body))
+	}
+	return jsonRPCResponse{Error: &jsonRPCError{
+		Code:    code,
+		Message: message,
+		Data:    jsonRPCCallError{Status: status, Detail: string(body)},
+	}}
+}
+
+// serveJSONRPC answers the JSON-RPC request raw, made on behalf of origin, and reports whether it expects a response.
+func serveJSONRPC(c context.Context, origin *callOrigin, suffix string, raw json.RawMessage) (jsonRPCResponse, bool) {
+	var req jsonRPCRequest
+	if json.Unmarshal(raw, &req) != nil || req.JSONRPC != jsonRPCVersion || req.Method == "" || (req.ID != nil && !validID(req.ID)) {
+		return jsonRPCResponse{Error: &jsonRPCError{Code: jsonRPCInvalidRequest, Message: "Invalid Request"}}, true
+	}
+	notification := req.ID == nil
+
+	serviceName, method, ok := strings.Cut(req.Method, ".")
+	if !ok || serviceName == "" || method == "" || strings.Contains(method, "/") || strings.HasPrefix(serviceName, "_") {
+		return jsonRPCResponse{ID: req.ID, Error: &jsonRPCError{Code: jsonRPCMethodNotFound, Message: "Method not found"}}, !notification
+	}
+
+	params := bytes.TrimSpace(req.Params)
+	if len(params) == 0 {
+		params = []byte("{}")
+	}
+	if params[0] != '{' {
+		return jsonRPCResponse{ID: req.ID, Error: &jsonRPCError{
+			Code:    jsonRPCInvalidParams,
+			Message: "Invalid params",
+			Data:    "params must be the request struct, an object",
+		}}, !notification
+	}
+
+	resp := callResponse(origin.dispatch(c, suffix, serviceName, method, params))
+	resp.ID = req.ID
+	return resp, !notification
+}
+
+// jsonRPC handles the JSON-RPC 2.0 requests, whose method names the service and method to call as "Service.method"
+// and whose params are the request struct. Batches of requests are answered concurrently, within the limits of
+// the batch endpoint. The calls go through the gateway policies like those of a batch request. Notifications,
+// requests without ID, are made but not answered, and a request made only of notifications is answered with 204.
+func jsonRPC(c context.Context, ctx *app.RequestContext) {
+	body := bytes.TrimSpace(ctx.Request.Body())
+	if !json.Valid(body) {
+		setErrorClass(ctx, "invalid_request")
+		ctx.JSON(consts.StatusOK, jsonRPCResponse{
+			JSONRPC: jsonRPCVersion,
+			Error:   &jsonRPCError{Code: jsonRPCParseError, Message: "Parse error"},
+		})
+		return
+	}
+	origin := newCallOrigin(c, ctx)
+
+	if body[0] != '[' {
+		resp, answer := serveJSONRPC(c, origin, "0", body)
+		if !answer {
+			ctx.SetStatusCode(http.StatusNoContent)
+			return
+		}
+		resp.JSONRPC = jsonRPCVersion
+		ctx.JSON(consts.StatusOK, resp)
+		return
+	}
+
+	var requests []json.RawMessage
+	_ = json.Unmarshal(body, &requests)
+	maxItems, concurrency := batchLimits()
+	if len(requests) == 0 || len(requests) > maxItems {
+		ctx.JSON(consts.StatusOK, jsonRPCResponse{
+			JSONRPC: jsonRPCVersion,
+			Error: &jsonRPCError{
+				Code:    jsonRPCInvalidRequest,
+				Message: "Invalid Request",
+				Data:    "a batch holds from 1 to " + strconv.Itoa(maxItems) + " requests",
+			},
+		})
+		return
+	}
+
+	replies := make([]jsonRPCResponse, len(requests))
+	answers := make([]bool, len(requests))
+	forEachConcurrently(len(requests), concurrency, func(i int) {
+		replies[i], answers[i] = serveJSONRPC(c, origin, strconv.Itoa(i), requests[i])
+	})
+
+	answered := make([]jsonRPCResponse, 0, len(replies))
+	for i, resp := range replies {
+		if answers[i] {
+			resp.JSONRPC = jsonRPCVersion
+			answered = append(answered, resp)
+		}
+	}
+	if len(answered) == 0 {
+		ctx.SetStatusCode(http.StatusNoContent)
+		return
+	}
+	ctx.JSON(consts.StatusOK, answered)
+}
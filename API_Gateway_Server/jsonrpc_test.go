@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/cloudwego/kitex/pkg/kerrors"
+	"github.com/cloudwego/kitex/pkg/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+func useJSONRPCBackend(t *testing.T) *int32 {
+	var calls int32
+	useFakeBackend(t, func(_ context.Context, method, _ string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		switch method {
+		case "methodB":
+			return "", kerrors.ErrRPCTimeout.WithCause(errors.New("1s"))
+		case "methodC":
+			return "", remote.NewTransError(remote.UnknownMethod, errors.New("unknown method methodC"))
+		case "methodD":
+			return "", kerrors.NewBizStatusError(preconditionFailedStatus, "precondition failed")
+		}
+		return `{"message": "hi"}`, nil
+	})
+	return &calls
+}
+
+func jsonRPCCall(body string) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, "/jsonrpc", nil),
+	}
+	ctx.Request.SetHeader("Content-Type", "application/json")
+	ctx.Request.SetBodyString(body)
+	jsonRPC(context.Background(), ctx)
+	return ctx
+}
+
+func TestJSONRPC_Request(t *testing.T) {
+	useJSONRPCBackend(t)
+
+	ctx := jsonRPCCall(`{"jsonrpc": "2.0", "method": "ServiceA.methodA", "params": {"message": "hi"}, "id": 1}`)
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `{"jsonrpc": "2.0", "result": {"message": "hi"}, "id": 1}`, string(ctx.Response.Body()))
+
+	ctx = jsonRPCCall(`{"jsonrpc": "2.0", "method": "ServiceA.methodB", "params": {}, "id": "b"}`)
+	assert.JSONEq(t, `{"jsonrpc": "2.0", "id": "b", "error": {
+		"code": -32001, "message": "Timeout", "data": {"status": 500, "detail": "Error making generic call"}
+	}}`, string(ctx.Response.Body()))
+
+	ctx = jsonRPCCall(`{"jsonrpc": "2.0", "method": "ServiceA.methodC", "id": null}`)
+	assert.JSONEq(t, `{"jsonrpc": "2.0", "id": null, "error": {
+		"code": -32601, "message": "Method not found", "data": {"status": 500, "detail": "Error making generic call"}
+	}}`, string(ctx.Response.Body()))
+
+	ctx = jsonRPCCall(`{"jsonrpc": "2.0", "method": "ServiceA.methodD", "id": 5}`)
+	assert.JSONEq(t, `{"jsonrpc": "2.0", "id": 5, "error": {
+		"code": -32006, "message": "Precondition failed", "data": {"status": 412, "detail": "Precondition failed"}
+	}}`, string(ctx.Response.Body()))
+
+	ctx = jsonRPCCall(`{"jsonrpc": "2.0", "method": "ServiceC.methodA", "id": 2}`)
+	assert.Contains(t, string(ctx.Response.Body()), `"code":-32601`)
+
+	ctx = jsonRPCCall(`{"jsonrpc": "2.0", "method": "ServiceA.methodA", "params": ["hi"], "id": 3}`)
+	assert.Contains(t, string(ctx.Response.Body()), `"code":-32602`)
+
+	ctx = jsonRPCCall(`{"jsonrpc": "2.0", "method": "ServiceA.methodA"`)
+	assert.JSONEq(t, `{"jsonrpc": "2.0", "id": null, "error": {"code": -32700, "message": "Parse error"}}`, string(ctx.Response.Body()))
+
+	ctx = jsonRPCCall(`{"method": "ServiceA.methodA", "id": 4}`)
+	assert.JSONEq(t, `{"jsonrpc": "2.0", "id": null, "error": {"code": -32600, "message": "Invalid Request"}}`, string(ctx.Response.Body()))
+}
+
+func TestJSONRPC_NotificationsAndBatches(t *testing.T) {
+	calls := useJSONRPCBackend(t)
+
+	ctx := jsonRPCCall(`{"jsonrpc": "2.0", "method": "ServiceA.methodA", "params": {"message": "hi"}}`)
+	assert.Equal(t, http.StatusNoContent, ctx.Response.StatusCode())
+	assert.Empty(t, ctx.Response.Body())
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls), "notifications are made")
+
+	ctx = jsonRPCCall(`[
+		{"jsonrpc": "2.0", "method": "ServiceA.methodA", "params": {"message": "hi"}, "id": 1},
+		{"jsonrpc": "2.0", "method": "ServiceB.methodA", "params": {"message": "hi"}},
+		1,
+		{"jsonrpc": "2.0", "method": "ServiceB.methodB", "id": 2}
+	]`)
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.JSONEq(t, `[
+		{"jsonrpc": "2.0", "result": {"message": "hi"}, "id": 1},
+		{"jsonrpc": "2.0", "id": null, "error": {"code": -32600, "message": "Invalid Request"}},
+		{"jsonrpc": "2.0", "id": 2, "error": {"code": -32001, "message": "Timeout", "data": {"status": 500, "detail": "Error making generic call"}}}
+	]`, string(ctx.Response.Body()))
+	assert.Equal(t, int32(4), atomic.LoadInt32(calls))
+
+	ctx = jsonRPCCall(`[{"jsonrpc": "2.0", "method": "ServiceA.methodA"}]`)
+	assert.Equal(t, http.StatusNoContent, ctx.Response.StatusCode())
+
+	ctx = jsonRPCCall(`[]`)
+	assert.Contains(t, string(ctx.Response.Body()), `"code":-32600`)
+}
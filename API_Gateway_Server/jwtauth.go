@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/sync/singleflight"
+)
+
+// claimsKey is the key of the verified JWT claims stored in the app.RequestContext of a request.
+const claimsKey = "claims"
+
+// jwksRefetchInterval is the minimum time between two fetches of the JWKS URL for an unknown key ID.
+const jwksRefetchInterval = time.Minute
+
+var defaultJWTAlgorithms = []string{"HS256", "RS256", "ES256"}
+
+var verifier *jwtVerifier
+
+var errUnknownKey = errors.New("no key found to verify token")
+
+// jwtConfig configures the verification of the bearer tokens of the routes matching one of Rules.
+// Tokens are signed with HMACSecret or with a key of the JWKS read from JWKSFile or JWKSURL,
+// using one of Algorithms. They must not be expired and, when set, must carry Issuer and Audience.
+type jwtConfig struct {
+	Issuer     string    `json:"issuer"`
+	Audience   string    `json:"audience"`
+	Algorithms []string  `json:"algorithms"`
+	HMACSecret string    `json:"hmacSecret"`
+	JWKSFile   string    `json:"jwksFile"`
+	JWKSURL    string    `json:"jwksURL"`
+	Leeway     duration  `json:"leeway"`
+	Rules      []jwtRule `json:"rules"`
+}
+
+// jwtRule requires a valid token on the routes matching Route. Audience overrides the
+// audience of jwtConfig. ClaimMetadata maps claims to the Kitex metadata keys they are
+// forwarded under, and ClaimFields maps claims to the request body fields they overwrite.
+type jwtRule struct {
+	Route         string            `json:"route"`
+	Audience      string            `json:"audience"`
+	ClaimMetadata map[string]string `json:"claimMetadata"`
+	ClaimFields   map[string]string `json:"claimFields"`
+}
+
+// jwk is a JSON Web Key as found in a JWKS.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwtVerifier verifies bearer tokens against the keys of a jwtConfig.
+type jwtVerifier struct {
+	cfg       jwtConfig
+	mu        sync.RWMutex
+	keys      map[string]interface{}
+	lastFetch time.Time
+	fetches   singleflight.Group
+}
+
+// newJWTVerifier creates a verifier for cfg, loading its JWKS.
+// It returns an error if the JWKS cannot be read or parsed.
+func newJWTVerifier(cfg jwtConfig) (*jwtVerifier, error) {
+	if len(cfg.Algorithms) == 0 {
+		cfg.Algorithms = defaultJWTAlgorithms
+	}
+	v := &jwtVerifier{cfg: cfg, keys: make(map[string]interface{})}
+
+	var data []byte
+	var err error
+	switch {
+	case cfg.JWKSFile != "":
+		data, err = os.ReadFile(cfg.JWKSFile)
+	case cfg.JWKSURL != "":
+		data, err = fetchJWKS(cfg.JWKSURL)
+		v.lastFetch = time.Now()
+	default:
+		return v, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	v.keys, err = parseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// fetchJWKS downloads the JWKS at url.
+// It returns the JWKS and an error if the request fails.
+func fetchJWKS(url string) ([]byte, error) {
+	cli := http.Client{Timeout: 5 * time.Second}
+	resp, err := cli.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// errUnsupportedKey is the error of the JWKS keys of a type or curve the gateway does not verify tokens with.
+var errUnsupportedKey = errors.New("unsupported key")
+
+// parseJWKS parses the RSA and EC public keys of a JWKS, keyed by key ID. Keys of other types or curves,
+// which identity providers publish alongside, are skipped, and so are malformed keys, which are logged.
+// It returns the keys and an error if data is not a valid JWKS or holds no usable key.
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if errors.Is(err, errUnsupportedKey) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Skipping JWKS key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable key in JWKS")
+	}
+	return keys, nil
+}
+
+// publicKey returns the RSA or ECDSA public key described by k.
+// It returns an error wrapping errUnsupportedKey if the key type or curve is unsupported,
+// and an error if the key is malformed.
+func (k jwk) publicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: curve %q", errUnsupportedKey, k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("%w: key type %q", errUnsupportedKey, k.Kty)
+}
+
+// refetch fetches the JWKS URL again, at most once per jwksRefetchInterval, and swaps in its keys.
+// Concurrent verifications share one fetch, made without holding the keys lock so that the verifications
+// with known keys do not wait for it.
+func (v *jwtVerifier) refetch() {
+	_, _, _ = v.fetches.Do("jwks", func() (interface{}, error) {
+		v.mu.Lock()
+		if time.Since(v.lastFetch) <= jwksRefetchInterval {
+			v.mu.Unlock()
+			return nil, nil
+		}
+		v.lastFetch = time.Now()
+		v.mu.Unlock()
+
+		data, err := fetchJWKS(v.cfg.JWKSURL)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := parseJWKS(data)
+		if err != nil {
+			return nil, err
+		}
+		v.mu.Lock()
+		v.keys = keys
+		v.mu.Unlock()
+		return nil, nil
+	})
+}
+
+// lookupKey returns the key of the JWKS with kid, refetching the JWKS URL
+// at most once per jwksRefetchInterval when kid is unknown.
+// A token without kid is verified with the only key of the JWKS matching its algorithm.
+func (v *jwtVerifier) lookupKey(kid string, matches func(interface{}) bool) (interface{}, bool) {
+	v.mu.RLock()
+	key, ok := v.keys[kid]
+	stale := v.cfg.JWKSURL != "" && time.Since(v.lastFetch) > jwksRefetchInterval
+	v.mu.RUnlock()
+	if ok {
+		return key, matches(key)
+	}
+
+	if kid != "" && stale {
+		v.refetch()
+		v.mu.RLock()
+		key, ok = v.keys[kid]
+		v.mu.RUnlock()
+		return key, ok && matches(key)
+	}
+
+	if kid == "" {
+		v.mu.RLock()
+		defer v.mu.RUnlock()
+		var found interface{}
+		for _, k := range v.keys {
+			if matches(k) {
+				if found != nil {
+					return nil, false
+				}
+				found = k
+			}
+		}
+		return found, found != nil
+	}
+	return nil, false
+}
+
+// keyFunc returns the key verifying token according to its algorithm and key ID.
+func (v *jwtVerifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	var matches func(interface{}) bool
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if v.cfg.HMACSecret == "" {
+			return nil, errUnknownKey
+		}
+		return []byte(v.cfg.HMACSecret), nil
+	case *jwt.SigningMethodRSA:
+		matches = func(k interface{}) bool { _, ok := k.(*rsa.PublicKey); return ok }
+	case *jwt.SigningMethodECDSA:
+		matches = func(k interface{}) bool { _, ok := k.(*ecdsa.PublicKey); return ok }
+	default:
+		return nil, errUnknownKey
+	}
+
+	key, ok := v.lookupKey(kid, matches)
+	if !ok {
+		return nil, errUnknownKey
+	}
+	return key, nil
+}
+
+// verify checks the signature, expiry, issuer and audience of tokenString at now.
+// An empty audience falls back to the audience of the verifier.
+// It returns the claims of the token and an error if the token is invalid.
+func (v *jwtVerifier) verify(tokenString, audience string, now time.Time) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, v.keyFunc,
+		jwt.WithValidMethods(v.cfg.Algorithms),
+		jwt.WithoutClaimsValidation(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if audience == "" {
+		audience = v.cfg.Audience
+	}
+	leeway := time.Duration(v.cfg.Leeway)
+	switch {
+	case !claims.VerifyExpiresAt(now.Add(-leeway).Unix(), true):
+		return nil, errors.New("token is expired or has no expiry")
+	case !claims.VerifyNotBefore(now.Add(leeway).Unix(), false):
+		return nil, errors.New("token is not valid yet")
+	case v.cfg.Issuer != "" && !claims.VerifyIssuer(v.cfg.Issuer, true):
+		return nil, errors.New("token has an invalid issuer")
+	case audience != "" && !claims.VerifyAudience(audience, true):
+		return nil, errors.New("token has an invalid audience")
+	}
+	return claims, nil
+}
+
+// claimString returns the claim name of claims as a string, joining lists with commas.
+// It returns false if the claim is absent.
+func claimString(claims jwt.MapClaims, name string) (string, bool) {
+	switch v := claims[name].(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, p := range v {
+			parts = append(parts, fmt.Sprint(p))
+		}
+		return strings.Join(parts, ","), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// withClaimMetadata returns c carrying the claims selected by mapping in the metadata sent to the backend.
+func withClaimMetadata(c context.Context, claims jwt.MapClaims, mapping map[string]string) context.Context {
+	for claim, key := range mapping {
+		if v, ok := claimString(claims, claim); ok {
+			c = metainfo.WithValue(c, key, v)
+		}
+	}
+	return c
+}
+
+// mapClaimFields overwrites the request body fields selected by mapping with their claims,
+// removing the fields whose claim is absent so that clients cannot set them. The body as received is kept
+// for the signature check. It returns an error if the body is not a JSON object.
+func mapClaimFields(ctx *app.RequestContext, claims jwt.MapClaims, mapping map[string]string) error {
+	if len(mapping) == 0 {
+		return nil
+	}
+
+	body := map[string]interface{}{}
+	if raw := ctx.Request.Body(); len(raw) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		err := decoder.Decode(&body)
+		if err != nil {
+			return err
+		}
+	}
+	for claim, field := range mapping {
+		if v, ok := claimString(claims, claim); ok {
+			body[field] = v
+		} else {
+			delete(body, field)
+		}
+	}
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	keepRawBody(ctx)
+	ctx.Request.SetBody(raw)
+	return nil
+}
+
+// bearerToken returns the token of the Authorization header of ctx, or an empty string if there is none.
+func bearerToken(ctx *app.RequestContext) string {
+	auth := string(ctx.GetHeader("Authorization"))
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(auth[7:])
+}
+
+// rejectUnauthenticated aborts the request in ctx with 401 and msg.
+func rejectUnauthenticated(ctx *app.RequestContext, msg string) {
+	ctx.AbortWithMsg(msg, http.StatusUnauthorized)
+	ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	setErrorClass(ctx, "unauthenticated")
+}
+
+// jwtAuth is the middleware requiring a valid bearer token on the routes of the JWT rules.
+// The claims of the token are stored in ctx and forwarded to the backend as configured.
+func jwtAuth(c context.Context, ctx *app.RequestContext) {
+	serviceName, method := routeOf(ctx)
+
+	var rule *jwtRule
+	for i := range config.JWT.Rules {
+		if matchRoute(config.JWT.Rules[i].Route, serviceName, method) {
+			rule = &config.JWT.Rules[i]
+			break
+		}
+	}
+	if rule == nil || verifier == nil {
+		ctx.Next(c)
+		return
+	}
+
+	token := bearerToken(ctx)
+	if token == "" {
+		rejectUnauthenticated(ctx, "Missing bearer token")
+		return
+	}
+
+	claims, err := verifier.verify(token, rule.Audience, time.Now())
+	if err != nil {
+		rejectUnauthenticated(ctx, "Invalid token")
+		return
+	}
+
+	err = mapClaimFields(ctx, claims, rule.ClaimFields)
+	if err != nil {
+		ctx.AbortWithMsg("Invalid JSON data", http.StatusBadRequest)
+		setErrorClass(ctx, "invalid_request")
+		return
+	}
+
+	ctx.Set(claimsKey, claims)
+	ctx.Next(withClaimMetadata(c, claims, rule.ClaimMetadata))
+}
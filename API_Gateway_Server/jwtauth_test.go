@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSecret = "test secret"
+
+func signHS256(t *testing.T, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	assert.NoError(t, err)
+	return token
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "user-1",
+		"roles": []string{"admin", "reader"},
+		"iss":   "issuer",
+		"aud":   "gateway",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func writeJWKS(t *testing.T, keys ...jwk) string {
+	content, err := json.Marshal(map[string][]jwk{"keys": keys})
+	assert.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(file, content, 0644))
+	return file
+}
+
+func newJWTContext(path, token, body string) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, path, nil),
+	}
+	if token != "" {
+		ctx.Request.SetHeader("Authorization", "Bearer "+token)
+	}
+	ctx.Request.SetBodyString(body)
+	return ctx
+}
+
+func useJWTConfig(t *testing.T, cfg jwtConfig) {
+	config = &gatewayConfig{JWT: cfg}
+	var err error
+	verifier, err = newJWTVerifier(cfg)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		config = &gatewayConfig{}
+		verifier = nil
+	})
+}
+
+func TestJWTVerifier_HS256(t *testing.T) {
+	v, err := newJWTVerifier(jwtConfig{HMACSecret: testSecret, Issuer: "issuer", Audience: "gateway"})
+	assert.NoError(t, err)
+
+	claims, err := v.verify(signHS256(t, validClaims()), "", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", claims["sub"])
+
+	_, err = v.verify(signHS256(t, validClaims()), "other", time.Now())
+	assert.Error(t, err)
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	_, err = v.verify(signHS256(t, expired), "", time.Now())
+	assert.Error(t, err)
+
+	noExpiry := validClaims()
+	delete(noExpiry, "exp")
+	_, err = v.verify(signHS256(t, noExpiry), "", time.Now())
+	assert.Error(t, err)
+}
+
+func TestJWTVerifier_RS256FromJWKSFile(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	file := writeJWKS(t, jwk{
+		Kty: "RSA",
+		Kid: "rsa-1",
+		N:   encodeBigInt(key.N),
+		E:   encodeBigInt(big.NewInt(int64(key.E))),
+	})
+
+	v, err := newJWTVerifier(jwtConfig{JWKSFile: file})
+	assert.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims())
+	token.Header["kid"] = "rsa-1"
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+
+	_, err = v.verify(signed, "", time.Now())
+	assert.NoError(t, err)
+
+	token.Header["kid"] = "unknown"
+	signed, err = token.SignedString(key)
+	assert.NoError(t, err)
+	_, err = v.verify(signed, "", time.Now())
+	assert.Error(t, err)
+
+	_, err = v.verify(signHS256(t, validClaims()), "", time.Now())
+	assert.Error(t, err)
+}
+
+func TestParseJWKS_SkipsUnusableKeys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	rsaKey := jwk{Kty: "RSA", Kid: "rsa-1", N: encodeBigInt(key.N), E: encodeBigInt(big.NewInt(int64(key.E)))}
+	unusable := []jwk{
+		{Kty: "oct", Kid: "hmac-1"},
+		{Kty: "OKP", Kid: "ed-1", Crv: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+		{Kty: "EC", Kid: "ec-1", Crv: "secp256k1"},
+		{Kty: "RSA", Kid: "rsa-2", N: "not base64!"},
+	}
+
+	content, err := json.Marshal(map[string][]jwk{"keys": append(unusable, rsaKey)})
+	assert.NoError(t, err)
+	keys, err := parseJWKS(content)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+	assert.Contains(t, keys, "rsa-1")
+
+	content, err = json.Marshal(map[string][]jwk{"keys": unusable})
+	assert.NoError(t, err)
+	_, err = parseJWKS(content)
+	assert.Error(t, err, "a JWKS without usable key is rejected")
+}
+
+func TestJWTVerifier_RefetchesJWKSWithoutBlocking(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	jwks, err := json.Marshal(map[string][]jwk{"keys": {{
+		Kty: "RSA",
+		Kid: "rsa-1",
+		N:   encodeBigInt(key.N),
+		E:   encodeBigInt(big.NewInt(int64(key.E))),
+	}}})
+	assert.NoError(t, err)
+
+	var fetches int32
+	fetching, release := make(chan struct{}, 1), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			fetching <- struct{}{}
+			<-release
+		}
+		_, _ = w.Write(jwks)
+	}))
+	defer server.Close()
+
+	v, err := newJWTVerifier(jwtConfig{JWKSURL: server.URL})
+	assert.NoError(t, err)
+	v.lastFetch = time.Now().Add(-2 * jwksRefetchInterval)
+	sign := func(kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims())
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		assert.NoError(t, err)
+		return signed
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := v.verify(sign("unknown"), "", time.Now())
+			assert.Error(t, err)
+		}()
+	}
+	<-fetching
+	_, err = v.verify(sign("rsa-1"), "", time.Now())
+	assert.NoError(t, err, "known keys are verified during the fetch")
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches), "the unknown key ID is fetched once")
+}
+
+func TestJWTVerifier_ES256WithoutKid(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	file := writeJWKS(t, jwk{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   encodeBigInt(key.X),
+		Y:   encodeBigInt(key.Y),
+	})
+
+	v, err := newJWTVerifier(jwtConfig{JWKSFile: file})
+	assert.NoError(t, err)
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodES256, validClaims()).SignedString(key)
+	assert.NoError(t, err)
+	_, err = v.verify(signed, "", time.Now())
+	assert.NoError(t, err)
+}
+
+func TestJWTAuth_RejectsMissingAndInvalidToken(t *testing.T) {
+	useJWTConfig(t, jwtConfig{HMACSecret: testSecret, Rules: []jwtRule{{Route: "ServiceA/*"}}})
+
+	ctx := newJWTContext("/ServiceA/methodA", "", "{}")
+	jwtAuth(context.Background(), ctx)
+	assert.True(t, ctx.IsAborted())
+	assert.Equal(t, http.StatusUnauthorized, ctx.Response.StatusCode())
+
+	ctx = newJWTContext("/ServiceA/methodA", "not a token", "{}")
+	jwtAuth(context.Background(), ctx)
+	assert.True(t, ctx.IsAborted())
+	assert.Equal(t, http.StatusUnauthorized, ctx.Response.StatusCode())
+
+	ctx = newJWTContext("/ServiceB/methodA", "", "{}")
+	jwtAuth(context.Background(), ctx)
+	assert.False(t, ctx.IsAborted())
+}
+
+func TestJWTAuth_ForwardsClaims(t *testing.T) {
+	useJWTConfig(t, jwtConfig{HMACSecret: testSecret, Rules: []jwtRule{{
+		Route:         "ServiceA/*",
+		ClaimMetadata: map[string]string{"sub": "user_id", "roles": "roles"},
+		ClaimFields:   map[string]string{"sub": "userId", "tenant": "tenant"},
+	}}})
+
+	body := `{"userId": "spoofed", "tenant": "spoofed", "message": "hi"}`
+	ctx := newJWTContext("/ServiceA/methodA", signHS256(t, validClaims()), body)
+
+	var metadata context.Context
+	ctx.SetHandlers(app.HandlersChain{jwtAuth, func(c context.Context, ctx *app.RequestContext) {
+		metadata = c
+	}})
+	jwtAuth(context.Background(), ctx)
+
+	assert.False(t, ctx.IsAborted())
+	assert.JSONEq(t, `{"userId": "user-1", "message": "hi"}`, string(ctx.Request.Body()))
+
+	_, ok := ctx.Get(claimsKey)
+	assert.True(t, ok)
+	assert.Equal(t, "user-1", (&metainfoCarrier{ctx: metadata}).Get("user_id"))
+	assert.Equal(t, "admin,reader", (&metainfoCarrier{ctx: metadata}).Get("roles"))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// limitsConfig bounds the size of the requests handled by the gateway. A zero limit is not enforced.
+// MaxBodyBytes is also applied by the server while reading the body, so that larger bodies are never buffered.
+type limitsConfig struct {
+	MaxBodyBytes   int `json:"maxBodyBytes"`
+	MaxHeaders     int `json:"maxHeaders"`
+	MaxHeaderBytes int `json:"maxHeaderBytes"`
+	MaxJSONDepth   int `json:"maxJSONDepth"`
+}
+
+// jsonDepth returns the maximum nesting depth of the objects and arrays of the JSON document data,
+// stopping as soon as it exceeds limit.
+func jsonDepth(data []byte, limit int) int {
+	depth, deepest := 0, 0
+	inString, escaped := false, false
+	for _, b := range data {
+		switch {
+		case inString && escaped:
+			escaped = false
+		case inString && b == '\\':
+			escaped = true
+		case inString && b == '"':
+			inString = false
+		case inString:
+		case b == '"':
+			inString = true
+		case b == '{' || b == '[':
+			depth++
+			if depth > deepest {
+				deepest = depth
+				if deepest > limit {
+					return deepest
+				}
+			}
+		case b == '}' || b == ']':
+			depth--
+		}
+	}
+	return deepest
+}
+
+// limitRequest is the middleware rejecting the requests over the configured limits
+// before their body is read by decode: 431 for too many or too large headers,
+// 413 for a too large body and 400 for a too deeply nested JSON body.
+func limitRequest(c context.Context, ctx *app.RequestContext) {
+	limits := config.Limits
+
+	if limits.MaxHeaders > 0 || limits.MaxHeaderBytes > 0 {
+		count, size := 0, 0
+		ctx.Request.Header.VisitAll(func(k, v []byte) {
+			count++
+			size += len(k) + len(v) + len(": \r\n")
+		})
+		if limits.MaxHeaders > 0 && count > limits.MaxHeaders {
+			ctx.AbortWithMsg(fmt.Sprintf("Too many request headers, at most %d allowed", limits.MaxHeaders), http.StatusRequestHeaderFieldsTooLarge)
+			setErrorClass(ctx, "headers_too_large")
+			return
+		}
+		if limits.MaxHeaderBytes > 0 && size > limits.MaxHeaderBytes {
+			ctx.AbortWithMsg(fmt.Sprintf("Request headers too large, at most %d bytes allowed", limits.MaxHeaderBytes), http.StatusRequestHeaderFieldsTooLarge)
+			setErrorClass(ctx, "headers_too_large")
+			return
+		}
+	}
+
+	if limits.MaxBodyBytes > 0 {
+		length := ctx.Request.Header.ContentLength()
+		if length < 0 {
+			length = len(ctx.Request.Body())
+		}
+		if length > limits.MaxBodyBytes {
+			ctx.AbortWithMsg(fmt.Sprintf("Request body too large, at most %d bytes allowed", limits.MaxBodyBytes), http.StatusRequestEntityTooLarge)
+			setErrorClass(ctx, "body_too_large")
+			return
+		}
+	}
+
+	if limits.MaxJSONDepth > 0 && jsonDepth(ctx.Request.Body(), limits.MaxJSONDepth) > limits.MaxJSONDepth {
+		ctx.AbortWithMsg(fmt.Sprintf("JSON nesting too deep, at most %d levels allowed", limits.MaxJSONDepth), http.StatusBadRequest)
+		setErrorClass(ctx, "json_too_deep")
+		return
+	}
+
+	ctx.Next(c)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func useLimits(t *testing.T, limits limitsConfig) {
+	config = &gatewayConfig{Limits: limits}
+	t.Cleanup(func() {
+		config = &gatewayConfig{}
+	})
+}
+
+func newLimitsContext(body string, headers int) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodPost, "/ServiceA/methodA", nil),
+	}
+	ctx.Request.SetBodyString(body)
+	for i := 0; i < headers; i++ {
+		ctx.Request.Header.Add("X-Test", strings.Repeat("a", 10))
+	}
+	return ctx
+}
+
+func TestJSONDepth(t *testing.T) {
+	assert.Equal(t, 0, jsonDepth([]byte(`"text"`), 10))
+	assert.Equal(t, 1, jsonDepth([]byte(`{"a": "{[{["}`), 10))
+	assert.Equal(t, 3, jsonDepth([]byte(`{"a": [{"b": "\"}"}], "c": {}}`), 10))
+	assert.Equal(t, 3, jsonDepth([]byte(`[[[[[[`), 2), "stops once over the limit")
+}
+
+func TestLimitRequest_Statuses(t *testing.T) {
+	useLimits(t, limitsConfig{MaxBodyBytes: 32, MaxHeaders: 5, MaxHeaderBytes: 120, MaxJSONDepth: 2})
+
+	for _, tc := range []struct {
+		name    string
+		body    string
+		headers int
+		status  int
+	}{
+		{"within limits", `{"message": {"text": "hi"}}`, 1, 0},
+		{"too many headers", `{}`, 6, http.StatusRequestHeaderFieldsTooLarge},
+		{"headers too large", `{}`, 5, http.StatusRequestHeaderFieldsTooLarge},
+		{"body too large", `{"message": "` + strings.Repeat("a", 32) + `"}`, 0, http.StatusRequestEntityTooLarge},
+		{"too deeply nested", `{"a": {"b": {"c": 1}}}`, 0, http.StatusBadRequest},
+	} {
+		ctx := newLimitsContext(tc.body, tc.headers)
+		limitRequest(context.Background(), ctx)
+		if tc.status == 0 {
+			assert.False(t, ctx.IsAborted(), tc.name)
+			continue
+		}
+		assert.Equal(t, tc.status, ctx.Response.StatusCode(), tc.name)
+	}
+}
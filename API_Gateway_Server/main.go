@@ -11,19 +11,33 @@ package main
 import (
 	"bufio"
 	"context"
+	"mime"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
+	"time"
+
+	"API_Gateway_Server/hmacsign"
 
 	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/cloudwego/hertz/pkg/app/server"
+	hzconfig "github.com/cloudwego/hertz/pkg/common/config"
+	"github.com/cloudwego/hertz/pkg/network"
+	"github.com/cloudwego/hertz/pkg/network/standard"
+	"github.com/cloudwego/hertz/pkg/protocol"
 	"github.com/cloudwego/hertz/pkg/protocol/consts"
 	"github.com/cloudwego/kitex/client"
 	"github.com/cloudwego/kitex/client/genericclient"
 	"github.com/cloudwego/kitex/pkg/discovery"
 	"github.com/cloudwego/kitex/pkg/generic"
+	"github.com/cloudwego/kitex/pkg/generic/descriptor"
+	"github.com/cloudwego/kitex/pkg/generic/thrift"
 	"github.com/cloudwego/kitex/pkg/loadbalance"
+	"github.com/cloudwego/kitex/pkg/remote/trans/gonet"
+	"github.com/cloudwego/kitex/pkg/transmeta"
 	"github.com/cloudwego/kitex/pkg/utils"
+	"github.com/cloudwego/kitex/transport"
 	"github.com/kitex-contrib/registry-nacos/resolver"
 )
 
@@ -34,10 +48,23 @@ var idlContent = make(map[string]string)
 var serviceIdlMap = make(map[string]string)
 var serviceClientMap = make(map[string]genericclient.Client)
 
-// validateContentType checks if the content type of ctx is valid.
+// callErrorKey is the key of the error of the generic call failing a request in its context.
+const callErrorKey = "callError"
+
+// validateContentType checks if the content type of ctx is valid, application/json with any parameters.
 // It returns true if the content type is invalid, otherwise false.
 func invalidContentType(ctx *app.RequestContext) bool {
-	return string(ctx.ContentType()) != "application/json"
+	return mediaType(ctx) != jsonContentType
+}
+
+// mediaType returns the media type of the content type of ctx, without its parameters,
+// or "" if there is none or it is malformed.
+func mediaType(ctx *app.RequestContext) string {
+	value, _, err := mime.ParseMediaType(string(ctx.ContentType()))
+	if err != nil {
+		return ""
+	}
+	return value
 }
 
 // readPath reads the ctx request path and returns an array
@@ -93,7 +120,8 @@ func resolveService(c context.Context, re discovery.Resolver, serviceName string
 // 	}
 // }
 
-// initIdl initialises the generic call features of the API Gateway based on each file in slice idlFile.
+// initIdl initialises the generic call features of the API Gateway based on each file in slice idlFile,
+// then derives the GraphQL schema and gRPC services from them.
 // It returns an error if any process in between fails.
 func initIdl() error {
 	for _, file := range idlFile {
@@ -102,7 +130,7 @@ func initIdl() error {
 			return err
 		}
 	}
-	return nil
+	return buildSchemas()
 }
 
 // readIdl open and read file, map the content to the file path,
@@ -227,10 +255,25 @@ func genericUtil(file string) (generic.Generic, error) {
 	return gen, nil
 }
 
-// genericClient creates the genericClient for serviceName using the given generic ge.
+// genericClient creates the genericClient for serviceName using the given generic ge,
+// connecting to the backends over TLS when backendTLS is set.
 // It returns the created generic client and an error if fails.
 func genericClient(serviceName string, ge generic.Generic) (genericclient.Client, error) {
-	cli, err := genericclient.NewClient(serviceName, ge, client.WithResolver(reg), client.WithLoadBalancer(lb))
+	opts := []client.Option{
+		client.WithResolver(reg),
+		client.WithLoadBalancer(lb),
+		client.WithTracer(rpcMetricsTracer{}),
+		client.WithTracer(accessLogTracer{}),
+		client.WithTransportProtocol(transport.TTHeader),
+		client.WithMetaHandler(transmeta.ClientTTHeaderHandler),
+	}
+	if backendTLS != nil {
+		opts = append(opts,
+			client.WithTransHandlerFactory(gonet.NewCliTransHandlerFactory()),
+			client.WithDialer(tlsDialer{backendTLS}),
+		)
+	}
+	cli, err := genericclient.NewClient(serviceName, ge, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -244,7 +287,7 @@ func makeGenericCall(c context.Context, cli genericclient.Client, method string,
 	return cli.GenericCall(c, method, body)
 }
 
-// updateIdl will update the idl mapping of serviceName to the given file.
+// updateIdl will update the idl mapping of serviceName to the given file and rebuild the GraphQL schema and gRPC services.
 // It returns an error if fails.
 func updateIDL(serviceName, file string) error {
 	err := mapContent(file)
@@ -258,14 +301,180 @@ func updateIDL(serviceName, file string) error {
 	}
 
 	mapping(serviceName, file, gen)
+	return buildSchemas()
+}
+
+// buildSchemas derives the methods labelled in the metrics, the request structs of the form input, the GraphQL
+// schema, and the gRPC services when gRPC is enabled, from the IDLs of the services.
+// It returns an error if any cannot be derived.
+func buildSchemas() error {
+	err := buildMetricsMethods()
+	if err != nil {
+		return err
+	}
+	err = buildFormRequests()
+	if err != nil {
+		return err
+	}
+	err = buildGraphQLSchema()
+	if err != nil {
+		return err
+	}
+	if config.GRPC.Address == "" {
+		return nil
+	}
+	return buildGRPCServices()
+}
+
+// serviceNames returns the names of the services, sorted.
+func serviceNames() []string {
+	services := make([]string, 0, len(serviceIdlMap))
+	for service := range serviceIdlMap {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// serviceDescriptor parses the IDL file into the descriptor of its service, as the generic clients do.
+func serviceDescriptor(file string) (*descriptor.ServiceDescriptor, error) {
+	tree, err := generic.ParseContent(file, idlContent[file], idlContent, true)
+	if err != nil {
+		return nil, err
+	}
+	return thrift.Parse(tree, thrift.DefaultParseMode())
+}
+
+// forEachMethod calls fn with each method of each service, sorted by service then method name,
+// and its descriptor parsed from the IDL of the service.
+// It returns an error if an IDL cannot be parsed.
+func forEachMethod(fn func(serviceName, method string, desc *descriptor.FunctionDescriptor)) error {
+	descriptors := map[string]*descriptor.ServiceDescriptor{}
+	for _, service := range serviceNames() {
+		file := serviceIdlMap[service]
+		sd, ok := descriptors[file]
+		if !ok {
+			var err error
+			sd, err = serviceDescriptor(file)
+			if err != nil {
+				return err
+			}
+			descriptors[file] = sd
+		}
+
+		methods := make([]string, 0, len(sd.Functions))
+		for method := range sd.Functions {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			fn(service, method, sd.Functions[method])
+		}
+	}
 	return nil
 }
 
+// requestArg returns the argument of the method described by desc, the request struct of its generic calls.
+// It returns false if the method does not take a single struct, which the generic calls cannot be made with.
+func requestArg(desc *descriptor.FunctionDescriptor) (*descriptor.FieldDescriptor, bool) {
+	args := desc.Request.Struct.FieldsByID
+	if len(args) != 1 {
+		return nil, false
+	}
+	for _, arg := range args {
+		return arg, arg.Type.Type == descriptor.STRUCT
+	}
+	return nil, false
+}
+
+// resultType returns the type of the result of the method described by desc, or nil if the method is void.
+func resultType(desc *descriptor.FunctionDescriptor) *descriptor.TypeDescriptor {
+	success, ok := desc.Response.Struct.FieldsByID[0]
+	if !ok || success.Type.Type == descriptor.VOID {
+		return nil
+	}
+	return success.Type
+}
+
 // initialise initialises the global variables before starting the server.
 // It returns any error when calling other functions create new instances.
 func initialise() error {
 	var err error
 
+	config, err = loadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	err = checkRateLimitConfig(config.RateLimit)
+	if err != nil {
+		return err
+	}
+	buckets = newBucketStore(config.RateLimit)
+	bulkheads = newBulkheadGroup(config.Bulkhead)
+	accessLogger, err = newAccessLogger(config.AccessLog)
+	if err != nil {
+		return err
+	}
+	verifier = nil
+	if len(config.JWT.Rules) > 0 {
+		verifier, err = newJWTVerifier(config.JWT)
+		if err != nil {
+			return err
+		}
+	}
+	keys = nil
+	if config.APIKeys.File != "" {
+		keys, err = newFileKeyStore(config.APIKeys.File)
+		if err != nil {
+			return err
+		}
+	}
+	policy = nil
+	if config.Authz.PolicyFile != "" {
+		policy, err = loadPolicy(config.Authz.PolicyFile)
+		if err != nil {
+			return err
+		}
+	}
+	decisionLogger, err = openLog(config.Authz.DecisionLog)
+	if err != nil {
+		return err
+	}
+	serverTLS, err = newServerTLS(config.TLS)
+	if err != nil {
+		return err
+	}
+	backendTLS, err = newBackendTLS(config.BackendTLS)
+	if err != nil {
+		return err
+	}
+	clientFilter, err = newIPFilter(config.IPFilter)
+	if err != nil {
+		return err
+	}
+	responses = nil
+	if len(config.Cache.Rules) > 0 {
+		responses = newMemoryCache(config.Cache.MaxEntries, config.Cache.MaxBytes)
+	}
+	jobs = nil
+	if len(config.Async.Routes) > 0 {
+		jobs = newMemoryJobStore(config.Async.MaxJobs, time.Duration(config.Async.TTL))
+	}
+
+	err = checkCORSConfig(config.CORS)
+	if err != nil {
+		return err
+	}
+	err = checkWebSocketConfig(config.WebSocket)
+	if err != nil {
+		return err
+	}
+
+	composites, err = newComposites(config.Composite)
+	if err != nil {
+		return err
+	}
+
 	lb = loadbalance.NewWeightedRandomBalancer()
 	reg, err = createNacosRegistry()
 	if err != nil {
@@ -282,9 +491,18 @@ func initialise() error {
 
 // decode handles the incoming request and performs the necessary operations.
 // It validates the context ctx, parses the request body, discover the service,
-// and makes a generic call with load balancer. Finally, it returns the response in JSON, or an error if any operation fails.
+// and makes a generic call with load balancer, shedding it if the service has too many calls in flight. Finally, it returns the response in JSON, or an error if any operation fails.
+// Each step is traced in a span continuing the trace of the traceparent header, which is passed on to the backend.
+// Requests to composite routes are answered by compose instead.
 func decode(c context.Context, ctx *app.RequestContext) {
+	c, span := startRequestSpan(c, ctx)
+	defer finishRequestSpan(span, ctx)
+
+	_, parseSpan := tracer.Start(c, "parse")
+	defer parseSpan.End()
+
 	if invalidContentType(ctx) {
+		setErrorClass(ctx, "invalid_request")
 		ctx.SetStatusCode(http.StatusBadRequest)
 		ctx.String(consts.StatusBadRequest, "Invalid Content-Type, expected application/json")
 		return
@@ -292,6 +510,7 @@ func decode(c context.Context, ctx *app.RequestContext) {
 
 	splitArr := readPath(ctx)
 	if len(splitArr) < 3 {
+		setErrorClass(ctx, "invalid_request")
 		ctx.SetStatusCode(http.StatusBadRequest)
 		ctx.String(consts.StatusBadRequest, "Invalid URL path")
 		return
@@ -299,20 +518,34 @@ func decode(c context.Context, ctx *app.RequestContext) {
 
 	serviceName := getServiceName(splitArr)
 	method := getMethod(splitArr)
+	setSpanRoute(span, serviceName, method)
+
+	if route, ok := composites[serviceName+"/"+method]; ok {
+		parseSpan.End()
+		compose(c, ctx, route)
+		return
+	}
 
 	body, err := ctx.Body()
 	if err != nil {
+		setErrorClass(ctx, "read_body")
 		ctx.SetStatusCode(http.StatusInternalServerError)
 		ctx.String(consts.StatusInternalServerError, "Internal Server Error")
 		return
 	}
 
-	reqBody, err := parseRequestBody(body)
-	if err != nil {
-		ctx.SetStatusCode(http.StatusBadRequest)
-		ctx.String(consts.StatusBadRequest, "Invalid JSON data")
-		return
+	// The requests mapped from form input by formInput are typed by the IDL already, and do not update it.
+	var reqBody map[string]string
+	if !ctx.GetBool(formInputKey) {
+		reqBody, err = parseRequestBody(body)
+		if err != nil {
+			setErrorClass(ctx, "invalid_request")
+			ctx.SetStatusCode(http.StatusBadRequest)
+			ctx.String(consts.StatusBadRequest, "Invalid JSON data")
+			return
+		}
 	}
+	parseSpan.End()
 
 	file, ok := reqBody["file"]
 
@@ -321,6 +554,7 @@ func decode(c context.Context, ctx *app.RequestContext) {
 	}
 
 	if err != nil {
+		setErrorClass(ctx, "idl_update")
 		ctx.SetStatusCode(http.StatusInternalServerError)
 		ctx.String(consts.StatusInternalServerError, "Internal server error, fail to update IDL")
 		return
@@ -334,20 +568,49 @@ func decode(c context.Context, ctx *app.RequestContext) {
 
 	_, ok = serviceClientMap[serviceName]
 	if !ok {
+		setErrorClass(ctx, "unknown_service")
 		ctx.SetStatusCode(http.StatusBadRequest)
 		ctx.String(consts.StatusBadRequest, "Invalid service name, service undefined")
 		return
 	}
 
-	_, err = resolveService(c, reg, serviceName)
+	resolveCtx, resolveSpan := tracer.Start(c, "resolve")
+	defer resolveSpan.End()
+	result, err := resolveService(resolveCtx, reg, serviceName)
+	endSpan(resolveSpan, err)
 	if err != nil {
+		setErrorClass(ctx, "resolve")
 		ctx.SetStatusCode(http.StatusInternalServerError)
 		ctx.String(consts.StatusInternalServerError, "Error resolving service")
 		return
 	}
+	resolverInstances.WithLabelValues(serviceName).Set(float64(len(result.Instances)))
+
+	release, err := bulkheads.acquire(c, serviceName)
+	if err != nil {
+		setErrorClass(ctx, "shed")
+		ctx.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.String(consts.StatusServiceUnavailable, "Service overloaded, request shed")
+		return
+	}
 
-	resp, err := makeGenericCall(c, serviceClientMap[serviceName], method, string(body))
+	callCtx, callSpan := startCallSpan(c, serviceName, method)
+	callCtx = forwardRequestID(callCtx, ctx)
+	start := time.Now()
+	resp, err := makeGenericCall(callCtx, serviceClientMap[serviceName], method, string(body))
+	release(time.Since(start), err)
+	endSpan(callSpan, err)
 	if err != nil {
+		ctx.Set(callErrorKey, err)
+	}
+	if isPreconditionFailed(err) {
+		setErrorClass(ctx, "precondition_failed")
+		ctx.SetStatusCode(http.StatusPreconditionFailed)
+		ctx.String(consts.StatusPreconditionFailed, "Precondition failed")
+		return
+	}
+	if err != nil {
+		setErrorClass(ctx, "generic_call")
 		ctx.SetStatusCode(http.StatusInternalServerError)
 		ctx.String(consts.StatusInternalServerError, "Error making generic call")
 		return
@@ -356,6 +619,7 @@ func decode(c context.Context, ctx *app.RequestContext) {
 	var response map[string]string
 	response, err = utils.JSONStr2Map(resp.(string))
 	if err != nil {
+		setErrorClass(ctx, "transform_response")
 		ctx.SetStatusCode(http.StatusInternalServerError)
 		ctx.String(consts.StatusInternalServerError, "Fail to transform response", resp)
 		return
@@ -363,23 +627,150 @@ func decode(c context.Context, ctx *app.RequestContext) {
 	ctx.JSON(consts.StatusOK, response)
 }
 
+// gatewayMiddlewares returns the middlewares enforcing the gateway policies, in the order they apply to every request.
+func gatewayMiddlewares() app.HandlersChain {
+	return append(app.HandlersChain{accessLog, filterIP, limitRequest, cors, rateLimit, formInput, jwtAuth, apiKeyAuth, verifySignature, authorize, idempotent, respondAsync}, callMiddlewares()...)
+}
+
+// callMiddlewares returns the middlewares shaping the call of an admitted request, which asynchronous calls
+// go through in the background.
+func callMiddlewares() app.HandlersChain {
+	return app.HandlersChain{conditional, cacheResponse, coalesce}
+}
+
+// callOrigin is the request on behalf of which the gateway makes calls to the backend services,
+// holding what the calls take from it so that they can be made concurrently.
+type callOrigin struct {
+	header     protocol.RequestHeader
+	requestID  string
+	conn       network.Conn
+	hmacClient string
+}
+
+// newCallOrigin returns the origin of the calls made on behalf of the request in ctx,
+// made by the client whose signature the request carries, if valid.
+func newCallOrigin(c context.Context, ctx *app.RequestContext) *callOrigin {
+	o := &callOrigin{requestID: requestID(ctx), conn: ctx.GetConn()}
+	if o.requestID == "" {
+		o.requestID = newRequestID()
+	}
+	ctx.Request.Header.CopyTo(&o.header)
+	// The calls are made to other URIs, losing an API key presented as a query parameter.
+	if key := presentedAPIKey(ctx); key != "" {
+		o.header.Set(apiKeyHeader, key)
+	}
+	o.hmacClient = ctx.GetString(hmacClientKey)
+	if o.hmacClient == "" {
+		o.verifySignature(c, string(ctx.Method()), string(ctx.Request.URI().RequestURI()), rawBody(ctx))
+	}
+	return o
+}
+
+// verifySignature checks the signature of the origin request, made with method to uri with body, once for all
+// its calls, which are then made on behalf of the client that signed it. The calls of a request without a valid
+// signature are made on behalf of no client, and rejected by the routes requiring a signature.
+func (o *callOrigin) verifySignature(c context.Context, method, uri string, body []byte) {
+	if len(o.header.Peek(hmacsign.HeaderClientID)) == 0 {
+		return
+	}
+	o.hmacClient, _ = checkSignature(c, func(key string) string {
+		return string(o.header.Peek(key))
+	}, method, uri, body)
+}
+
+// dispatch makes the call to method of serviceName with the JSON body through the gateway middlewares and decode,
+// as if it had been sent on its own with the headers of the origin request, but always synchronously
+// and without idempotency key, and signed by the client that signed the origin request.
+// The call is logged under the ID of the origin request followed by suffix.
+// It returns the context of the call, holding its response.
+func (o *callOrigin) dispatch(c context.Context, suffix, serviceName, method string, body []byte) *app.RequestContext {
+	ctx := app.NewContext(0)
+	o.header.CopyTo(&ctx.Request.Header)
+	ctx.Request.Header.SetMethod(http.MethodPost)
+	ctx.Request.SetRequestURI("/" + serviceName + "/" + method)
+	ctx.Request.Header.SetContentTypeBytes([]byte("application/json"))
+	ctx.Request.Header.Set(requestIDHeader, o.requestID+"-"+suffix)
+	ctx.Request.Header.DelBytes([]byte(preferHeader))
+	ctx.Request.Header.DelBytes([]byte(idempotencyKeyHeader))
+	ctx.Request.SetBody(body)
+	ctx.Request.Header.SetContentLength(len(body))
+	ctx.SetConn(o.conn)
+	ctx.SetClientIPFunc(clientIP)
+	if o.hmacClient != "" {
+		ctx.Set(hmacClientKey, o.hmacClient)
+	}
+
+	ctx.SetHandlers(append(gatewayMiddlewares(), decode))
+	ctx.Next(c)
+	return ctx
+}
+
+// newGateway creates the Hertz server of the gateway listening on hostPorts, with the middlewares
+// enforcing the gateway policies and its routes registered. It serves HTTPS when TLS is configured,
+// on the standard transport since the netpoll one does not support TLS.
+func newGateway(hostPorts string) *server.Hertz {
+	opts := []hzconfig.Option{
+		server.WithHostPorts(hostPorts),
+		server.WithTracer(httpMetricsTracer{}),
+	}
+	if serverTLS != nil {
+		opts = append(opts, server.WithTLS(serverTLS), server.WithTransport(standard.NewTransporter))
+	}
+	if config.Limits.MaxBodyBytes > 0 {
+		opts = append(opts, server.WithMaxRequestBodySize(config.Limits.MaxBodyBytes))
+	}
+	hz := server.Default(opts...)
+	hz.SetClientIPFunc(clientIP)
+	hz.NoHijackConnPool = true
+
+	hz.Use(gatewayMiddlewares()...)
+
+	hz.GET("/metrics", serveMetrics)
+
+	admin := hz.Group("/_admin", adminAuth)
+	admin.GET("/keys", listAPIKeys)
+	admin.POST("/keys", createAPIKey)
+	admin.POST("/keys/:id/rotate", rotateAPIKey)
+	admin.DELETE("/keys/:id", revokeAPIKey)
+	admin.DELETE("/cache", purgeCache)
+	admin.POST("/subscriptions/:service/:method", notifySubscriptions)
+
+	hz.POST("/_batch", batch)
+	hz.POST("/jsonrpc", jsonRPC)
+	hz.GET("/graphql", serveGraphQL)
+	hz.POST("/graphql", serveGraphQL)
+	hz.GET("/_ws", serveWebSocket)
+	hz.GET("/_subscribe", subscribe)
+	hz.GET("/_jobs/:id", getJob)
+
+	hz.Any("/", decode)
+	hz.NoRoute(decode)
+	hz.NoMethod(decode)
+	return hz
+}
+
 // main acts as the entry point of the server application. It sets up a server
 // using the Hertz framework and registers the `decode` function as the
-// handler for incoming requests. The server listens on 127.0.0.1:8888
-// and handles requests for any registered routes.
+// handler for incoming requests, behind the middlewares enforcing the gateway policies.
+// The server listens on 127.0.0.1:8888, over HTTPS when TLS is configured, and handles requests for any registered routes.
+// The gRPC services are served alongside when a gRPC address is configured.
 func main() {
-	hz := server.Default(
-		server.WithHostPorts("127.0.0.1:8888"),
-	)
-
 	err := initialise()
 	if err != nil {
 		panic(err.Error())
 	}
 
-	hz.Any("/", decode)
-	hz.NoRoute(decode)
-	hz.NoMethod(decode)
+	shutdownTracing, err := initTracing(context.Background(), config.Tracing)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer shutdownTracing(context.Background())
+
+	stopGRPC, err := startGRPCServer(config.GRPC)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer stopGRPC()
 
-	hz.Spin()
+	newGateway("127.0.0.1:8888").Spin()
 }
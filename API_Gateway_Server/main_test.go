@@ -48,6 +48,14 @@ func TestInvalidContentType_InvalidRequest(t *testing.T) {
 	assert.True(t, boolean)
 }
 
+func TestInvalidContentType_Parameters(t *testing.T) {
+	ctx := &app.RequestContext{}
+	ctx.Request.SetHeader("Content-Type", "Application/JSON; charset=utf-8")
+
+	boolean := invalidContentType(ctx)
+	assert.False(t, boolean)
+}
+
 func TestReadPath(t *testing.T) {
 	ctx := &app.RequestContext{}
 	ctx.Request.SetRequestURI("/foo/bar")
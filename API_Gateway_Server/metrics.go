@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/cloudwego/kitex/pkg/generic/descriptor"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/cloudwego/kitex/pkg/stats"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/expfmt"
+)
+
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	requestsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_requests_total",
+		Help: "HTTP requests handled by the gateway.",
+	}, []string{"service", "method", "status_class"})
+
+	requestErrorsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_request_errors_total",
+		Help: "HTTP requests answered with a 4xx or 5xx status.",
+	}, []string{"service", "method", "status_class"})
+
+	requestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_request_duration_seconds",
+		Help:    "Latency of the HTTP requests handled by the gateway.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "method", "status_class"})
+
+	requestsInFlight = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_requests_in_flight",
+		Help: "HTTP requests currently being handled by the gateway.",
+	})
+
+	rpcCallsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_rpc_calls_total",
+		Help: "Generic calls made to backend instances.",
+	}, []string{"service", "method", "instance", "status_class"})
+
+	rpcErrorsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_rpc_errors_total",
+		Help: "Generic calls to backend instances that failed.",
+	}, []string{"service", "method", "instance"})
+
+	rpcDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_rpc_duration_seconds",
+		Help:    "Latency of the generic calls made to backend instances.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "method", "instance"})
+
+	resolverInstances = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_resolver_instances",
+		Help: "Instances found by the resolver at the last resolution of each service.",
+	}, []string{"service"})
+
+	_ = promauto.With(metricsRegistry).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gateway_loaded_idls",
+		Help: "IDL files currently loaded by the gateway.",
+	}, func() float64 { return float64(len(idlContent)) })
+)
+
+func init() {
+	metricsRegistry.MustRegister(collectors.NewGoCollector())
+	metricsRegistry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// statusClass returns the class of the HTTP status code, such as "2xx".
+func statusClass(code int) string {
+	return fmt.Sprintf("%dxx", code/100)
+}
+
+// metricsMethods holds the methods of the services defined by their IDLs, by "service/method",
+// rebuilt whenever an IDL changes.
+var metricsMethods atomic.Value
+
+// buildMetricsMethods lists the methods of the services from their IDLs, the methods labelled in the metrics.
+// It returns an error if an IDL cannot be parsed, keeping the previous methods.
+func buildMetricsMethods() error {
+	methods := map[string]bool{}
+	err := forEachMethod(func(serviceName, method string, _ *descriptor.FunctionDescriptor) {
+		methods[serviceName+"/"+method] = true
+	})
+	if err != nil {
+		return err
+	}
+	metricsMethods.Store(methods)
+	return nil
+}
+
+// metricsRoute returns the service and method labels of ctx.
+func metricsRoute(ctx *app.RequestContext) (string, string) {
+	return metricsLabels(routeOf(ctx))
+}
+
+// metricsLabels returns the service and method labels of a call to method of serviceName.
+// Services that are not loaded, and methods their IDL does not define, are labelled "unknown"
+// to bound the label cardinality.
+func metricsLabels(serviceName, method string) (string, string) {
+	if _, ok := serviceClientMap[serviceName]; !ok {
+		return "unknown", "unknown"
+	}
+	methods, _ := metricsMethods.Load().(map[string]bool)
+	if !methods[serviceName+"/"+method] {
+		return serviceName, "unknown"
+	}
+	return serviceName, method
+}
+
+type requestStartKey struct{}
+
+// httpMetricsTracer is the Hertz tracer recording the metrics of every HTTP request.
+type httpMetricsTracer struct{}
+
+// Start records that a request is in flight and when it started.
+func (httpMetricsTracer) Start(c context.Context, _ *app.RequestContext) context.Context {
+	requestsInFlight.Inc()
+	return context.WithValue(c, requestStartKey{}, time.Now())
+}
+
+// Finish records the outcome and latency of the request in ctx.
+func (httpMetricsTracer) Finish(c context.Context, ctx *app.RequestContext) {
+	requestsInFlight.Dec()
+
+	serviceName, method := metricsRoute(ctx)
+	code := ctx.Response.StatusCode()
+	class := statusClass(code)
+
+	requestsTotal.WithLabelValues(serviceName, method, class).Inc()
+	if code >= consts.StatusBadRequest {
+		requestErrorsTotal.WithLabelValues(serviceName, method, class).Inc()
+	}
+	if start, ok := c.Value(requestStartKey{}).(time.Time); ok {
+		requestDuration.WithLabelValues(serviceName, method, class).Observe(time.Since(start).Seconds())
+	}
+}
+
+// rpcMetricsTracer is the Kitex client tracer recording the metrics of every generic call.
+type rpcMetricsTracer struct{}
+
+// Start does nothing, the call is timed with the events recorded by Kitex.
+func (rpcMetricsTracer) Start(c context.Context) context.Context {
+	return c
+}
+
+// Finish records the outcome and latency of the generic call in c, labelled by the instance called.
+// Methods the IDL does not define are labelled "unknown" like in the HTTP metrics.
+func (rpcMetricsTracer) Finish(c context.Context) {
+	ri := rpcinfo.GetRPCInfo(c)
+	if ri == nil {
+		return
+	}
+
+	instance := "none"
+	if addr := ri.To().Address(); addr != nil {
+		instance = addr.String()
+	}
+	serviceName, method := metricsLabels(ri.To().ServiceName(), ri.To().Method())
+
+	class := "ok"
+	if ri.Stats().Error() != nil {
+		class = "error"
+		rpcErrorsTotal.WithLabelValues(serviceName, method, instance).Inc()
+	}
+	rpcCallsTotal.WithLabelValues(serviceName, method, instance, class).Inc()
+
+	start, finish := ri.Stats().GetEvent(stats.RPCStart), ri.Stats().GetEvent(stats.RPCFinish)
+	if start != nil && finish != nil && !start.IsNil() && !finish.IsNil() {
+		rpcDuration.WithLabelValues(serviceName, method, instance).Observe(finish.Time().Sub(start.Time()).Seconds())
+	}
+}
+
+// serveMetrics writes the gateway metrics in the Prometheus text format.
+func serveMetrics(_ context.Context, ctx *app.RequestContext) {
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		ctx.SetStatusCode(consts.StatusInternalServerError)
+		ctx.String(consts.StatusInternalServerError, "Fail to gather metrics")
+		return
+	}
+
+	ctx.SetContentType(string(expfmt.FmtText))
+	encoder := expfmt.NewEncoder(ctx, expfmt.FmtText)
+	for _, family := range families {
+		err = encoder.Encode(family)
+		if err != nil {
+			ctx.SetStatusCode(consts.StatusInternalServerError)
+			return
+		}
+	}
+}
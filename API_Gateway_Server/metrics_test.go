@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", statusClass(http.StatusOK))
+	assert.Equal(t, "4xx", statusClass(http.StatusTooManyRequests))
+	assert.Equal(t, "5xx", statusClass(http.StatusServiceUnavailable))
+}
+
+func TestHTTPMetricsTracer_RecordsRequest(t *testing.T) {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodGet, "/ServiceZ/methodA", nil),
+	}
+	ctx.SetStatusCode(http.StatusBadRequest)
+
+	before := testutil.ToFloat64(requestErrorsTotal.WithLabelValues("unknown", "unknown", "4xx"))
+
+	tracer := httpMetricsTracer{}
+	c := tracer.Start(context.Background(), ctx)
+	assert.Equal(t, float64(1), testutil.ToFloat64(requestsInFlight))
+	tracer.Finish(c, ctx)
+
+	assert.Equal(t, float64(0), testutil.ToFloat64(requestsInFlight))
+	assert.Equal(t, before+1, testutil.ToFloat64(requestErrorsTotal.WithLabelValues("unknown", "unknown", "4xx")))
+}
+
+func TestMetricsRoute_MethodsOfIDL(t *testing.T) {
+	useFakeBackend(t, nil)
+	useServiceIDLs(t)
+	assert.NoError(t, buildMetricsMethods())
+
+	routeOf := func(path string) []string {
+		ctx := &app.RequestContext{Request: *protocol.NewRequest(http.MethodPost, path, nil)}
+		serviceName, method := metricsRoute(ctx)
+		return []string{serviceName, method}
+	}
+	assert.Equal(t, []string{"ServiceA", "methodA"}, routeOf("/ServiceA/methodA"))
+	assert.Equal(t, []string{"ServiceA", "unknown"}, routeOf("/ServiceA/random-1234"))
+	assert.Equal(t, []string{"unknown", "unknown"}, routeOf("/ServiceZ/methodA"))
+}
+
+func TestRPCMetricsTracer_MethodsOfIDL(t *testing.T) {
+	useFakeBackend(t, nil)
+	useServiceIDLs(t)
+	assert.NoError(t, buildMetricsMethods())
+
+	finish := func(method string) {
+		to := rpcinfo.NewEndpointInfo("ServiceA", method, nil, nil)
+		ri := rpcinfo.NewRPCInfo(nil, to, nil, nil, rpcinfo.NewRPCStats())
+		rpcMetricsTracer{}.Finish(rpcinfo.NewCtxWithRPCInfo(context.Background(), ri))
+	}
+	known := testutil.ToFloat64(rpcCallsTotal.WithLabelValues("ServiceA", "methodA", "none", "ok"))
+	unknown := testutil.ToFloat64(rpcCallsTotal.WithLabelValues("ServiceA", "unknown", "none", "ok"))
+	finish("methodA")
+	finish("random-1234")
+
+	assert.Equal(t, known+1, testutil.ToFloat64(rpcCallsTotal.WithLabelValues("ServiceA", "methodA", "none", "ok")))
+	assert.Equal(t, unknown+1, testutil.ToFloat64(rpcCallsTotal.WithLabelValues("ServiceA", "unknown", "none", "ok")))
+}
+
+func TestServeMetrics(t *testing.T) {
+	resolverInstances.WithLabelValues("ServiceA").Set(3)
+
+	ctx := &app.RequestContext{}
+	serveMetrics(context.Background(), ctx)
+
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	body := string(ctx.Response.Body())
+	assert.True(t, strings.Contains(body, `gateway_resolver_instances{service="ServiceA"} 3`))
+	assert.True(t, strings.Contains(body, "gateway_loaded_idls"))
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+// maxBuckets is the number of in-memory buckets kept, the least recently used being evicted beyond it.
+const maxBuckets = 10000
+
+// casAttempts is the number of times a distributed bucket update is retried on contention.
+const casAttempts = 5
+
+var buckets bucketStore = newMemoryBucketStore()
+
+var errBucketContention = errors.New("token bucket contention, too many concurrent updates")
+
+// rateLimitConfig configures the token bucket rate limits applied before decode.
+// Distributed keeps the buckets in sharedStore, so that every gateway instance enforces the same limits
+// once sharedStore is an external store; it is rejected while sharedStore is in-process.
+type rateLimitConfig struct {
+	Distributed bool            `json:"distributed"`
+	Rules       []rateLimitRule `json:"rules"`
+}
+
+// rateLimitRule limits requests to the routes matching Route to Rate requests
+// per second with bursts of up to Burst requests. Key selects what a bucket
+// is kept for: "ip" for each client IP, "apikey" for each API key or "route" for the route itself.
+type rateLimitRule struct {
+	Route string  `json:"route"`
+	Key   string  `json:"key"`
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// bucketState is the number of tokens in a bucket at the time it was last updated.
+type bucketState struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateDecision is the outcome of taking a token from a bucket.
+type rateDecision struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	retryAfter time.Duration
+	reset      time.Duration
+}
+
+// bucketStore keeps the token buckets of the rate limiter.
+type bucketStore interface {
+	take(c context.Context, key string, rule rateLimitRule, now time.Time) (rateDecision, error)
+}
+
+// effectiveBurst returns the capacity of the buckets of rule, at least one token.
+func effectiveBurst(rule rateLimitRule) float64 {
+	return math.Max(1, float64(rule.Burst))
+}
+
+// takeToken refills state for the time elapsed until now and takes one token from it if available.
+// A nil state is a new, full bucket.
+// It returns the updated state and the decision.
+func takeToken(state *bucketState, rule rateLimitRule, now time.Time) (bucketState, rateDecision) {
+	burst := effectiveBurst(rule)
+
+	next := bucketState{tokens: burst, last: now}
+	if state != nil {
+		elapsed := now.Sub(state.last).Seconds()
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		next.tokens = math.Min(burst, state.tokens+elapsed*rule.Rate)
+	}
+
+	d := rateDecision{limit: int(burst)}
+	if next.tokens >= 1 {
+		next.tokens--
+		d.allowed = true
+	} else if rule.Rate > 0 {
+		d.retryAfter = secondsToDuration((1 - next.tokens) / rule.Rate)
+	} else {
+		d.retryAfter = time.Hour
+	}
+	d.remaining = int(next.tokens)
+	if rule.Rate > 0 {
+		d.reset = secondsToDuration((burst - next.tokens) / rule.Rate)
+	}
+	return next, d
+}
+
+// secondsToDuration converts s seconds to a time.Duration.
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// memoryBucketStore keeps up to maxBuckets token buckets in the memory of this gateway instance,
+// ordered from the most to the least recently used.
+type memoryBucketStore struct {
+	mu         sync.Mutex
+	maxBuckets int
+	order      *list.List
+	buckets    map[string]*list.Element
+}
+
+type memoryBucket struct {
+	key   string
+	state bucketState
+	rule  rateLimitRule
+}
+
+// newMemoryBucketStore creates an empty in-memory bucketStore.
+func newMemoryBucketStore() *memoryBucketStore {
+	return &memoryBucketStore{maxBuckets: maxBuckets, order: list.New(), buckets: make(map[string]*list.Element)}
+}
+
+// full reports whether b would be full again by now, and so equivalent to a new bucket.
+func (b *memoryBucket) full(now time.Time) bool {
+	return b.rule.Rate > 0 && b.state.tokens+now.Sub(b.state.last).Seconds()*b.rule.Rate >= effectiveBurst(b.rule)
+}
+
+// take takes a token from the bucket at key.
+// A new bucket first evicts the least recently used buckets that are full again, then the least recently
+// used bucket if there are still maxBuckets of them.
+func (s *memoryBucketStore) take(_ context.Context, key string, rule rateLimitRule, now time.Time) (rateDecision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.buckets[key]; ok {
+		b := elem.Value.(*memoryBucket)
+		next, d := takeToken(&b.state, rule, now)
+		b.state, b.rule = next, rule
+		s.order.MoveToFront(elem)
+		return d, nil
+	}
+
+	for oldest := s.order.Back(); oldest != nil && oldest.Value.(*memoryBucket).full(now); oldest = s.order.Back() {
+		s.remove(oldest)
+	}
+	if s.order.Len() >= s.maxBuckets {
+		s.remove(s.order.Back())
+	}
+	next, d := takeToken(nil, rule, now)
+	s.buckets[key] = s.order.PushFront(&memoryBucket{key: key, state: next, rule: rule})
+	return d, nil
+}
+
+// remove deletes the bucket of elem. The caller must hold s.mu.
+func (s *memoryBucketStore) remove(elem *list.Element) {
+	s.order.Remove(elem)
+	delete(s.buckets, elem.Value.(*memoryBucket).key)
+}
+
+// kvBucketStore keeps token buckets in a kvStore.
+// Concurrent updates are resolved with compare-and-swap.
+type kvBucketStore struct {
+	kv kvStore
+}
+
+// newKVBucketStore creates a bucketStore backed by kv.
+func newKVBucketStore(kv kvStore) *kvBucketStore {
+	return &kvBucketStore{kv: kv}
+}
+
+// take takes a token from the bucket at key.
+// It returns an error if the store fails or the bucket stays contended for casAttempts attempts.
+func (s *kvBucketStore) take(c context.Context, key string, rule rateLimitRule, now time.Time) (rateDecision, error) {
+	key = "ratelimit/" + key
+	for i := 0; i < casAttempts; i++ {
+		old, ok, err := s.kv.Get(c, key)
+		if err != nil {
+			return rateDecision{}, err
+		}
+
+		var state *bucketState
+		if ok {
+			state, err = decodeBucket(old)
+			if err != nil {
+				return rateDecision{}, err
+			}
+		}
+
+		next, d := takeToken(state, rule, now)
+		swapped, err := s.kv.CompareAndSwap(c, key, old, encodeBucket(next), d.reset+time.Second)
+		if err != nil {
+			return rateDecision{}, err
+		}
+		if swapped {
+			return d, nil
+		}
+	}
+	return rateDecision{}, errBucketContention
+}
+
+// encodeBucket serialises state for storage in a kvStore.
+func encodeBucket(state bucketState) []byte {
+	return []byte(fmt.Sprintf("%g|%d", state.tokens, state.last.UnixNano()))
+}
+
+// decodeBucket parses a bucket serialised by encodeBucket.
+// It returns the bucket and an error if data is malformed.
+func decodeBucket(data []byte) (*bucketState, error) {
+	var tokens float64
+	var nanos int64
+	_, err := fmt.Sscanf(string(data), "%g|%d", &tokens, &nanos)
+	if err != nil {
+		return nil, err
+	}
+	return &bucketState{tokens: tokens, last: time.Unix(0, nanos)}, nil
+}
+
+// checkRateLimitConfig returns an error if cfg asks for distributed rate limits without a store shared by
+// the gateway instances.
+func checkRateLimitConfig(cfg rateLimitConfig) error {
+	if _, inProcess := sharedStore.(*memoryKV); cfg.Distributed && inProcess {
+		return errors.New("distributed rate limits need an external shared store, only the in-process store is available")
+	}
+	return nil
+}
+
+// newBucketStore creates the bucketStore described by cfg.
+func newBucketStore(cfg rateLimitConfig) bucketStore {
+	if cfg.Distributed {
+		return newKVBucketStore(sharedStore)
+	}
+	return newMemoryBucketStore()
+}
+
+// rateLimitKey returns the bucket key of ctx under the rule at index i.
+// It returns false if the rule does not apply to ctx, such as an API key rule for a request without a key.
+// An API key rule keeps a bucket for the ID of each known key, never for the secret presented, and one for the
+// client IP of the requests presenting an unknown key, so that made up keys do not each get a full bucket.
+func rateLimitKey(ctx *app.RequestContext, i int, rule rateLimitRule, serviceName, method string) (string, bool) {
+	switch rule.Key {
+	case "ip":
+		return fmt.Sprintf("%d/ip/%s", i, ctx.ClientIP()), true
+	case "apikey":
+		presented := presentedAPIKey(ctx)
+		if presented == "" {
+			return "", false
+		}
+		if keys != nil {
+			if k, err := lookupAPIKey(keys, presented); err == nil {
+				return fmt.Sprintf("%d/apikey/%s", i, k.ID), true
+			}
+		}
+		return fmt.Sprintf("%d/ip/%s", i, ctx.ClientIP()), true
+	case "route":
+		return fmt.Sprintf("%d/route/%s/%s", i, serviceName, method), true
+	}
+	return "", false
+}
+
+// ceilSeconds returns d in whole seconds, rounded up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// rateLimit is the middleware enforcing the rate limit rules matching the requested route.
+// Requests over a limit are rejected with 429 and a Retry-After header, and the
+// X-RateLimit-* headers report the state of the most exhausted bucket.
+// Requests are let through if the bucket store fails.
+func rateLimit(c context.Context, ctx *app.RequestContext) {
+	serviceName, method := routeOf(ctx)
+
+	var tightest *rateDecision
+	for i, rule := range config.RateLimit.Rules {
+		if !matchRoute(rule.Route, serviceName, method) {
+			continue
+		}
+		key, ok := rateLimitKey(ctx, i, rule, serviceName, method)
+		if !ok {
+			continue
+		}
+		d, err := buckets.take(c, key, rule, time.Now())
+		if err != nil {
+			continue
+		}
+		if tightest == nil || !d.allowed || tightest.allowed && d.remaining < tightest.remaining {
+			tightest = &d
+		}
+		if !d.allowed {
+			break
+		}
+	}
+
+	if tightest == nil {
+		ctx.Next(c)
+		return
+	}
+
+	if !tightest.allowed {
+		ctx.AbortWithMsg("Too many requests, rate limit exceeded", http.StatusTooManyRequests)
+		setErrorClass(ctx, "rate_limited")
+		retryAfter := tightest.retryAfter
+		if retryAfter < time.Second {
+			retryAfter = time.Second
+		}
+		ctx.Header("Retry-After", ceilSeconds(retryAfter))
+	}
+	ctx.Header("X-RateLimit-Limit", strconv.Itoa(tightest.limit))
+	ctx.Header("X-RateLimit-Remaining", strconv.Itoa(tightest.remaining))
+	ctx.Header("X-RateLimit-Reset", ceilSeconds(tightest.reset))
+	if tightest.allowed {
+		ctx.Next(c)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRateLimitContext(apiKey string) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodGet, "/ServiceA/methodA", nil),
+	}
+	if apiKey != "" {
+		ctx.Request.SetHeader(apiKeyHeader, apiKey)
+	}
+	return ctx
+}
+
+func TestTakeToken_BurstThenRefill(t *testing.T) {
+	rule := rateLimitRule{Rate: 1, Burst: 2}
+	now := time.Unix(1000, 0)
+
+	state, d := takeToken(nil, rule, now)
+	assert.True(t, d.allowed)
+	assert.Equal(t, 1, d.remaining)
+
+	state, d = takeToken(&state, rule, now)
+	assert.True(t, d.allowed)
+	assert.Equal(t, 0, d.remaining)
+
+	state, d = takeToken(&state, rule, now)
+	assert.False(t, d.allowed)
+	assert.Equal(t, time.Second, d.retryAfter)
+
+	_, d = takeToken(&state, rule, now.Add(time.Second))
+	assert.True(t, d.allowed)
+}
+
+func TestKVBucketStore_SharedBetweenInstances(t *testing.T) {
+	kv := newMemoryKV()
+	first := newKVBucketStore(kv)
+	second := newKVBucketStore(kv)
+	rule := rateLimitRule{Rate: 1, Burst: 1}
+	now := time.Now()
+
+	d, err := first.take(context.Background(), "client", rule, now)
+	assert.NoError(t, err)
+	assert.True(t, d.allowed)
+
+	d, err = second.take(context.Background(), "client", rule, now)
+	assert.NoError(t, err)
+	assert.False(t, d.allowed)
+}
+
+func TestRateLimit_RejectsOverLimit(t *testing.T) {
+	store := useAPIKeys(t)
+	partner, hash := newAPIKeySecret("partner")
+	assert.NoError(t, store.Put(apiKey{ID: "partner", Hash: hash, Owner: "partner"}))
+	other, hash := newAPIKeySecret("other")
+	assert.NoError(t, store.Put(apiKey{ID: "other", Hash: hash, Owner: "other"}))
+	config.RateLimit = rateLimitConfig{
+		Rules: []rateLimitRule{{Route: "ServiceA/*", Key: "apikey", Rate: 0.5, Burst: 1}},
+	}
+	buckets = newMemoryBucketStore()
+
+	ctx := newRateLimitContext(partner)
+	rateLimit(context.Background(), ctx)
+	assert.False(t, ctx.IsAborted())
+	assert.Equal(t, "1", string(ctx.Response.Header.Peek("X-RateLimit-Limit")))
+	assert.Equal(t, "0", string(ctx.Response.Header.Peek("X-RateLimit-Remaining")))
+
+	ctx = newRateLimitContext(partner)
+	rateLimit(context.Background(), ctx)
+	assert.True(t, ctx.IsAborted())
+	assert.Equal(t, http.StatusTooManyRequests, ctx.Response.StatusCode())
+	assert.Equal(t, "2", string(ctx.Response.Header.Peek("Retry-After")))
+
+	ctx = newRateLimitContext(other)
+	rateLimit(context.Background(), ctx)
+	assert.False(t, ctx.IsAborted())
+}
+
+func TestRateLimit_UnknownAPIKeysShareIPBucket(t *testing.T) {
+	useAPIKeys(t)
+	config.RateLimit = rateLimitConfig{
+		Rules: []rateLimitRule{{Route: "ServiceA/*", Key: "apikey", Rate: 0.5, Burst: 1}},
+	}
+	buckets = newMemoryBucketStore()
+
+	ctx := newRateLimitContext("made-up.key")
+	rateLimit(context.Background(), ctx)
+	assert.False(t, ctx.IsAborted())
+
+	ctx = newRateLimitContext("another.key")
+	rateLimit(context.Background(), ctx)
+	assert.True(t, ctx.IsAborted(), "unknown keys are limited by client IP")
+
+	key, ok := rateLimitKey(newRateLimitContext("made-up.key"), 0, config.RateLimit.Rules[0], "ServiceA", "methodA")
+	assert.True(t, ok)
+	assert.NotContains(t, key, "made-up.key")
+}
+
+func TestRateLimit_UnmatchedRoute(t *testing.T) {
+	config = &gatewayConfig{RateLimit: rateLimitConfig{
+		Rules: []rateLimitRule{{Route: "ServiceB/*", Key: "route", Rate: 1, Burst: 1}},
+	}}
+	buckets = newMemoryBucketStore()
+	defer func() { config = &gatewayConfig{} }()
+
+	for i := 0; i < 3; i++ {
+		ctx := newRateLimitContext("")
+		rateLimit(context.Background(), ctx)
+		assert.False(t, ctx.IsAborted())
+		assert.Empty(t, ctx.Response.Header.Peek("X-RateLimit-Limit"))
+	}
+}
+
+// bucketKeys returns the keys of the buckets of store from the most to the least recently used.
+func bucketKeys(store *memoryBucketStore) []string {
+	var keys []string
+	for elem := store.order.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*memoryBucket).key)
+	}
+	return keys
+}
+
+func TestMemoryBucketStore_Bounded(t *testing.T) {
+	store := newMemoryBucketStore()
+	store.maxBuckets = 2
+	c := context.Background()
+	now := time.Now()
+	rule := rateLimitRule{Rate: 1, Burst: 2}
+
+	for _, key := range []string{"a", "b", "a", "c"} {
+		_, err := store.take(c, key, rule, now)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"c", "a"}, bucketKeys(store), "the most recently used buckets are kept")
+
+	zeroBurst := rateLimitRule{Rate: 1}
+	_, err := store.take(c, "d", zeroBurst, now)
+	assert.NoError(t, err)
+	_, err = store.take(c, "e", rule, now.Add(2*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"e"}, bucketKeys(store), "buckets full again are evicted, including those of rules without burst")
+}
+
+func TestCheckRateLimitConfig_DistributedNeedsSharedStore(t *testing.T) {
+	assert.NoError(t, checkRateLimitConfig(rateLimitConfig{}))
+	assert.Error(t, checkRateLimitConfig(rateLimitConfig{Distributed: true}))
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/cloudwego/hertz/pkg/protocol/http1/resp"
+)
+
+// The defaults of the subscriptions: the interval between polls, the shortest interval a client may request,
+// the time after which a comment keeps an unchanged stream alive and the subscriptions open at once.
+const (
+	defaultSSEInterval      = 5 * time.Second
+	defaultSSEMinInterval   = time.Second
+	defaultSSEKeepAlive     = 15 * time.Second
+	defaultSSESubscriptions = 1000
+)
+
+// sseConfig configures the subscriptions streamed as Server-Sent Events. A subscription polls its method every
+// Interval, or the interval requested by the client but no shorter than MinInterval. A comment is sent when nothing
+// was sent for KeepAlive, so that proxies keep the stream open and closed clients are noticed.
+// At most MaxSubscriptions are open at once.
+type sseConfig struct {
+	Interval         duration `json:"interval"`
+	MinInterval      duration `json:"minInterval"`
+	KeepAlive        duration `json:"keepAlive"`
+	MaxSubscriptions int      `json:"maxSubscriptions"`
+}
+
+// sseSettings returns the configured interval, minimum interval, keep-alive and maximum of subscriptions,
+// or their defaults.
+func sseSettings() (time.Duration, time.Duration, time.Duration, int) {
+	interval, minInterval, keepAlive := time.Duration(config.SSE.Interval), time.Duration(config.SSE.MinInterval), time.Duration(config.SSE.KeepAlive)
+	maxSubscriptions := config.SSE.MaxSubscriptions
+	if minInterval <= 0 {
+		minInterval = defaultSSEMinInterval
+	}
+	if interval <= 0 {
+		interval = defaultSSEInterval
+	}
+	if interval < minInterval {
+		interval = minInterval
+	}
+	if keepAlive <= 0 {
+		keepAlive = defaultSSEKeepAlive
+	}
+	if maxSubscriptions <= 0 {
+		maxSubscriptions = defaultSSESubscriptions
+	}
+	return interval, minInterval, keepAlive, maxSubscriptions
+}
+
+// subscription is an open subscription, woken to poll its method before its interval elapses.
+type subscription struct {
+	route string
+	wake  chan struct{}
+}
+
+// subscriptionRegistry holds the open subscriptions by the service and method they poll.
+type subscriptionRegistry struct {
+	mu     sync.Mutex
+	routes map[string]map[*subscription]struct{}
+	open   int
+}
+
+var subscriptions = &subscriptionRegistry{routes: map[string]map[*subscription]struct{}{}}
+
+// add opens a subscription to method of serviceName. It returns false if max subscriptions are already open.
+func (r *subscriptionRegistry) add(serviceName, method string, max int) (*subscription, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.open >= max {
+		return nil, false
+	}
+	s := &subscription{route: serviceName + "/" + method, wake: make(chan struct{}, 1)}
+	if r.routes[s.route] == nil {
+		r.routes[s.route] = map[*subscription]struct{}{}
+	}
+	r.routes[s.route][s] = struct{}{}
+	r.open++
+	return s, true
+}
+
+// remove closes the subscription s.
+func (r *subscriptionRegistry) remove(s *subscription) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.routes[s.route], s)
+	if len(r.routes[s.route]) == 0 {
+		delete(r.routes, s.route)
+	}
+	r.open--
+}
+
+// notify wakes the subscriptions to method of serviceName to poll it now, and returns how many were woken.
+func (r *subscriptionRegistry) notify(serviceName, method string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for s := range r.routes[serviceName+"/"+method] {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	return len(r.routes[serviceName+"/"+method])
+}
+
+// sseEvent formats an event of the stream with its ID, type and data, which must fit on a single line.
+func sseEvent(id int, event string, data []byte) []byte {
+	return []byte(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", id, event, data))
+}
+
+// pollEvent makes the call to method of serviceName with body through the gateway policies and returns the event
+// type and data reporting its outcome: "update" and the compact JSON response, or "error" and the status and message.
+func pollEvent(c context.Context, origin *callOrigin, suffix, serviceName, method string, body []byte) (string, []byte) {
+	result := resultOf(origin.dispatch(c, suffix, serviceName, method, body))
+	if result.Error == "" && result.Body != nil {
+		data, err := compactJSON(result.Body)
+		if err == nil {
+			return "update", data
+		}
+	}
+	data, _ := json.Marshal(webSocketError{Status: result.Status, Message: result.Error})
+	return "error", data
+}
+
+// compactJSON returns the JSON value data without insignificant spaces, on a single line.
+func compactJSON(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	if err := json.Compact(&b, data); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// subscribe streams the outcome of a method as Server-Sent Events, polling the method of the service and method
+// query parameters with the JSON request of the body parameter every interval parameter, or the configured interval.
+// The calls are made through the gateway policies with the headers of the request, like the calls of a batch request,
+// and an event is only sent when the outcome changes: "update" with the JSON response or "error" with the status and
+// message of the failure. A backend may have the subscriptions poll at once through notifySubscriptions.
+// The stream ends when the client disconnects, noticed at the latest on the next keep-alive.
+func subscribe(c context.Context, ctx *app.RequestContext) {
+	serviceName, method := ctx.Query("service"), ctx.Query("method")
+	if serviceName == "" || method == "" {
+		setErrorClass(ctx, "invalid_request")
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.String(consts.StatusBadRequest, "Invalid subscription, service and method required")
+		return
+	}
+	body := []byte(ctx.Query("body"))
+	if len(body) == 0 {
+		body = []byte("{}")
+	}
+	if !json.Valid(body) {
+		setErrorClass(ctx, "invalid_request")
+		ctx.SetStatusCode(http.StatusBadRequest)
+		ctx.String(consts.StatusBadRequest, "Invalid JSON data")
+		return
+	}
+
+	interval, minInterval, keepAlive, maxSubscriptions := sseSettings()
+	if requested := ctx.Query("interval"); requested != "" {
+		d, err := time.ParseDuration(requested)
+		if err != nil || d <= 0 {
+			setErrorClass(ctx, "invalid_request")
+			ctx.SetStatusCode(http.StatusBadRequest)
+			ctx.String(consts.StatusBadRequest, "Invalid interval")
+			return
+		}
+		interval = d
+		if interval < minInterval {
+			interval = minInterval
+		}
+	}
+
+	sub, ok := subscriptions.add(serviceName, method, maxSubscriptions)
+	if !ok {
+		setErrorClass(ctx, "overloaded")
+		ctx.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.String(consts.StatusServiceUnavailable, "Too many subscriptions")
+		return
+	}
+	defer subscriptions.remove(sub)
+
+	origin := newCallOrigin(c, ctx)
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	ctx.Response.Header.Set("X-Accel-Buffering", "no")
+	ctx.Response.HijackWriter(resp.NewChunkedBodyWriter(&ctx.Response, ctx.GetWriter()))
+
+	send := func(data []byte) bool {
+		_, err := ctx.Write(data)
+		return err == nil && ctx.Flush() == nil
+	}
+	if !send([]byte("retry: " + strconv.FormatInt(interval.Milliseconds(), 10) + "\n\n")) {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	keepAliveTicker := time.NewTicker(keepAlive)
+	defer keepAliveTicker.Stop()
+	lastSent := time.Now()
+	// wait waits for the next poll, keeping the stream alive meanwhile. It returns false if the client is gone.
+	wait := func() bool {
+		for {
+			select {
+			case <-ticker.C:
+				return connActive(ctx)
+			case <-sub.wake:
+				return connActive(ctx)
+			case <-keepAliveTicker.C:
+				if !connActive(ctx) {
+					return false
+				}
+				if time.Since(lastSent) < keepAlive {
+					continue
+				}
+				if !send([]byte(": keep-alive\n\n")) {
+					return false
+				}
+				lastSent = time.Now()
+			}
+		}
+	}
+
+	var last []byte
+	for polls := 1; ; polls++ {
+		event, data := pollEvent(c, origin, strconv.Itoa(polls), serviceName, method, body)
+		if current := append([]byte(event+" "), data...); string(current) != string(last) {
+			if !send(sseEvent(polls, event, data)) {
+				return
+			}
+			last, lastSent = current, time.Now()
+		}
+		if !wait() {
+			return
+		}
+	}
+}
+
+// connActive reports whether the connection of ctx is still open, as far as the transport tells.
+func connActive(ctx *app.RequestContext) bool {
+	if conn, ok := ctx.GetConn().(interface{ IsActive() bool }); ok {
+		return conn.IsActive()
+	}
+	return true
+}
+
+// notifySubscriptions has the open subscriptions to the method of the service path parameters poll it now,
+// for backends to push their changes, and answers with how many were notified.
+func notifySubscriptions(c context.Context, ctx *app.RequestContext) {
+	notified := subscriptions.notify(ctx.Param("service"), ctx.Param("method"))
+	ctx.JSON(consts.StatusOK, map[string]int{"notified": notified})
+}
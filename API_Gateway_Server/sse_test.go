@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/cloudwego/hertz/pkg/route/param"
+	"github.com/stretchr/testify/assert"
+)
+
+// useSubscriptionServer serves the subscriptions to ServiceA and ServiceB, whose calls are answered with respond,
+// and returns the address of the server.
+func useSubscriptionServer(t *testing.T, cfg sseConfig, respond func(c context.Context, method, request string) (string, error)) string {
+	useFakeBackend(t, respond)
+	config.SSE = cfg
+	return startTestServer(t, "/_subscribe", subscribe)
+}
+
+// sseStream reads the events of a subscription.
+type sseStream struct {
+	reader *bufio.Reader
+}
+
+// openSubscription subscribes to the server at addr with the query parameters.
+func openSubscription(t *testing.T, addr string, query url.Values) *sseStream {
+	resp, err := http.Get("http://" + addr + "/_subscribe?" + query.Encode())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = resp.Body.Close()
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return &sseStream{reader: bufio.NewReader(resp.Body)}
+}
+
+// next returns the ID, type and data of the next event, skipping comments and the retry field.
+func (s *sseStream) next(t *testing.T) (string, string, string) {
+	fields := map[string]string{}
+	for {
+		line, err := s.reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return "", "", ""
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && fields["event"] != "" {
+			return fields["id"], fields["event"], fields["data"]
+		}
+		if name, value, ok := strings.Cut(line, ": "); ok && name != "" {
+			fields[name] = value
+		}
+	}
+}
+
+func subscribeCall(query url.Values) *app.RequestContext {
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodGet, "/_subscribe?"+query.Encode(), nil),
+	}
+	subscribe(context.Background(), ctx)
+	return ctx
+}
+
+func TestSubscribe_EmitsOnlyChanges(t *testing.T) {
+	var calls int32
+	var received atomic.Value
+	addr := useSubscriptionServer(t, sseConfig{Interval: duration(10 * time.Millisecond), MinInterval: duration(time.Millisecond), KeepAlive: duration(50 * time.Millisecond)},
+		func(_ context.Context, method, request string) (string, error) {
+			received.Store(method + " " + request)
+			switch n := atomic.AddInt32(&calls, 1); {
+			case n <= 2:
+				return `{"message": "v1"}`, nil
+			case n <= 4:
+				return `{"message": "v2"}`, nil
+			}
+			return "", errors.New("backend down")
+		})
+
+	stream := openSubscription(t, addr, url.Values{"service": {"ServiceA"}, "method": {"methodA"}, "body": {`{"userId": "1"}`}})
+	id, event, data := stream.next(t)
+	assert.Equal(t, []string{"1", "update", `{"message":"v1"}`}, []string{id, event, data})
+	assert.Equal(t, `methodA {"userId": "1"}`, received.Load())
+
+	id, event, data = stream.next(t)
+	assert.Equal(t, []string{"3", "update", `{"message":"v2"}`}, []string{id, event, data}, "the unchanged response is not sent")
+
+	id, event, data = stream.next(t)
+	assert.Equal(t, []string{"5", "error", `{"status":500,"message":"Error making generic call"}`}, []string{id, event, data})
+}
+
+func TestSubscribe_PollsWhenNotified(t *testing.T) {
+	var calls int32
+	addr := useSubscriptionServer(t, sseConfig{Interval: duration(time.Hour), KeepAlive: duration(50 * time.Millisecond)}, func(context.Context, string, string) (string, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return `{"message": "before"}`, nil
+		}
+		return `{"message": "after"}`, nil
+	})
+
+	stream := openSubscription(t, addr, url.Values{"service": {"ServiceA"}, "method": {"methodA"}})
+	_, _, data := stream.next(t)
+	assert.Equal(t, `{"message":"before"}`, data)
+
+	ctx := &app.RequestContext{}
+	ctx.Params = param.Params{{Key: "service", Value: "ServiceA"}, {Key: "method", Value: "methodA"}}
+	notifySubscriptions(context.Background(), ctx)
+	assert.JSONEq(t, `{"notified": 1}`, string(ctx.Response.Body()))
+
+	_, _, data = stream.next(t)
+	assert.Equal(t, `{"message":"after"}`, data)
+}
+
+func TestSubscribe_InvalidSubscription(t *testing.T) {
+	addr := useSubscriptionServer(t, sseConfig{MaxSubscriptions: 1, KeepAlive: duration(50 * time.Millisecond)}, func(context.Context, string, string) (string, error) {
+		return `{"message": "hi"}`, nil
+	})
+
+	ctx := subscribeCall(url.Values{"service": {"ServiceA"}})
+	assert.Equal(t, http.StatusBadRequest, ctx.Response.StatusCode())
+	ctx = subscribeCall(url.Values{"service": {"ServiceA"}, "method": {"methodA"}, "body": {"{"}})
+	assert.Equal(t, "Invalid JSON data", string(ctx.Response.Body()))
+	ctx = subscribeCall(url.Values{"service": {"ServiceA"}, "method": {"methodA"}, "interval": {"soon"}})
+	assert.Equal(t, "Invalid interval", string(ctx.Response.Body()))
+
+	stream := openSubscription(t, addr, url.Values{"service": {"ServiceA"}, "method": {"methodA"}})
+	stream.next(t)
+	ctx = subscribeCall(url.Values{"service": {"ServiceA"}, "method": {"methodB"}})
+	assert.Equal(t, http.StatusServiceUnavailable, ctx.Response.StatusCode())
+}
+
+func TestSSESettings(t *testing.T) {
+	useFakeBackend(t, nil)
+	interval, minInterval, keepAlive, maxSubscriptions := sseSettings()
+	assert.Equal(t, []time.Duration{defaultSSEInterval, defaultSSEMinInterval, defaultSSEKeepAlive}, []time.Duration{interval, minInterval, keepAlive})
+	assert.Equal(t, defaultSSESubscriptions, maxSubscriptions)
+
+	config.SSE = sseConfig{Interval: duration(time.Millisecond), MinInterval: duration(time.Second)}
+	interval, _, _, _ = sseSettings()
+	assert.Equal(t, time.Second, interval, "the interval is no shorter than the minimum")
+}
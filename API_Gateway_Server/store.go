@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// memoryKVMaxEntries is the number of entries an in-process kvStore holds before it refuses new keys.
+	memoryKVMaxEntries = 1 << 20
+	// memoryKVSweepInterval is the minimum time between two sweeps of the expired entries of an in-process kvStore.
+	memoryKVSweepInterval = time.Minute
+	// memoryKVSweepSample is the number of entries checked for expiry when a full in-process kvStore stores a new key
+	// between two sweeps.
+	memoryKVSweepSample = 32
+)
+
+var sharedStore kvStore = newMemoryKV()
+
+var errStoreFull = errors.New("key-value store full")
+
+// kvStore is the key-value store of the state that gateway instances would have to coordinate, such as nonces,
+// quotas and idempotency records. Only the in-process memoryKV is available, so this state is kept by each
+// gateway instance on its own; an external store such as Redis or etcd may be plugged in as sharedStore.
+type kvStore interface {
+	// Get returns the value stored at key and whether it exists.
+	Get(c context.Context, key string) ([]byte, bool, error)
+	// CompareAndSwap stores value at key with the given ttl only if the current
+	// value equals old, where a nil old requires key to be absent.
+	// It reports whether the value was stored.
+	CompareAndSwap(c context.Context, key string, old, value []byte, ttl time.Duration) (bool, error)
+	// Delete removes the value stored at key, if any.
+	Delete(c context.Context, key string) error
+}
+
+// memoryKV is an in-process kvStore holding at most maxEntries entries. Expired entries are swept at most once
+// per sweepInterval as values are stored, so that the keys never read again do not accumulate.
+type memoryKV struct {
+	mu            sync.Mutex
+	entries       map[string]kvEntry
+	maxEntries    int
+	sweepInterval time.Duration
+	swept         time.Time
+}
+
+type kvEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// newMemoryKV creates an empty in-process kvStore.
+func newMemoryKV() *memoryKV {
+	return &memoryKV{
+		entries:       make(map[string]kvEntry),
+		maxEntries:    memoryKVMaxEntries,
+		sweepInterval: memoryKVSweepInterval,
+		swept:         time.Now(),
+	}
+}
+
+func (e kvEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// sweep removes the expired entries, all of them if the last sweep is older than m.sweepInterval, or else up to
+// memoryKVSweepSample of them when the store is full. The caller must hold m.mu.
+func (m *memoryKV) sweep(now time.Time) {
+	if now.Sub(m.swept) >= m.sweepInterval {
+		for key, e := range m.entries {
+			if e.expired(now) {
+				delete(m.entries, key)
+			}
+		}
+		m.swept = now
+		return
+	}
+	if len(m.entries) < m.maxEntries {
+		return
+	}
+	checked := 0
+	for key, e := range m.entries {
+		if e.expired(now) {
+			delete(m.entries, key)
+		}
+		checked++
+		if checked == memoryKVSweepSample {
+			return
+		}
+	}
+}
+
+// lookup returns the live entry at key, deleting it if it has expired.
+// The caller must hold m.mu.
+func (m *memoryKV) lookup(key string) (kvEntry, bool) {
+	e, ok := m.entries[key]
+	if ok && e.expired(time.Now()) {
+		delete(m.entries, key)
+		return kvEntry{}, false
+	}
+	return e, ok
+}
+
+// Get returns the value stored at key and whether it exists.
+func (m *memoryKV) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.lookup(key)
+	return e.value, ok, nil
+}
+
+// CompareAndSwap stores value at key only if the current value equals old.
+// A zero ttl keeps the value until it is replaced.
+// It returns errStoreFull if key is new and the store holds maxEntries live entries.
+func (m *memoryKV) CompareAndSwap(_ context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.sweep(now)
+	e, ok := m.lookup(key)
+	if old == nil && ok || old != nil && (!ok || !bytes.Equal(e.value, old)) {
+		return false, nil
+	}
+	if !ok && len(m.entries) >= m.maxEntries {
+		return false, errStoreFull
+	}
+	var expires time.Time
+	if ttl > 0 {
+		expires = now.Add(ttl)
+	}
+	m.entries[key] = kvEntry{value: value, expires: expires}
+	return true, nil
+}
+
+// Delete removes the value stored at key, if any.
+func (m *memoryKV) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
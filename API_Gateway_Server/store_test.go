@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryKV_SweepsExpiredKeys(t *testing.T) {
+	kv := newMemoryKV()
+	kv.sweepInterval = 20 * time.Millisecond
+	for i := 0; i < 10; i++ {
+		_, err := kv.CompareAndSwap(context.Background(), "nonce/"+strconv.Itoa(i), nil, []byte("x"), 10*time.Millisecond)
+		assert.NoError(t, err)
+	}
+	_, err := kv.CompareAndSwap(context.Background(), "kept", nil, []byte("x"), 0)
+	assert.NoError(t, err)
+	assert.Len(t, kv.entries, 11)
+
+	time.Sleep(30 * time.Millisecond)
+	_, err = kv.CompareAndSwap(context.Background(), "other", nil, []byte("x"), 0)
+	assert.NoError(t, err)
+	assert.Len(t, kv.entries, 2, "the expired keys are removed without being read")
+}
+
+func TestMemoryKV_RefusesNewKeysWhenFull(t *testing.T) {
+	kv := newMemoryKV()
+	kv.maxEntries = 2
+	c := context.Background()
+	for _, key := range []string{"a", "b"} {
+		stored, err := kv.CompareAndSwap(c, key, nil, []byte("1"), 0)
+		assert.NoError(t, err)
+		assert.True(t, stored)
+	}
+
+	_, err := kv.CompareAndSwap(c, "c", nil, []byte("1"), 0)
+	assert.ErrorIs(t, err, errStoreFull)
+
+	stored, err := kv.CompareAndSwap(c, "a", []byte("1"), []byte("2"), 0)
+	assert.NoError(t, err, "existing keys are still updated")
+	assert.True(t, stored)
+
+	assert.NoError(t, kv.Delete(c, "b"))
+	stored, err = kv.CompareAndSwap(c, "c", nil, []byte("1"), time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, stored)
+
+	time.Sleep(5 * time.Millisecond)
+	stored, err = kv.CompareAndSwap(c, "d", nil, []byte("1"), 0)
+	assert.NoError(t, err, "expired keys are reclaimed when the store is full")
+	assert.True(t, stored)
+}
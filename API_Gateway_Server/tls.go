@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is the minimum time between two checks of the certificate files for changes.
+const certCheckInterval = time.Second
+
+var serverTLS *tls.Config
+var backendTLS *tls.Config
+
+// tlsConfig enables HTTPS on the gateway listener with the certificate and key of CertFile and KeyFile,
+// reloaded when the files change. With ClientCAFile set, client certificates are verified against
+// its CAs, and required when RequireClientCert is set.
+type tlsConfig struct {
+	CertFile          string `json:"certFile"`
+	KeyFile           string `json:"keyFile"`
+	ClientCAFile      string `json:"clientCAFile"`
+	RequireClientCert bool   `json:"requireClientCert"`
+}
+
+// backendTLSConfig enables TLS on the generic calls to the backends, verifying their certificates
+// against the CAs of CAFile for ServerName, or the host called when ServerName is empty.
+// With CertFile and KeyFile set, the gateway presents their certificate for mTLS.
+type backendTLSConfig struct {
+	CAFile     string `json:"caFile"`
+	CertFile   string `json:"certFile"`
+	KeyFile    string `json:"keyFile"`
+	ServerName string `json:"serverName"`
+}
+
+// certReloader serves a certificate and key read from files, reloading them when the files change.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// newCertReloader creates a certReloader serving the certificate of certFile and keyFile.
+// It returns an error if they cannot be loaded.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.lastModified()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	r.cert, r.modTime, r.checked = &cert, modTime, time.Now()
+	return r, nil
+}
+
+// lastModified returns the latest modification time of the certificate and key files.
+func (r *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// certificate returns the current certificate, reloading it if the files changed since it was loaded.
+// The previous certificate is kept if the files cannot be loaded, such as while they are being replaced.
+func (r *certReloader) certificate() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.checked) < certCheckInterval {
+		return r.cert
+	}
+	r.checked = now
+
+	modTime, err := r.lastModified()
+	if err != nil || modTime.Equal(r.modTime) {
+		return r.cert
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		log.Printf("Failed to reload certificate %s: %v", r.certFile, err)
+		return r.cert
+	}
+	r.cert, r.modTime = &cert, modTime
+	return r.cert
+}
+
+// GetCertificate returns the certificate presented by the gateway listener.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.certificate(), nil
+}
+
+// GetClientCertificate returns the certificate presented to the backends.
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.certificate(), nil
+}
+
+// loadCertPool reads the PEM encoded CA certificates of file.
+// It returns the pool and an error if the file cannot be read or holds no certificate.
+func loadCertPool(file string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificate found in %s", file)
+	}
+	return pool, nil
+}
+
+// newServerTLS creates the TLS configuration of the gateway listener described by cfg.
+// It returns nil if HTTPS is disabled, and an error if the certificates cannot be loaded.
+func newServerTLS(cfg tlsConfig) (*tls.Config, error) {
+	if cfg.CertFile == "" && cfg.KeyFile == "" {
+		return nil, nil
+	}
+	reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if cfg.ClientCAFile != "" {
+		tlsCfg.ClientCAs, err = loadCertPool(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.RequireClientCert {
+			tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if cfg.RequireClientCert {
+		return nil, errors.New("requireClientCert needs a clientCAFile")
+	}
+	return tlsCfg, nil
+}
+
+// newBackendTLS creates the TLS configuration of the generic calls described by cfg.
+// It returns nil if TLS to the backends is disabled, and an error if the certificates cannot be loaded.
+func newBackendTLS(cfg backendTLSConfig) (*tls.Config, error) {
+	if cfg.CAFile == "" && cfg.CertFile == "" && cfg.KeyFile == "" {
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+	var err error
+	if cfg.CAFile != "" {
+		tlsCfg.RootCAs, err = loadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.GetClientCertificate = reloader.GetClientCertificate
+	}
+	return tlsCfg, nil
+}
+
+// tlsDialer is the Kitex dialer opening TLS connections to the backends.
+type tlsDialer struct {
+	cfg *tls.Config
+}
+
+// DialTimeout connects to address and completes the TLS handshake within timeout.
+func (d tlsDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, network, address, d.cfg)
+}
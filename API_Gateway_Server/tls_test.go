@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPKI is a CA with a server and a client certificate issued by it, written to PEM files.
+type testPKI struct {
+	caFile     string
+	serverCert string
+	serverKey  string
+	clientCert string
+	clientKey  string
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	content := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	assert.NoError(t, os.WriteFile(file, content, 0600))
+}
+
+// issueCert writes a certificate for template signed by parent and parentKey, and its key.
+// A nil parent self-signs the certificate.
+func issueCert(t *testing.T, dir, name string, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	writePEM(t, filepath.Join(dir, name+".crt"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, name+".key"), "EC PRIVATE KEY", keyDER)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return cert, key
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	dir := t.TempDir()
+	now := time.Now()
+
+	ca, caKey := issueCert(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	issueCert(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "server"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	issueCert(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "gateway"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	return testPKI{
+		caFile:     filepath.Join(dir, "ca.crt"),
+		serverCert: filepath.Join(dir, "server.crt"),
+		serverKey:  filepath.Join(dir, "server.key"),
+		clientCert: filepath.Join(dir, "client.crt"),
+		clientKey:  filepath.Join(dir, "client.key"),
+	}
+}
+
+// startGateway serves the gateway built as main builds it with serverCfg and returns the address of the server.
+func startGateway(t *testing.T, serverCfg *tls.Config) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	serverTLS = serverCfg
+	t.Cleanup(func() {
+		serverTLS = nil
+	})
+	hz := newGateway(addr)
+	go hz.Spin()
+	t.Cleanup(func() {
+		// The standard transport only returns from Shutdown once the context is done.
+		c, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_ = hz.Shutdown(c)
+	})
+
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			_ = conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return addr
+}
+
+// getMetrics requests the metrics of the gateway at addr over HTTPS with clientCfg.
+func getMetrics(addr string, clientCfg *tls.Config) (*http.Response, error) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}, Timeout: 5 * time.Second}
+	resp, err := client.Get("https://" + addr + "/metrics")
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestTLS_ServesHTTPS(t *testing.T) {
+	pki := newTestPKI(t)
+	serverCfg, err := newServerTLS(tlsConfig{CertFile: pki.serverCert, KeyFile: pki.serverKey})
+	assert.NoError(t, err)
+	addr := startGateway(t, serverCfg)
+
+	clientCfg, err := newBackendTLS(backendTLSConfig{CAFile: pki.caFile})
+	assert.NoError(t, err)
+	resp, err := getMetrics(addr, clientCfg)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.NotNil(t, resp.TLS)
+	}
+
+	_, err = http.Get("http://" + addr + "/metrics")
+	assert.Error(t, err, "plain HTTP is not served")
+}
+
+func TestTLS_MutualAuthentication(t *testing.T) {
+	pki := newTestPKI(t)
+	serverCfg, err := newServerTLS(tlsConfig{
+		CertFile:          pki.serverCert,
+		KeyFile:           pki.serverKey,
+		ClientCAFile:      pki.caFile,
+		RequireClientCert: true,
+	})
+	assert.NoError(t, err)
+	addr := startGateway(t, serverCfg)
+
+	clientCfg, err := newBackendTLS(backendTLSConfig{CAFile: pki.caFile, CertFile: pki.clientCert, KeyFile: pki.clientKey})
+	assert.NoError(t, err)
+	resp, err := getMetrics(addr, clientCfg)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	withoutCert, err := newBackendTLS(backendTLSConfig{CAFile: pki.caFile})
+	assert.NoError(t, err)
+	_, err = getMetrics(addr, withoutCert)
+	assert.Error(t, err)
+
+	untrusted := newTestPKI(t)
+	otherCA, err := newBackendTLS(backendTLSConfig{CAFile: pki.caFile, CertFile: untrusted.clientCert, KeyFile: untrusted.clientKey})
+	assert.NoError(t, err)
+	_, err = getMetrics(addr, otherCA)
+	assert.Error(t, err)
+}
+
+func TestTLS_Disabled(t *testing.T) {
+	cfg, err := newServerTLS(tlsConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	cfg, err = newBackendTLS(backendTLSConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, cfg)
+}
+
+func TestCertReloader_ReloadsChangedFiles(t *testing.T) {
+	pki := newTestPKI(t)
+	reloader, err := newCertReloader(pki.serverCert, pki.serverKey)
+	assert.NoError(t, err)
+	first := reloader.certificate()
+
+	replacement := newTestPKI(t)
+	for from, to := range map[string]string{replacement.serverCert: pki.serverCert, replacement.serverKey: pki.serverKey} {
+		content, err := os.ReadFile(from)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(to, content, 0600))
+		later := time.Now().Add(time.Minute)
+		assert.NoError(t, os.Chtimes(to, later, later))
+	}
+
+	assert.Same(t, first, reloader.certificate(), "files are only checked once per interval")
+	reloader.checked = time.Time{}
+	assert.NotSame(t, first, reloader.certificate())
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "API_Gateway_Server"
+
+var tracer = otel.Tracer(tracerName)
+var propagator = propagation.TraceContext{}
+
+// tracingConfig selects where the spans of the gateway are exported.
+// Exporter is "otlp" to send them over OTLP/HTTP to the collector at Endpoint
+// (default localhost:4318), "stdout" to print them, or empty to disable tracing.
+// SampleRatio is the fraction of new traces sampled, all of them when unset.
+type tracingConfig struct {
+	Exporter    string  `json:"exporter"`
+	Endpoint    string  `json:"endpoint"`
+	SampleRatio float64 `json:"sampleRatio"`
+}
+
+// newTraceExporter creates the span exporter selected by cfg.
+// It returns nil if tracing is disabled, and an error if the exporter is unknown or cannot be created.
+func newTraceExporter(c context.Context, cfg tracingConfig) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case "":
+		return nil, nil
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		opts := []otlptracehttp.Option{otlptracehttp.WithInsecure()}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		return otlptracehttp.New(c, opts...)
+	}
+	return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+}
+
+// newTracerProvider creates a tracer provider exporting the spans of the gateway to exporter.
+func newTracerProvider(exporter sdktrace.SpanExporter, sampleRatio float64) *sdktrace.TracerProvider {
+	if sampleRatio <= 0 {
+		sampleRatio = 1
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(tracerName))),
+	)
+}
+
+// initTracing installs the global tracer provider described by cfg.
+// It returns a function flushing and stopping the provider, and an error if the exporter cannot be created.
+func initTracing(c context.Context, cfg tracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+
+	exporter, err := newTraceExporter(c, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	tp := newTracerProvider(exporter, cfg.SampleRatio)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// requestHeaderCarrier adapts a Hertz request header to a propagation.TextMapCarrier.
+type requestHeaderCarrier struct {
+	header *protocol.RequestHeader
+}
+
+func (h requestHeaderCarrier) Get(key string) string {
+	return h.header.Get(key)
+}
+
+func (h requestHeaderCarrier) Set(key, value string) {
+	h.header.Set(key, value)
+}
+
+func (h requestHeaderCarrier) Keys() []string {
+	var keys []string
+	h.header.VisitAll(func(k, _ []byte) {
+		keys = append(keys, string(k))
+	})
+	return keys
+}
+
+// metainfoCarrier adapts the Kitex transport metadata of ctx to a propagation.TextMapCarrier.
+// Values set on it are sent to the backend in the TTHeader of the next call made with ctx.
+type metainfoCarrier struct {
+	ctx context.Context
+}
+
+func (m *metainfoCarrier) Get(key string) string {
+	v, _ := metainfo.GetValue(m.ctx, key)
+	return v
+}
+
+func (m *metainfoCarrier) Set(key, value string) {
+	m.ctx = metainfo.WithValue(m.ctx, key, value)
+}
+
+func (m *metainfoCarrier) Keys() []string {
+	var keys []string
+	for k := range metainfo.GetAllValues(m.ctx) {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// startRequestSpan starts the server span of the request in ctx,
+// continuing the trace given by its traceparent header if any.
+func startRequestSpan(c context.Context, ctx *app.RequestContext) (context.Context, trace.Span) {
+	c = propagator.Extract(c, requestHeaderCarrier{&ctx.Request.Header})
+	return tracer.Start(c, string(ctx.Path()),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPMethod(string(ctx.Method())),
+			semconv.HTTPTarget(string(ctx.Path())),
+		),
+	)
+}
+
+// finishRequestSpan records the response status of ctx on span and ends it.
+func finishRequestSpan(span trace.Span, ctx *app.RequestContext) {
+	code := ctx.Response.StatusCode()
+	span.SetAttributes(semconv.HTTPStatusCode(code))
+	if code >= consts.StatusInternalServerError {
+		span.SetStatus(codes.Error, string(ctx.Response.Body()))
+	}
+	span.End()
+}
+
+// startCallSpan starts the client span of a generic call to method of serviceName.
+// The returned context carries the span context in its transport metadata for the backend.
+func startCallSpan(c context.Context, serviceName, method string) (context.Context, trace.Span) {
+	c, span := tracer.Start(c, "generic_call",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("kitex"),
+			semconv.RPCService(serviceName),
+			semconv.RPCMethod(method),
+		),
+	)
+	carrier := &metainfoCarrier{ctx: c}
+	propagator.Inject(c, carrier)
+	return carrier.ctx, span
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// setSpanRoute names span after the requested route.
+func setSpanRoute(span trace.Span, serviceName, method string) {
+	span.SetName(serviceName + "/" + method)
+	span.SetAttributes(attribute.String("gateway.service", serviceName), attribute.String("gateway.method", method))
+}
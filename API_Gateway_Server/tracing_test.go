@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+const testTraceparent = "00-" + testTraceID + "-00f067aa0ba902b7-01"
+
+var spanOutput syncBuffer
+var tracingOnce sync.Once
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// useStdoutTracing exports the spans of the tests synchronously to spanOutput.
+func useStdoutTracing(t *testing.T) {
+	tracingOnce.Do(func() {
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(&spanOutput))
+		assert.NoError(t, err)
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	})
+}
+
+func TestNewTraceExporter(t *testing.T) {
+	exporter, err := newTraceExporter(context.Background(), tracingConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, exporter)
+
+	exporter, err = newTraceExporter(context.Background(), tracingConfig{Exporter: "stdout"})
+	assert.NoError(t, err)
+	assert.NotNil(t, exporter)
+
+	_, err = newTraceExporter(context.Background(), tracingConfig{Exporter: "zipkin"})
+	assert.Error(t, err)
+}
+
+func TestDecode_ContinuesIncomingTrace(t *testing.T) {
+	useStdoutTracing(t)
+
+	ctx := &app.RequestContext{
+		Request: *protocol.NewRequest(http.MethodGet, "/ServiceA/methodA", nil),
+	}
+	ctx.Request.SetHeader("Content-Type", "text/plain")
+	ctx.Request.SetHeader("traceparent", testTraceparent)
+
+	decode(context.Background(), ctx)
+
+	assert.Equal(t, http.StatusBadRequest, ctx.Response.StatusCode())
+	output := spanOutput.String()
+	assert.True(t, strings.Contains(output, `"Name":"parse"`))
+	assert.True(t, strings.Contains(output, testTraceID))
+}
+
+func TestStartCallSpan_PropagatesToMetainfo(t *testing.T) {
+	useStdoutTracing(t)
+
+	ctx := &app.RequestContext{}
+	ctx.Request.SetHeader("traceparent", testTraceparent)
+	c, span := startRequestSpan(context.Background(), ctx)
+	defer span.End()
+
+	callCtx, callSpan := startCallSpan(c, "ServiceA", "methodA")
+	defer callSpan.End()
+
+	traceparent, ok := metainfo.GetValue(callCtx, "traceparent")
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(traceparent, "00-"+testTraceID+"-"))
+	assert.Equal(t, callSpan.SpanContext().SpanID().String(), strings.Split(traceparent, "-")[2])
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/hertz-contrib/websocket"
+)
+
+// The defaults of the WebSocket bridge: the calls made concurrently over a connection,
+// the interval between pings and the size of the messages read.
+const (
+	defaultWebSocketInFlight     = 16
+	defaultWebSocketPingInterval = 30 * time.Second
+	defaultWebSocketMessageBytes = 1 << 20
+)
+
+// webSocketWriteTimeout bounds the time spent writing a message or a ping to a WebSocket.
+const webSocketWriteTimeout = 10 * time.Second
+
+// accessTokenParam is the query parameter of the bearer token of a WebSocket upgrade,
+// as browsers cannot set the Authorization header of WebSocket requests.
+const accessTokenParam = "access_token"
+
+// The authentications required to upgrade to a WebSocket.
+const (
+	webSocketAuthJWT    = "jwt"
+	webSocketAuthAPIKey = "apiKey"
+)
+
+// webSocketConfig configures the WebSocket bridge. Auth requires a valid bearer token, "jwt", verified for Audience,
+// or a valid API key, "apiKey", to upgrade. Browsers on the origins matching AllowOrigins may connect, and only
+// those on the origin of the gateway when it is empty. Each connection may send Rate calls per second with bursts of
+// Burst, without limit when Rate is 0, and make MaxInFlight calls at once. Pings are sent every PingInterval and the
+// connection is closed if no pong or message arrives within two intervals. Messages are at most MaxMessageBytes.
+type webSocketConfig struct {
+	Auth            string   `json:"auth"`
+	Audience        string   `json:"audience"`
+	AllowOrigins    []string `json:"allowOrigins"`
+	Rate            float64  `json:"rate"`
+	Burst           int      `json:"burst"`
+	MaxInFlight     int      `json:"maxInFlight"`
+	PingInterval    duration `json:"pingInterval"`
+	MaxMessageBytes int64    `json:"maxMessageBytes"`
+}
+
+// webSocketMessage is a call sent over a WebSocket, answered with a reply of the same ID.
+type webSocketMessage struct {
+	ID      json.RawMessage `json:"id"`
+	Service string          `json:"service"`
+	Method  string          `json:"method"`
+	Body    json.RawMessage `json:"body"`
+}
+
+// webSocketReply answers a call sent over a WebSocket with its response or its error.
+type webSocketReply struct {
+	ID       json.RawMessage `json:"id"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    *webSocketError `json:"error,omitempty"`
+}
+
+// webSocketError is the error of a failed call, the HTTP status and message answering it.
+type webSocketError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// checkWebSocketConfig returns an error if the authentication required by cfg is unknown or not configured.
+func checkWebSocketConfig(cfg webSocketConfig) error {
+	switch cfg.Auth {
+	case "":
+		return nil
+	case webSocketAuthJWT:
+		if verifier == nil {
+			return errors.New("webSocket auth jwt requires jwt rules")
+		}
+		return nil
+	case webSocketAuthAPIKey:
+		if keys == nil {
+			return errors.New("webSocket auth apiKey requires an API key file")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown webSocket auth %q", cfg.Auth)
+}
+
+// authenticateWebSocket authenticates the client upgrading ctx to a WebSocket as configured, adding its credentials
+// to the headers of origin so that the calls made over the connection present them to the gateway policies.
+// It aborts ctx with 401 and returns false if the client fails to authenticate.
+func authenticateWebSocket(ctx *app.RequestContext, origin *callOrigin) bool {
+	switch config.WebSocket.Auth {
+	case webSocketAuthJWT:
+		token := bearerToken(ctx)
+		if token == "" {
+			token = ctx.Query(accessTokenParam)
+		}
+		if token == "" {
+			rejectUnauthenticated(ctx, "Missing bearer token")
+			return false
+		}
+		if _, err := verifier.verify(token, config.WebSocket.Audience, time.Now()); err != nil {
+			rejectUnauthenticated(ctx, "Invalid token")
+			return false
+		}
+		origin.header.Set("Authorization", "Bearer "+token)
+	case webSocketAuthAPIKey:
+		presented := presentedAPIKey(ctx)
+		if presented == "" {
+			ctx.AbortWithMsg("Missing API key", http.StatusUnauthorized)
+			setErrorClass(ctx, "unauthenticated")
+			return false
+		}
+		if _, err := lookupAPIKey(keys, presented); err != nil {
+			ctx.AbortWithMsg("Invalid API key", http.StatusUnauthorized)
+			setErrorClass(ctx, "unauthenticated")
+			return false
+		}
+		origin.header.Set(apiKeyHeader, presented)
+	}
+	return true
+}
+
+// allowsWebSocketOrigin reports whether the browser upgrading ctx may connect, from an allowed origin.
+func allowsWebSocketOrigin(ctx *app.RequestContext) bool {
+	origin := string(ctx.GetHeader("Origin"))
+	if origin == "" {
+		return true
+	}
+	if len(config.WebSocket.AllowOrigins) == 0 {
+		return origin == "http://"+string(ctx.Host()) || origin == "https://"+string(ctx.Host())
+	}
+	rule := corsRule{AllowOrigins: config.WebSocket.AllowOrigins}
+	return rule.allowsOrigin(origin)
+}
+
+// webSocketSession is a WebSocket connection over which calls are made on behalf of the client that opened it.
+type webSocketSession struct {
+	conn   *websocket.Conn
+	origin *callOrigin
+	rule   rateLimitRule
+	bucket *bucketState
+	calls  int
+
+	writeMu  sync.Mutex
+	lastSeen atomic.Int64
+}
+
+// seen records that the client showed it is alive, sending a message or a pong.
+func (s *webSocketSession) seen() {
+	s.lastSeen.Store(time.Now().UnixNano())
+}
+
+// allow reports whether the client may make another call now, within the rate of the connection.
+func (s *webSocketSession) allow(now time.Time) bool {
+	if s.rule.Rate <= 0 {
+		return true
+	}
+	next, d := takeToken(s.bucket, s.rule, now)
+	s.bucket = &next
+	return d.allowed
+}
+
+// reply writes r to the connection, one message at a time.
+func (s *webSocketSession) reply(r webSocketReply) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_ = s.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+	_ = s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// call makes the call of msg through the gateway policies and returns the reply answering it.
+func (s *webSocketSession) call(c context.Context, suffix string, msg webSocketMessage) webSocketReply {
+	body := msg.Body
+	if len(body) == 0 {
+		body = json.RawMessage("{}")
+	}
+	result := resultOf(s.origin.dispatch(c, suffix, msg.Service, msg.Method, body))
+	if result.Error != "" || result.Body == nil {
+		return webSocketReply{ID: msg.ID, Error: &webSocketError{Status: result.Status, Message: result.Error}}
+	}
+	return webSocketReply{ID: msg.ID, Response: result.Body}
+}
+
+// ping pings the client every interval until done is closed or a ping fails.
+// It closes the connection if the client was not seen for two intervals.
+func (s *webSocketSession) ping(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, s.lastSeen.Load())) > 2*interval {
+				_ = s.conn.Close()
+				return
+			}
+			if s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(webSocketWriteTimeout)) != nil {
+				return
+			}
+		}
+	}
+}
+
+// serve reads the calls sent over the connection and makes them concurrently, at most maxInFlight at once,
+// until the connection fails or closes. Invalid calls and those beyond the rate of the connection are answered
+// with an error without being made. It then waits for the calls in flight before closing the connection.
+func (s *webSocketSession) serve(c context.Context, cfg webSocketConfig) {
+	maxInFlight, interval, maxBytes := cfg.MaxInFlight, time.Duration(cfg.PingInterval), cfg.MaxMessageBytes
+	if maxInFlight <= 0 {
+		maxInFlight = defaultWebSocketInFlight
+	}
+	if interval <= 0 {
+		interval = defaultWebSocketPingInterval
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultWebSocketMessageBytes
+	}
+
+	s.conn.SetReadLimit(maxBytes)
+	s.seen()
+	s.conn.SetPongHandler(func(string) error {
+		s.seen()
+		return nil
+	})
+
+	done := make(chan struct{})
+	go s.ping(interval, done)
+
+	inFlight := make(chan struct{}, maxInFlight)
+	var wg sync.WaitGroup
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		s.seen()
+
+		var msg webSocketMessage
+		if json.Unmarshal(data, &msg) != nil {
+			s.reply(webSocketReply{Error: &webSocketError{Status: http.StatusBadRequest, Message: "Invalid JSON data"}})
+			continue
+		}
+		if msg.Service == "" || msg.Method == "" {
+			s.reply(webSocketReply{ID: msg.ID, Error: &webSocketError{Status: http.StatusBadRequest, Message: "Invalid call, service and method required"}})
+			continue
+		}
+		if !s.allow(time.Now()) {
+			s.reply(webSocketReply{ID: msg.ID, Error: &webSocketError{Status: http.StatusTooManyRequests, Message: "Too many requests"}})
+			continue
+		}
+
+		s.calls++
+		suffix := strconv.Itoa(s.calls)
+		inFlight <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-inFlight
+				wg.Done()
+			}()
+			s.reply(s.call(c, suffix, msg))
+		}()
+	}
+
+	close(done)
+	wg.Wait()
+	_ = s.conn.Close()
+}
+
+// serveWebSocket upgrades the request to a WebSocket bridging calls to the services, once the client has
+// authenticated as configured. Each message is a call {id, service, method, body} made through the gateway policies
+// like the calls of a batch request, with the headers of the upgrade request, and answered by a message
+// {id, response} or {id, error} as soon as it completes, so that calls are multiplexed over the connection.
+func serveWebSocket(c context.Context, ctx *app.RequestContext) {
+	origin := newCallOrigin(c, ctx)
+	if !authenticateWebSocket(ctx, origin) {
+		return
+	}
+
+	cfg := config.WebSocket
+	upgrader := websocket.HertzUpgrader{CheckOrigin: allowsWebSocketOrigin}
+	err := upgrader.Upgrade(ctx, func(conn *websocket.Conn) {
+		s := &webSocketSession{conn: conn, origin: origin, rule: rateLimitRule{Rate: cfg.Rate, Burst: cfg.Burst}}
+		s.serve(c, cfg)
+	})
+	if err != nil {
+		setErrorClass(ctx, "invalid_request")
+	}
+}
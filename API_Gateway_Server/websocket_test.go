@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// useWebSocketServer serves the WebSocket bridge to ServiceA and ServiceB, whose calls are answered with respond,
+// and returns the address of the server.
+func useWebSocketServer(t *testing.T, cfg webSocketConfig, respond func(c context.Context, method, request string) (string, error)) string {
+	useFakeBackend(t, respond)
+	config.WebSocket = cfg
+	return startTestServer(t, "/_ws", serveWebSocket)
+}
+
+// startTestServer serves handler on GET path and returns the address of the server.
+// The configuration must not change while the server runs.
+func startTestServer(t *testing.T, path string, handler app.HandlerFunc) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	hz := server.New(server.WithHostPorts(addr), server.WithDisablePrintRoute(true))
+	hz.NoHijackConnPool = true
+	hz.GET(path, handler)
+	go hz.Spin()
+	t.Cleanup(func() {
+		_ = hz.Shutdown(context.Background())
+	})
+
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			_ = conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return addr
+}
+
+// wsClient is a minimal WebSocket client sending text messages and reading frames.
+type wsClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialWebSocket upgrades a connection to addr with the path and query of target.
+// It returns the response of the server if it refuses the upgrade.
+func dialWebSocket(t *testing.T, addr, target string) (*wsClient, *http.Response) {
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	_, err = io.WriteString(conn, "GET "+target+" HTTP/1.1\r\nHost: "+addr+"\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	assert.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	assert.NoError(t, err)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp
+	}
+	return &wsClient{conn: conn, reader: reader}, resp
+}
+
+// send sends the text message, masked with a zero key.
+func (c *wsClient) send(t *testing.T, message string) {
+	frame := []byte{0x81}
+	if len(message) < 126 {
+		frame = append(frame, 0x80|byte(len(message)))
+	} else {
+		frame = append(frame, 0x80|126, byte(len(message)>>8), byte(len(message)))
+	}
+	frame = append(frame, 0, 0, 0, 0)
+	_, err := c.conn.Write(append(frame, message...))
+	assert.NoError(t, err)
+}
+
+// read returns the opcode and payload of the next frame.
+func (c *wsClient) read() (byte, []byte, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return 0, nil, err
+	}
+	size := uint64(header[1] & 0x7f)
+	switch size {
+	case 126:
+		b := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, b); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(b))
+	case 127:
+		return 0, nil, errors.New("frame too large")
+	}
+	payload := make([]byte, size)
+	_, err := io.ReadFull(c.reader, payload)
+	return header[0] & 0x0f, payload, err
+}
+
+// replies returns the next n replies, keyed by ID, skipping the other frames.
+func (c *wsClient) replies(t *testing.T, n int) map[string]webSocketReply {
+	replies := map[string]webSocketReply{}
+	for len(replies) < n {
+		opcode, payload, err := c.read()
+		if !assert.NoError(t, err) {
+			return replies
+		}
+		if opcode != 0x1 {
+			continue
+		}
+		var reply webSocketReply
+		assert.NoError(t, json.Unmarshal(payload, &reply))
+		replies[string(reply.ID)] = reply
+	}
+	return replies
+}
+
+func TestWebSocket_MultiplexesCalls(t *testing.T) {
+	release := make(chan struct{})
+	addr := useWebSocketServer(t, webSocketConfig{}, func(_ context.Context, method, request string) (string, error) {
+		if method == "methodB" {
+			<-release
+		}
+		if method == "methodC" {
+			return "", errors.New("backend down")
+		}
+		return `{"message": "` + method + `"}`, nil
+	})
+
+	client, resp := dialWebSocket(t, addr, "/_ws")
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	client.send(t, `{"id": "slow", "service": "ServiceB", "method": "methodB", "body": {"message": "hi"}}`)
+	client.send(t, `{"id": 1, "service": "ServiceA", "method": "methodA", "body": {"message": "hi"}}`)
+	client.send(t, `{"id": 2, "service": "ServiceA", "method": "methodC"}`)
+	client.send(t, `{"id": 3, "service": "ServiceA"}`)
+	client.send(t, `not json`)
+
+	replies := client.replies(t, 4)
+	assert.JSONEq(t, `{"message": "methodA"}`, string(replies["1"].Response))
+	assert.Equal(t, &webSocketError{Status: http.StatusInternalServerError, Message: "Error making generic call"}, replies["2"].Error)
+	assert.Equal(t, http.StatusBadRequest, replies["3"].Error.Status)
+	assert.Equal(t, &webSocketError{Status: http.StatusBadRequest, Message: "Invalid JSON data"}, replies["null"].Error)
+
+	close(release)
+	replies = client.replies(t, 1)
+	assert.JSONEq(t, `{"message": "methodB"}`, string(replies[`"slow"`].Response))
+}
+
+func TestWebSocket_RateLimitsConnection(t *testing.T) {
+	addr := useWebSocketServer(t, webSocketConfig{Rate: 0.001, Burst: 2}, func(context.Context, string, string) (string, error) {
+		return `{"message": "hi"}`, nil
+	})
+
+	client, _ := dialWebSocket(t, addr, "/_ws")
+	for i := 1; i <= 3; i++ {
+		client.send(t, `{"id": `+string(rune('0'+i))+`, "service": "ServiceA", "method": "methodA"}`)
+	}
+	replies := client.replies(t, 3)
+	assert.Nil(t, replies["1"].Error)
+	assert.Nil(t, replies["2"].Error)
+	assert.Equal(t, &webSocketError{Status: http.StatusTooManyRequests, Message: "Too many requests"}, replies["3"].Error)
+
+	other, _ := dialWebSocket(t, addr, "/_ws")
+	other.send(t, `{"id": 1, "service": "ServiceA", "method": "methodA"}`)
+	assert.Nil(t, other.replies(t, 1)["1"].Error, "each connection has its own rate")
+}
+
+func TestWebSocket_AuthenticatesUpgrade(t *testing.T) {
+	useFakeBackend(t, func(context.Context, string, string) (string, error) {
+		return `{"message": "hi"}`, nil
+	})
+	store := useAPIKeys(t, "ServiceA/*")
+	config.WebSocket = webSocketConfig{Auth: webSocketAuthAPIKey}
+	key, hash := newAPIKeySecret("abc")
+	assert.NoError(t, store.Put(apiKey{ID: "abc", Hash: hash, Owner: "dashboard"}))
+	addr := startTestServer(t, "/_ws", serveWebSocket)
+
+	_, resp := dialWebSocket(t, addr, "/_ws")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	_, resp = dialWebSocket(t, addr, "/_ws?api_key=abc.wrong")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	client, resp := dialWebSocket(t, addr, "/_ws?api_key="+key)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	client.send(t, `{"id": 1, "service": "ServiceA", "method": "methodA"}`)
+	assert.JSONEq(t, `{"message": "hi"}`, string(client.replies(t, 1)["1"].Response), "the calls present the key of the upgrade")
+}
+
+func TestWebSocket_PingsClient(t *testing.T) {
+	addr := useWebSocketServer(t, webSocketConfig{PingInterval: duration(20 * time.Millisecond)}, nil)
+
+	client, _ := dialWebSocket(t, addr, "/_ws")
+	opcode, _, err := client.read()
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x9), opcode)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for err == nil && time.Now().Before(deadline) {
+		_, _, err = client.read()
+	}
+	assert.Error(t, err, "the connection is closed without pongs")
+}
+
+func TestCheckWebSocketConfig(t *testing.T) {
+	useFakeBackend(t, nil)
+	assert.NoError(t, checkWebSocketConfig(webSocketConfig{}))
+	assert.Error(t, checkWebSocketConfig(webSocketConfig{Auth: webSocketAuthJWT}))
+	assert.Error(t, checkWebSocketConfig(webSocketConfig{Auth: webSocketAuthAPIKey}))
+	assert.Error(t, checkWebSocketConfig(webSocketConfig{Auth: "basic"}))
+}
@@ -7,7 +7,10 @@ import (
 )
 
 // ServiceAImpl implements the last service interface defined in the IDL.
-type ServiceAImpl struct{}
+// Its methodC keeps the message of each user, the resource checked against the if_match preconditions.
+type ServiceAImpl struct {
+	messages resourceStates
+}
 
 type ServiceBImpl struct{}
 
@@ -38,7 +41,7 @@ func (s *ServiceAImpl) MethodC(ctx context.Context, req *api.Request) (resp *api
 		return nil, fmt.Errorf("missing content in JSON body, require userId and message")
 	}
 	msg := fmt.Sprint("User", req.UserId, " Connected to ServiceA, methodC.\nMessage content:", req.Message)
-	return &api.Response{Message: msg}, nil
+	return s.messages.respond(ctx, req.UserId, &api.Response{Message: msg})
 }
 
 // MethodA implements the ServiceBImpl interface.
@@ -2,31 +2,37 @@ package main
 
 import (
 	api "RPC_Server/kitex_gen/api/servicea"
+	"context"
 	"fmt"
 	"log"
 	"net"
 	"sync"
 
+	"github.com/cloudwego/kitex/pkg/transmeta"
 	"github.com/cloudwego/kitex/server"
 )
 
-func serverA(addr *net.TCPAddr) server.Server {
-	svr := api.NewServer(
-		new(ServiceAImpl),
+func serverA(addr *net.TCPAddr, opts ...server.Option) server.Server {
+	opts = append([]server.Option{
 		server.WithServiceAddr(addr),
-	)
+		server.WithMetaHandler(transmeta.ServerTTHeaderHandler),
+		server.WithMiddleware(tracingMiddleware),
+	}, opts...)
+	svr := api.NewServer(new(ServiceAImpl), opts...)
 	return svr
 }
 
-func serverB(addr *net.TCPAddr) server.Server {
-	svr := api.NewServer(
-		new(ServiceBImpl),
+func serverB(addr *net.TCPAddr, opts ...server.Option) server.Server {
+	opts = append([]server.Option{
 		server.WithServiceAddr(addr),
-	)
+		server.WithMetaHandler(transmeta.ServerTTHeaderHandler),
+		server.WithMiddleware(tracingMiddleware),
+	}, opts...)
+	svr := api.NewServer(new(ServiceBImpl), opts...)
 	return svr
 }
 
-func startServer(serviceName string, port int, f func(*net.TCPAddr) server.Server) {
+func startServer(serviceName string, port int, f func(*net.TCPAddr, ...server.Option) server.Server) {
 
 	err1 := registerOnNacos(serviceName, port)
 
@@ -45,7 +51,12 @@ func startServer(serviceName string, port int, f func(*net.TCPAddr) server.Serve
 
 			addr, _ := net.ResolveTCPAddr("tcp", fmt.Sprintf("127.0.0.1:%d", int(port+instanceID)))
 
-			svr := f(addr)
+			opts, err := tlsOptions(addr)
+			if err != nil {
+				log.Fatal("Failed to set up TLS:", err)
+			}
+
+			svr := f(addr, opts...)
 
 			err1 := svr.Run()
 
@@ -60,6 +71,12 @@ func startServer(serviceName string, port int, f func(*net.TCPAddr) server.Serve
 }
 
 func main() {
+	shutdown, err := initTracing(context.Background())
+	if err != nil {
+		log.Fatal("Failed to initialise tracing:", err)
+	}
+	defer shutdown(context.Background())
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 	go startServer("ServiceA", 8080, serverA)
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"RPC_Server/kitex_gen/api"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/kitex/pkg/kerrors"
+)
+
+// ifMatchMetaKey is the metadata key the gateway forwards the If-Match header of a request under.
+const ifMatchMetaKey = "if_match"
+
+// preconditionFailedStatus is the biz status code of the calls failed for their if_match precondition,
+// answered with 412 by the gateway.
+const preconditionFailedStatus = 412
+
+// maxResourceStates is the number of users whose resource state is kept, the least recently updated
+// being forgotten beyond it.
+const maxResourceStates = 10000
+
+// resourceStates keeps the last response of a method to each user, the state of the resource
+// the if_match preconditions of the user are checked against, for up to maxResourceStates users.
+type resourceStates struct {
+	mu     sync.Mutex
+	order  *list.List
+	states map[string]*list.Element
+}
+
+// resourceState is the state of the resource of user.
+type resourceState struct {
+	user string
+	resp *api.Response
+}
+
+// entityTag returns the entity tag of resp as the gateway computes it from the response it serves,
+// the quoted start of the SHA-256 digest of its JSON.
+func entityTag(resp *api.Response) string {
+	body, _ := json.Marshal(map[string]string{"message": resp.Message})
+	digest := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(digest[:16]) + `"`
+}
+
+// matchEntityTag reports whether etag strongly matches one of the entity tags of the if_match value,
+// or the value is "*".
+func matchEntityTag(value, etag string) bool {
+	value = strings.TrimSpace(value)
+	if value == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(value, ",") {
+		if strings.TrimSpace(tag) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// respond makes resp the state of the resource of user and answers with it, if the if_match metadata of ctx,
+// when present, matches the current state. Otherwise the call fails with the precondition failed biz status error.
+func (s *resourceStates) respond(ctx context.Context, user string, resp *api.Response) (*api.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.states == nil {
+		s.order, s.states = list.New(), make(map[string]*list.Element)
+	}
+	elem, exists := s.states[user]
+	if ifMatch, ok := metainfo.GetValue(ctx, ifMatchMetaKey); ok {
+		if !exists || !matchEntityTag(ifMatch, entityTag(elem.Value.(*resourceState).resp)) {
+			return nil, kerrors.NewBizStatusError(preconditionFailedStatus, "precondition failed")
+		}
+	}
+	if exists {
+		elem.Value.(*resourceState).resp = resp
+		s.order.MoveToFront(elem)
+		return resp, nil
+	}
+	if s.order.Len() >= maxResourceStates {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.states, oldest.Value.(*resourceState).user)
+	}
+	s.states[user] = s.order.PushFront(&resourceState{user: user, resp: resp})
+	return resp, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/cloudwego/kitex/pkg/remote/trans/gonet"
+	"github.com/cloudwego/kitex/server"
+)
+
+// tlsOptions returns the options serving the Kitex server at addr over TLS when TLS_CERT_FILE and
+// TLS_KEY_FILE are set, requiring client certificates signed by the CAs of TLS_CLIENT_CA_FILE when set.
+// It returns no option when TLS is disabled, and an error if the certificates cannot be loaded.
+func tlsOptions(addr *net.TCPAddr) ([]server.Option, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if caFile := os.Getenv("TLS_CLIENT_CA_FILE"); caFile != "" {
+		content, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	ln, err := tls.Listen("tcp", addr.String(), cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Netpoll does not support TLS, the TLS connections are served by the go net transport.
+	return []server.Option{
+		server.WithListener(ln),
+		server.WithTransServerFactory(gonet.NewTransServerFactory()),
+		server.WithTransHandlerFactory(gonet.NewSvrTransHandlerFactory()),
+	}, nil
+}
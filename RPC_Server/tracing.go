@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bytedance/gopkg/cloud/metainfo"
+	"github.com/cloudwego/kitex/pkg/endpoint"
+	"github.com/cloudwego/kitex/pkg/rpcinfo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("RPC_Server")
+var propagator = propagation.TraceContext{}
+
+// initTracing installs the tracer provider selected by OTEL_TRACES_EXPORTER:
+// "otlp" exports to the collector at OTEL_EXPORTER_OTLP_ENDPOINT (default localhost:4318),
+// "stdout" prints the spans, and anything else leaves tracing disabled.
+func initTracing(ctx context.Context) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch os.Getenv("OTEL_TRACES_EXPORTER") {
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithInsecure())
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return func(context.Context) error { return nil }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("RPC_Server"))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// metainfoCarrier reads the traceparent sent by the gateway in the TTHeader transport metadata.
+type metainfoCarrier struct {
+	ctx context.Context
+}
+
+func (m metainfoCarrier) Get(key string) string {
+	v, _ := metainfo.GetValue(m.ctx, key)
+	return v
+}
+
+func (m metainfoCarrier) Set(string, string) {}
+
+func (m metainfoCarrier) Keys() []string {
+	var keys []string
+	for k := range metainfo.GetAllValues(m.ctx) {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// tracingMiddleware starts a server span for every call, continuing the trace of the gateway,
+// so that the ctx given to ServiceAImpl and ServiceBImpl carries it.
+func tracingMiddleware(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, req, resp interface{}) error {
+		name := "unknown"
+		if ri := rpcinfo.GetRPCInfo(ctx); ri != nil {
+			name = fmt.Sprintf("%s/%s", ri.Invocation().ServiceName(), ri.Invocation().MethodName())
+		}
+
+		ctx = propagator.Extract(ctx, metainfoCarrier{ctx})
+		ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		if id, ok := metainfo.GetValue(ctx, "request_id"); ok {
+			span.SetAttributes(attribute.String("request_id", id))
+		}
+
+		err := next(ctx, req, resp)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}